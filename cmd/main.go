@@ -2,10 +2,15 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"tgbot/internal/ai"
+	"tgbot/internal/ai/anthropic"
+	"tgbot/internal/ai/fake"
 	"tgbot/internal/ai/openai"
 	"tgbot/internal/config"
 	"tgbot/internal/lib/logger/handlers/fileslog"
@@ -16,8 +21,8 @@ import (
 	"tgbot/internal/maincontroller"
 	"tgbot/internal/store"
 	"tgbot/internal/store/db"
+	"tgbot/internal/updates"
 	"tgbot/migrator"
-	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
@@ -49,12 +54,18 @@ func main() {
 	commandsRu := tgbotapi.NewSetMyCommandsWithScopeAndLanguage(tgbotapi.NewBotCommandScopeDefault(), "ru",
 		tgbotapi.BotCommand{Command: "new", Description: "Начать новый дилог"},
 		tgbotapi.BotCommand{Command: "dialogs", Description: "Список диалогов"},
+		tgbotapi.BotCommand{Command: "persona", Description: "Персона диалога"},
+		tgbotapi.BotCommand{Command: "settings", Description: "Настройки генерации"},
+		tgbotapi.BotCommand{Command: "image", Description: "Сгенерировать изображение"},
 		tgbotapi.BotCommand{Command: "profile", Description: "Ваш профиль"},
 	)
 
 	commandsEn := tgbotapi.NewSetMyCommandsWithScopeAndLanguage(tgbotapi.NewBotCommandScopeDefault(), "en",
 		tgbotapi.BotCommand{Command: "new", Description: "Start new dialog"},
 		tgbotapi.BotCommand{Command: "dialogs", Description: "List of dialogs"},
+		tgbotapi.BotCommand{Command: "persona", Description: "Persona of the dialog"},
+		tgbotapi.BotCommand{Command: "settings", Description: "Generation settings"},
+		tgbotapi.BotCommand{Command: "image", Description: "Generate an image"},
 		tgbotapi.BotCommand{Command: "profile", Description: "Your profile"},
 	)
 	_, _ = bot.Send(commandsEn)
@@ -63,7 +74,11 @@ func main() {
 	bc := context.Background()
 	ctx, cancel := context.WithCancel(bc)
 
-	aiAPI := openai.New("https://api.openai.com/v1", cfg.OpenAiToken, time.Minute)
+	aiProviders, err := newAIProviders(cfg)
+	if err != nil {
+		log.Error("Could not create AI providers", sl.Err(err))
+		return
+	}
 
 	st, err := store.New(dbDriver)
 	if err != nil {
@@ -71,7 +86,8 @@ func main() {
 		return
 	}
 
-	_, err = maincontroller.New(ctx, bot, st, aiAPI, log, cfg.TgAdmin)
+	source := newUpdateSource(bot, cfg, log)
+	mc, err := maincontroller.New(ctx, bot, source, st, aiProviders, log, cfg)
 	if err != nil {
 		log.Error("Could not create main handler", sl.Err(err))
 		return
@@ -82,11 +98,129 @@ func main() {
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT)
 	s := <-c
 	log.Info("Signal received", slog.Attr{Key: "signal", Value: slog.StringValue(s.String())})
+	shutdownCtx, shutdownCancel := context.WithTimeout(bc, cfg.ShutdownTimeout)
+	if err := source.Stop(shutdownCtx); err != nil {
+		log.Error("Could not stop updates", sl.Err(err))
+	}
+	shutdownErr := mc.Shutdown(shutdownCtx)
+	if shutdownErr != nil {
+		log.Error("Requests not finished at shutdown", sl.Err(shutdownErr))
+	}
+	shutdownCancel()
 	cancel()
-	_ = st.Close()
+	// Handlers still running use the store, it is closed by the exit then
+	if shutdownErr == nil {
+		_ = st.Close()
+	}
+	log.Info("Stopped")
 	os.Exit(0)
 }
 
+// newUpdateSource returns the webhook or the polling source of the updates set by the config
+func newUpdateSource(bot *tgbotapi.BotAPI, cfg *config.Config, log *slog.Logger) updates.Source {
+	if cfg.Updates != config.UpdatesWebhook {
+		return updates.NewPolling(bot)
+	}
+	return updates.NewWebhook(bot, updates.WebhookConfig{
+		URL:                cfg.Webhook.URL,
+		Listen:             cfg.Webhook.Listen,
+		Path:               cfg.Webhook.Path,
+		SecretToken:        cfg.Webhook.SecretToken,
+		CertFile:           cfg.Webhook.CertFile,
+		KeyFile:            cfg.Webhook.KeyFile,
+		SelfSigned:         cfg.Webhook.SelfSigned,
+		MaxConnections:     cfg.Webhook.MaxConnections,
+		DropPendingUpdates: cfg.Webhook.DropPendingUpdates,
+	}, log)
+}
+
+// newAIProviders creates the clients of the configured AI providers
+func newAIProviders(cfg *config.Config) (*ai.Registry, error) {
+	transport, err := newAITransport(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	registry := ai.NewRegistry()
+	for name, provider := range cfg.Providers {
+		breaker := ai.NewCircuitBreaker(ai.DefaultBreakerThreshold, ai.DefaultBreakerCooldown)
+		switch provider.Type {
+		case config.ProviderTypeOpenAI:
+			api, err := openai.New(openai.Config{
+				Name:         name,
+				BaseURL:      provider.BaseURL,
+				Token:        provider.Token,
+				Auth:         openai.AuthStyle(provider.Auth),
+				APIVersion:   provider.APIVersion,
+				Proxy:        provider.Proxy,
+				Timeout:      provider.Timeout,
+				Headers:      provider.Headers,
+				Organization: provider.Organization,
+				Project:      provider.Project,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("provider %s: %w", name, err)
+			}
+			if transport != nil {
+				api.WrapTransport(transport)
+			}
+			registry.RegisterChatModel(name, ai.NewResilientChatModel(name, api, ai.DefaultRetryPolicy, breaker))
+			registry.RegisterImageModel(name, api)
+			registry.RegisterSpeechToText(name, api)
+			registry.RegisterTextToSpeech(name, api)
+			registry.RegisterModerator(name, api)
+		case config.ProviderTypeAnthropic:
+			api, err := anthropic.New(anthropic.Config{
+				BaseURL: provider.BaseURL,
+				Token:   provider.Token,
+				Proxy:   provider.Proxy,
+				Timeout: provider.Timeout,
+				Headers: provider.Headers,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("provider %s: %w", name, err)
+			}
+			if transport != nil {
+				api.WrapTransport(transport)
+			}
+			registry.RegisterChatModel(name, ai.NewResilientChatModel(name, api, ai.DefaultRetryPolicy, breaker))
+		}
+	}
+
+	if cfg.Moderation.Moderator == config.ModeratorLocal {
+		moderator, err := ai.NewPatternModerator(cfg.Moderation.Patterns)
+		if err != nil {
+			return nil, err
+		}
+		registry.RegisterModerator(config.ModeratorLocal, moderator)
+	}
+	return registry, nil
+}
+
+// newAITransport returns the wrapper of the provider transports that replaces the providers
+// or records their responses, nil if the providers are called as is
+func newAITransport(cfg *config.Config) (func(next http.RoundTripper) http.RoundTripper, error) {
+	if cfg.AIBackend == config.AIBackendFake {
+		transport, err := fake.NewTransport(fake.Options{
+			FixturesDir: cfg.FakeFixtures,
+			Delay:       cfg.FakeDelay,
+			FailEvery:   cfg.FakeFailEvery,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return transport.Wrap, nil
+	}
+	if cfg.RecordFixtures != "" {
+		recorder, err := fake.NewRecorder(cfg.RecordFixtures)
+		if err != nil {
+			return nil, err
+		}
+		return recorder.Wrap, nil
+	}
+	return nil, nil
+}
+
 func setupMySlog() *slog.Logger {
 	fileHandler := fileslog.NewFileSlogHandler("./data/logs")
 
@@ -0,0 +1,253 @@
+package anthropic
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"tgbot/internal/ai"
+	"time"
+)
+
+const (
+	MessagesEndpoint = "messages"
+	APIVersion       = "2023-06-01"
+	DefaultMaxTokens = 4096
+)
+
+// Thinking budgets of the reasoning efforts, the API requires at least 1024 tokens
+var thinkingBudgets = map[string]int{
+	ai.EffortLow:    1024,
+	ai.EffortMedium: 4096,
+	ai.EffortHigh:   16384,
+}
+
+type Config struct {
+	BaseURL string
+	Token   string
+	Proxy   string
+	Timeout time.Duration
+	// Extra headers sent with every request
+	Headers map[string]string
+}
+
+type Anthropic struct {
+	client http.Client
+	cfg    Config
+}
+
+func New(cfg Config) (*Anthropic, error) {
+	if _, err := url.Parse(cfg.BaseURL); err != nil {
+		return nil, fmt.Errorf("New(): invalid base url: %w", err)
+	}
+	client, err := ai.NewHTTPClient(cfg.Proxy, cfg.Timeout)
+	if err != nil {
+		return nil, fmt.Errorf("New(): %w", err)
+	}
+	return &Anthropic{client: client, cfg: cfg}, nil
+}
+
+// WrapTransport wraps the HTTP transport of the client, e.g. to record or fake the responses
+func (api *Anthropic) WrapTransport(wrap func(next http.RoundTripper) http.RoundTripper) {
+	api.client.Transport = wrap(api.client.Transport)
+}
+
+func (api *Anthropic) GetStreamMessages(ctx context.Context, request ai.ChatRequest) (*ai.Stream, error) {
+	bData, err := json.Marshal(newMessagesRequest(request))
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint, err := url.JoinPath(api.cfg.BaseURL, MessagesEndpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(bData))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Accept", "text/event-stream")
+	req.Header.Add("X-Api-Key", api.cfg.Token)
+	req.Header.Add("Anthropic-Version", APIVersion)
+	for name, value := range api.cfg.Headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := api.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("%w: %w", ai.ErrCanceled, err)
+		}
+		return nil, newTransportError(err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer func() { _ = resp.Body.Close() }()
+		bd, _ := io.ReadAll(resp.Body)
+		return nil, newResponseError(resp, bd)
+	}
+
+	stream := ai.NewStream()
+	events := ai.NewEventReader(resp.Body, ai.DefaultMaxEventSize)
+	go func() {
+		var usage Usage
+		var thinking strings.Builder
+		defer func() { _ = resp.Body.Close() }()
+		for {
+			// Event names are duplicated in the "type" field of the data payload,
+			// so only the data is parsed.
+			sseEvent, err := events.Next()
+			if err != nil {
+				if ctx.Err() != nil {
+					stream.Close(ctx.Err())
+					return
+				}
+				if errors.Is(err, io.EOF) {
+					stream.Close(&ai.Error{Provider: ai.ProviderAnthropic, Kind: ai.KindServer, Message: "stream ended without message_stop"})
+					return
+				}
+				stream.Close(&ai.Error{Provider: ai.ProviderAnthropic, Kind: ai.KindServer, Message: "failed to read stream", Err: err})
+				return
+			}
+
+			var event StreamEvent
+			if err := json.Unmarshal(sseEvent.Data, &event); err != nil {
+				stream.Close(&ai.Error{Provider: ai.ProviderAnthropic, Kind: ai.KindServer, Message: "failed to decode stream event", Err: err})
+				return
+			}
+
+			switch event.Type {
+			case EventMessageStart:
+				if event.Message != nil {
+					usage = event.Message.Usage
+				}
+			case EventMessageDelta:
+				// Token counts in message_delta are cumulative
+				if event.Usage != nil {
+					usage.OutputTokens = event.Usage.OutputTokens
+				}
+			case EventContentBlockDelta:
+				if event.Delta == nil {
+					continue
+				}
+				var chunk ai.Chunk
+				switch event.Delta.Type {
+				case DeltaText:
+					chunk.Content = event.Delta.Text
+				case DeltaThinking:
+					chunk.Reasoning = event.Delta.Thinking
+					thinking.WriteString(event.Delta.Thinking)
+				}
+				if chunk.Content == "" && chunk.Reasoning == "" {
+					continue
+				}
+				if !stream.Send(ctx, chunk) {
+					stream.Close(ctx.Err())
+					return
+				}
+			case EventMessageStop:
+				// Thinking is a part of the output tokens and isn't counted separately,
+				// the estimate is lower than billed if the thinking was summarized
+				aiUsage := usage.toAI()
+				if thinking.Len() > 0 {
+					aiUsage.ReasoningTokens = min(ai.TokenizerFor(request.Model).CountTokens(thinking.String()), usage.OutputTokens)
+				}
+				stream.SetUsage(aiUsage)
+				stream.Close(nil)
+				return
+			case EventError:
+				streamErr := &ai.Error{Provider: ai.ProviderAnthropic, Message: "stream error"}
+				if event.Error != nil {
+					streamErr.Kind = errorKind(event.Error)
+					streamErr.Message = event.Error.Type + ": " + event.Error.Message
+				}
+				stream.Close(streamErr)
+				return
+			}
+		}
+	}()
+
+	return stream, nil
+}
+
+// newMessagesRequest converts a provider-neutral request to the Messages API format.
+// System messages are moved to the top-level system field and consecutive messages
+// of the same role are merged, since the API requires alternating roles.
+func newMessagesRequest(request ai.ChatRequest) MessagesRequest {
+	res := MessagesRequest{
+		Model:       request.Model,
+		MaxTokens:   DefaultMaxTokens,
+		Stream:      request.Stream,
+		Temperature: request.Temperature,
+		TopP:        request.TopP,
+	}
+	if request.MaxTokens > 0 {
+		res.MaxTokens = request.MaxTokens
+	}
+	if request.User != "" {
+		res.Metadata = &Metadata{UserID: request.User}
+	}
+	if budget, ok := thinkingBudgets[request.ReasoningEffort]; ok {
+		// Thinking doesn't allow changing the sampling, and max_tokens must leave room for the answer
+		res.Thinking = &Thinking{Type: ThinkingEnabled, BudgetTokens: budget}
+		res.Temperature = nil
+		res.TopP = nil
+		if res.MaxTokens <= budget {
+			res.MaxTokens = budget + DefaultMaxTokens
+		}
+	}
+
+	var system []string
+	for _, msg := range request.Messages {
+		switch msg.Role {
+		case ai.RoleSystem:
+			system = append(system, msg.Text())
+			continue
+		case ai.RoleUser, ai.RoleAssistant:
+		default:
+			continue
+		}
+
+		blocks := newContentBlocks(msg.Content)
+		if len(blocks) == 0 {
+			continue
+		}
+		if l := len(res.Messages); l > 0 && res.Messages[l-1].Role == msg.Role {
+			res.Messages[l-1].Content = append(res.Messages[l-1].Content, blocks...)
+			continue
+		}
+		res.Messages = append(res.Messages, Message{Role: msg.Role, Content: blocks})
+	}
+	res.System = strings.Join(system, "\n\n")
+
+	return res
+}
+
+func newContentBlocks(parts []ai.ContentPart) []ContentBlock {
+	res := make([]ContentBlock, 0, len(parts))
+	for _, part := range parts {
+		switch part.Type {
+		case ai.PartText:
+			// Empty text blocks are rejected by the API
+			if part.Text != "" {
+				res = append(res, ContentBlock{Type: BlockText, Text: part.Text})
+			}
+		case ai.PartImage:
+			res = append(res, ContentBlock{Type: BlockImage, Source: &ImageSource{
+				Type:      ImageSourceBase64,
+				MediaType: part.Image.MIMEType,
+				Data:      base64.StdEncoding.EncodeToString(part.Image.Data),
+			}})
+		}
+	}
+	return res
+}
@@ -0,0 +1,167 @@
+package anthropic
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"tgbot/internal/ai"
+)
+
+// newStubServer replays the recorded stream of the file for every request
+func newStubServer(t *testing.T, status int, header http.Header, file string) *httptest.Server {
+	t.Helper()
+	body, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/"+MessagesEndpoint || r.Header.Get("X-Api-Key") != "key" ||
+			r.Header.Get("Anthropic-Version") != APIVersion {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		for name, values := range header {
+			w.Header()[name] = values
+		}
+		if status == http.StatusOK {
+			w.Header().Set("Content-Type", "text/event-stream")
+		}
+		w.WriteHeader(status)
+		_, _ = w.Write(body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestGetStreamMessages(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		header     http.Header
+		file       string
+		wantText   string
+		wantUsage  ai.Usage
+		wantKind   ai.ErrorKind
+		wantStatus ai.StreamStatus
+		// The request fails before the stream is returned
+		wantReqErr bool
+		retryAfter time.Duration
+	}{
+		{
+			name:       "answer",
+			status:     http.StatusOK,
+			file:       "testdata/stream.txt",
+			wantText:   "Hello, world!",
+			wantUsage:  ai.Usage{PromptTokens: 35, CompletionTokens: 12, TotalTokens: 47, CachedTokens: 10},
+			wantStatus: ai.StreamFinished,
+		},
+		{
+			name:       "error event",
+			status:     http.StatusOK,
+			file:       "testdata/stream_error.txt",
+			wantText:   "Hel",
+			wantKind:   ai.KindServer,
+			wantStatus: ai.StreamFailed,
+		},
+		{
+			name:       "truncated stream",
+			status:     http.StatusOK,
+			file:       "testdata/stream_truncated.txt",
+			wantText:   "Hel",
+			wantKind:   ai.KindServer,
+			wantStatus: ai.StreamFailed,
+		},
+		{
+			name:       "rate limited",
+			status:     http.StatusTooManyRequests,
+			header:     http.Header{"Retry-After": {"7"}},
+			file:       "testdata/rate_limit.json",
+			wantKind:   ai.KindRateLimited,
+			wantReqErr: true,
+			retryAfter: 7 * time.Second,
+		},
+		{
+			name:       "prompt too long",
+			status:     http.StatusBadRequest,
+			file:       "testdata/prompt_too_long.json",
+			wantKind:   ai.KindContextTooLong,
+			wantReqErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newStubServer(t, tt.status, tt.header, tt.file)
+			api, err := New(Config{BaseURL: srv.URL + "/v1", Token: "key"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			stream, err := api.GetStreamMessages(context.Background(), ai.ChatRequest{
+				Model:    "claude-sonnet-4-5",
+				Stream:   true,
+				Messages: []ai.Message{ai.TextMessage(ai.RoleUser, "Hi")},
+			})
+			if tt.wantReqErr {
+				var aiErr *ai.Error
+				if !errors.As(err, &aiErr) {
+					t.Fatalf("error = %v, want *ai.Error", err)
+				}
+				if aiErr.Kind != tt.wantKind || aiErr.StatusCode != tt.status || aiErr.RetryAfter != tt.retryAfter {
+					t.Errorf("error = %+v, want kind %v, status %d, retry after %v", aiErr, tt.wantKind, tt.status, tt.retryAfter)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var chunks []string
+			for chunk := range stream.Chunks() {
+				chunks = append(chunks, chunk.Content)
+			}
+			if text := strings.Join(chunks, ""); text != tt.wantText {
+				t.Errorf("text = %q (chunks %q), want %q", text, chunks, tt.wantText)
+			}
+			if stream.Status() != tt.wantStatus {
+				t.Errorf("status = %v, want %v (err %v)", stream.Status(), tt.wantStatus, stream.Err())
+			}
+			if kind := ai.ErrorKindOf(stream.Err()); kind != tt.wantKind {
+				t.Errorf("error kind = %v, want %v (err %v)", kind, tt.wantKind, stream.Err())
+			}
+			if tt.wantStatus == ai.StreamFinished && stream.Usage() != tt.wantUsage {
+				t.Errorf("usage = %+v, want %+v", stream.Usage(), tt.wantUsage)
+			}
+		})
+	}
+}
+
+func TestNewMessagesRequest(t *testing.T) {
+	res := newMessagesRequest(ai.ChatRequest{
+		Model: "claude-sonnet-4-5",
+		Messages: []ai.Message{
+			ai.TextMessage(ai.RoleSystem, "Be brief."),
+			ai.TextMessage(ai.RoleUser, "One"),
+			ai.TextMessage(ai.RoleUser, "Two"),
+			ai.TextMessage(ai.RoleAssistant, ""),
+			ai.TextMessage(ai.RoleAssistant, "Three"),
+		},
+		ReasoningEffort: ai.EffortLow,
+	})
+
+	if res.System != "Be brief." {
+		t.Errorf("system = %q", res.System)
+	}
+	if len(res.Messages) != 2 || len(res.Messages[0].Content) != 2 || res.Messages[1].Content[0].Text != "Three" {
+		t.Errorf("messages = %+v, want merged user turn and one assistant turn", res.Messages)
+	}
+	if res.Thinking == nil || res.Thinking.BudgetTokens != thinkingBudgets[ai.EffortLow] || res.MaxTokens <= res.Thinking.BudgetTokens {
+		t.Errorf("thinking = %+v, max tokens %d", res.Thinking, res.MaxTokens)
+	}
+}
@@ -0,0 +1,68 @@
+package anthropic
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"tgbot/internal/ai"
+)
+
+// Error types from the error body
+const (
+	typeInvalidRequest  = "invalid_request_error"
+	typeAuthentication  = "authentication_error"
+	typePermission      = "permission_error"
+	typeRequestTooLarge = "request_too_large"
+	typeRateLimit       = "rate_limit_error"
+	typeAPI             = "api_error"
+	typeOverloaded      = "overloaded_error"
+)
+
+// newResponseError converts a non-200 response to a typed provider error
+func newResponseError(resp *http.Response, body []byte) *ai.Error {
+	res := &ai.Error{
+		Provider:   ai.ProviderAnthropic,
+		Kind:       ai.KindByStatus(resp.StatusCode),
+		StatusCode: resp.StatusCode,
+		Message:    string(body),
+		RetryAfter: ai.RetryAfter(resp.Header),
+	}
+
+	var errResp ErrorResponse
+	if json.Unmarshal(body, &errResp) != nil || errResp.Error.Message == "" {
+		return res
+	}
+	res.Message = errResp.Error.Type + ": " + errResp.Error.Message
+	if kind := errorKind(&errResp.Error); kind != ai.KindUnknown {
+		res.Kind = kind
+	}
+	return res
+}
+
+func errorKind(e *Error) ai.ErrorKind {
+	switch e.Type {
+	case typeAuthentication, typePermission:
+		return ai.KindInvalidKey
+	case typeRequestTooLarge:
+		return ai.KindContextTooLong
+	case typeRateLimit:
+		return ai.KindRateLimited
+	case typeAPI, typeOverloaded:
+		return ai.KindServer
+	case typeInvalidRequest:
+		// The API has no dedicated types for these errors
+		msg := strings.ToLower(e.Message)
+		switch {
+		case strings.Contains(msg, "prompt is too long"):
+			return ai.KindContextTooLong
+		case strings.Contains(msg, "credit balance"):
+			return ai.KindQuotaExceeded
+		}
+	}
+	return ai.KindUnknown
+}
+
+// newTransportError is returned when the request didn't get a response
+func newTransportError(err error) *ai.Error {
+	return &ai.Error{Provider: ai.ProviderAnthropic, Kind: ai.KindServer, Err: err}
+}
@@ -0,0 +1,116 @@
+package anthropic
+
+import "tgbot/internal/ai"
+
+type MessagesRequest struct {
+	Model       string    `json:"model"`
+	MaxTokens   int       `json:"max_tokens"`
+	System      string    `json:"system,omitempty"`
+	Messages    []Message `json:"messages"`
+	Stream      bool      `json:"stream"`
+	Metadata    *Metadata `json:"metadata,omitempty"`
+	Temperature *float64  `json:"temperature,omitempty"`
+	TopP        *float64  `json:"top_p,omitempty"`
+	Thinking    *Thinking `json:"thinking,omitempty"`
+}
+
+// Thinking enables extended thinking, the budget is a part of max_tokens
+type Thinking struct {
+	Type         string `json:"type"`
+	BudgetTokens int    `json:"budget_tokens"`
+}
+
+const ThinkingEnabled = "enabled"
+
+type Message struct {
+	Role    string         `json:"role"`
+	Content []ContentBlock `json:"content"`
+}
+
+type ContentBlock struct {
+	Type   string       `json:"type"`
+	Text   string       `json:"text,omitempty"`
+	Source *ImageSource `json:"source,omitempty"`
+}
+
+type ImageSource struct {
+	Type      string `json:"type"`
+	MediaType string `json:"media_type"`
+	Data      string `json:"data"`
+}
+
+const (
+	BlockText         = "text"
+	BlockImage        = "image"
+	ImageSourceBase64 = "base64"
+)
+
+type Metadata struct {
+	UserID string `json:"user_id,omitempty"`
+}
+
+// StreamEvent is a union of all event payloads sent by the Messages streaming API.
+// Only the fields used by the parser are declared.
+type StreamEvent struct {
+	Type    string         `json:"type"`
+	Index   int            `json:"index"`
+	Message *StreamMessage `json:"message,omitempty"`
+	Delta   *StreamDelta   `json:"delta,omitempty"`
+	Usage   *Usage         `json:"usage,omitempty"`
+	Error   *Error         `json:"error,omitempty"`
+}
+
+type StreamMessage struct {
+	ID    string `json:"id"`
+	Model string `json:"model"`
+	Usage Usage  `json:"usage"`
+}
+
+type Usage struct {
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+}
+
+type StreamDelta struct {
+	Type       string `json:"type"`
+	Text       string `json:"text"`
+	Thinking   string `json:"thinking"`
+	StopReason string `json:"stop_reason"`
+}
+
+type Error struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+type ErrorResponse struct {
+	Type  string `json:"type"`
+	Error Error  `json:"error"`
+}
+
+func (u Usage) toAI() ai.Usage {
+	// input_tokens doesn't include tokens read from or written to the cache
+	prompt := u.InputTokens + u.CacheCreationInputTokens + u.CacheReadInputTokens
+	return ai.Usage{
+		PromptTokens:     prompt,
+		CompletionTokens: u.OutputTokens,
+		TotalTokens:      prompt + u.OutputTokens,
+		CachedTokens:     u.CacheReadInputTokens,
+	}
+}
+
+const (
+	EventMessageStart      = "message_start"
+	EventContentBlockStart = "content_block_start"
+	EventContentBlockDelta = "content_block_delta"
+	EventContentBlockStop  = "content_block_stop"
+	EventMessageDelta      = "message_delta"
+	EventMessageStop       = "message_stop"
+	EventPing              = "ping"
+	EventError             = "error"
+
+	DeltaText     = "text_delta"
+	DeltaThinking = "thinking_delta"
+)
@@ -1,5 +1,9 @@
 package ai
 
+import "context"
+
 type ChatModel interface {
-	GetStreamMessages(request ChatRequest) (<-chan string, error)
+	// GetStreamMessages starts generation of an answer. Canceling ctx aborts the
+	// underlying HTTP request and finishes the stream with ErrCanceled.
+	GetStreamMessages(ctx context.Context, request ChatRequest) (*Stream, error)
 }
@@ -0,0 +1,120 @@
+package ai
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var ErrCanceled = errors.New("AI request canceled")
+
+type ErrorKind int
+
+const (
+	KindUnknown ErrorKind = iota
+	KindRateLimited
+	KindQuotaExceeded
+	KindContextTooLong
+	KindInvalidKey
+	// Server errors and network failures
+	KindServer
+	// The provider is not called for a while after consecutive failures
+	KindUnavailable
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case KindRateLimited:
+		return "rate limited"
+	case KindQuotaExceeded:
+		return "quota exceeded"
+	case KindContextTooLong:
+		return "context too long"
+	case KindInvalidKey:
+		return "invalid key"
+	case KindServer:
+		return "server error"
+	case KindUnavailable:
+		return "unavailable"
+	}
+	return "unknown"
+}
+
+// Error is reported when a provider fails to produce an answer
+type Error struct {
+	Provider   string
+	Kind       ErrorKind
+	StatusCode int
+	Message    string
+	// Delay requested by the provider before the next request, 0 if not set
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *Error) Error() string {
+	res := e.Provider + ": " + e.Kind.String()
+	if e.StatusCode != 0 {
+		res += fmt.Sprintf(": status %d", e.StatusCode)
+	}
+	if e.Message != "" {
+		res += ": " + e.Message
+	}
+	if e.Err != nil {
+		res += ": " + e.Err.Error()
+	}
+	return res
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Retryable reports whether the same request may succeed later
+func (e *Error) Retryable() bool {
+	return e.Kind == KindRateLimited || e.Kind == KindServer
+}
+
+// Failover reports whether another model may succeed where the failed one didn't,
+// errors caused by the request itself aren't failed over
+func Failover(err error) bool {
+	switch ErrorKindOf(err) {
+	case KindRateLimited, KindQuotaExceeded, KindInvalidKey, KindServer, KindUnavailable:
+		return true
+	}
+	return false
+}
+
+// ErrorKindOf returns the kind of the provider error in the chain, KindUnknown if there is none
+func ErrorKindOf(err error) ErrorKind {
+	var aiErr *Error
+	if errors.As(err, &aiErr) {
+		return aiErr.Kind
+	}
+	return KindUnknown
+}
+
+// KindByStatus is the fallback for responses without a recognized error code
+func KindByStatus(statusCode int) ErrorKind {
+	switch {
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		return KindInvalidKey
+	case statusCode == http.StatusTooManyRequests:
+		return KindRateLimited
+	case statusCode == http.StatusRequestEntityTooLarge:
+		return KindContextTooLong
+	case statusCode >= http.StatusInternalServerError:
+		return KindServer
+	}
+	return KindUnknown
+}
+
+// RetryAfter parses the Retry-After header in seconds, the HTTP date form is not used by AI providers
+func RetryAfter(header http.Header) time.Duration {
+	seconds, err := strconv.ParseFloat(header.Get("Retry-After"), 64)
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
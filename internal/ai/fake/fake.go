@@ -0,0 +1,179 @@
+// Package fake replaces the HTTP APIs of AI providers with recorded or generated responses,
+// so the bot can run without provider tokens in demos and end-to-end tests. The real provider
+// clients are used on top of the fake transport, so recorded streams go through the same parsers.
+package fake
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FixtureExt is the extension of fixture files. A fixture is a raw HTTP response:
+// the status line, the headers, an empty line and the body read until the end of the file.
+const FixtureExt = ".http"
+
+type Options struct {
+	// Directory with fixture files, responses are generated if it is empty or has no fixtures for the endpoint
+	FixturesDir string
+	// Pause between the events of streamed responses
+	Delay time.Duration
+	// Every Nth request fails with 503, 0 disables failures
+	FailEvery int
+}
+
+// Transport is an http.RoundTripper that answers the requests of the provider clients.
+// Fixtures of an endpoint are replayed in the order of their names, starting over after the last one.
+type Transport struct {
+	opts     Options
+	fixtures map[string][]string // [fixture key] file paths
+
+	mu       sync.Mutex
+	requests int
+	replayed map[string]int // [fixture key] number of replayed fixtures
+}
+
+func NewTransport(opts Options) (*Transport, error) {
+	t := &Transport{opts: opts, fixtures: map[string][]string{}, replayed: map[string]int{}}
+	if opts.FixturesDir == "" {
+		return t, nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(opts.FixturesDir, "*"+FixtureExt))
+	if err != nil {
+		return nil, fmt.Errorf("NewTransport(): %w", err)
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), FixtureExt)
+		// Fixtures are named <fixture key>_<number>
+		key, _, ok := cutLast(name, "_")
+		if !ok {
+			continue
+		}
+		t.fixtures[key] = append(t.fixtures[key], file)
+	}
+	return t, nil
+}
+
+// Wrap returns the transport itself, so it can replace the transports of the provider clients
+func (t *Transport) Wrap(_ http.RoundTripper) http.RoundTripper {
+	return t
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		defer func() { _ = req.Body.Close() }()
+	}
+	key := fixtureKey(req)
+
+	t.mu.Lock()
+	t.requests++
+	fail := t.opts.FailEvery > 0 && t.requests%t.opts.FailEvery == 0
+	var fixture string
+	if files := t.fixtures[key]; len(files) > 0 && !fail {
+		fixture = files[t.replayed[key]%len(files)]
+		t.replayed[key]++
+	}
+	t.mu.Unlock()
+
+	var resp *http.Response
+	var err error
+	switch {
+	case fail:
+		resp = newResponse(req, http.StatusServiceUnavailable, "application/json",
+			[]byte(`{"error":{"message":"fake failure","type":"server_error"}}`))
+	case fixture != "":
+		resp, err = readFixture(req, fixture)
+	default:
+		resp, err = generate(req)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if t.opts.Delay > 0 && strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		resp.Body = delayEvents(req.Context(), resp.Body, t.opts.Delay)
+	}
+	return resp, nil
+}
+
+func readFixture(req *http.Request, file string) (*http.Response, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("readFixture(): %w", err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(data)), req)
+	if err != nil {
+		return nil, fmt.Errorf("readFixture(): %s: %w", file, err)
+	}
+	return resp, nil
+}
+
+func newResponse(req *http.Request, status int, contentType string, body []byte) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {contentType}},
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+// delayEvents streams the events of the body with a pause before each of them
+func delayEvents(ctx context.Context, body io.ReadCloser, delay time.Duration) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		defer func() { _ = body.Close() }()
+		data, err := io.ReadAll(body)
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		for len(data) > 0 {
+			event := data
+			if i := bytes.Index(data, []byte("\n\n")); i >= 0 {
+				event = data[:i+2]
+			}
+			data = data[len(event):]
+
+			select {
+			case <-ctx.Done():
+				pw.CloseWithError(ctx.Err())
+				return
+			case <-time.After(delay):
+			}
+			if _, err := pw.Write(event); err != nil {
+				return
+			}
+		}
+		_ = pw.Close()
+	}()
+	return pr
+}
+
+// fixtureKey identifies the endpoint, e.g. v1_chat_completions
+func fixtureKey(req *http.Request) string {
+	return strings.ReplaceAll(strings.Trim(req.URL.Path, "/"), "/", "_")
+}
+
+func cutLast(s, sep string) (string, string, bool) {
+	i := strings.LastIndex(s, sep)
+	if i < 0 {
+		return s, "", false
+	}
+	return s[:i], s[i+len(sep):], true
+}
@@ -0,0 +1,297 @@
+package fake
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"net/http"
+	"strings"
+	"tgbot/internal/ai"
+	"tgbot/internal/ai/anthropic"
+	"tgbot/internal/ai/openai"
+	"time"
+)
+
+// Words of generated answers sent in one event
+const wordsPerEvent = 3
+
+const (
+	// Opus frame of 20 ms of silence: the TOC byte of a fullband CELT frame and its range coded silence
+	opusSilentFrame = "\xf8\xff\xfe"
+	// Samples of a frame at 48 kHz, the rate of the Opus granule positions
+	opusFrameSamples = 960
+	// Samples the decoder drops at the start
+	opusPreSkip = 312
+	// Frames of the generated speech, 1 second
+	speechFrames = 50
+)
+
+// generate answers the endpoints that can be faked without fixtures. Chat answers repeat the
+// last user message, so the whole dialog flow can be checked by hand. Moderation flags nothing.
+func generate(req *http.Request) (*http.Response, error) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, fmt.Errorf("generate(): %w", err)
+	}
+
+	path := req.URL.Path
+	switch {
+	case strings.HasSuffix(path, "/"+openai.ChatCompletions):
+		return newResponse(req, http.StatusOK, "text/event-stream", openaiChatStream(body)), nil
+	case strings.HasSuffix(path, "/"+anthropic.MessagesEndpoint):
+		return newResponse(req, http.StatusOK, "text/event-stream", anthropicChatStream(body)), nil
+	case strings.HasSuffix(path, "/"+openai.AudioTranscriptions):
+		bData, _ := json.Marshal(openai.TranscriptionResponse{Text: "This is a fake transcription of the voice message"})
+		return newResponse(req, http.StatusOK, "application/json", bData), nil
+	case strings.HasSuffix(path, "/"+openai.Moderations):
+		bData, _ := json.Marshal(openai.ModerationResponse{ID: "fake", Results: []openai.ModerationOutput{{Categories: map[string]bool{}}}})
+		return newResponse(req, http.StatusOK, "application/json", bData), nil
+	case strings.HasSuffix(path, "/"+openai.ImagesGenerations):
+		bData, err := json.Marshal(openai.ImageGenerationResponse{
+			Created: time.Now().Unix(),
+			Data:    []openai.ImageData{{B64JSON: placeholderImage()}},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("generate(): %w", err)
+		}
+		return newResponse(req, http.StatusOK, "application/json", bData), nil
+	case strings.HasSuffix(path, "/"+openai.AudioSpeech):
+		// The bot asks for Opus, the speech is sent as a voice note without converting it
+		return newResponse(req, http.StatusOK, "audio/ogg", placeholderSpeech()), nil
+	}
+	return newResponse(req, http.StatusNotFound, "application/json",
+		[]byte(`{"error":{"message":"no fixture for `+fixtureKey(req)+`","type":"invalid_request_error"}}`)), nil
+}
+
+// chatRequest is the part of the OpenAI and Anthropic chat requests used to generate the answer
+type chatRequest struct {
+	Model    string `json:"model"`
+	Messages []struct {
+		Role    string          `json:"role"`
+		Content json.RawMessage `json:"content"`
+	} `json:"messages"`
+	// Reasoning is generated if either of them is set
+	ReasoningEffort string              `json:"reasoning_effort"`
+	Thinking        *anthropic.Thinking `json:"thinking"`
+}
+
+// reasoning returns the generated reasoning, empty if the request doesn't ask for it
+func (r *chatRequest) reasoning() string {
+	if r.ReasoningEffort == "" && r.Thinking == nil {
+		return ""
+	}
+	return "The user wants an answer. This is a fake reasoning of " + r.Model + " before it."
+}
+
+func (r *chatRequest) reasoningTokens() int {
+	if text := r.reasoning(); text != "" {
+		return ai.TokenizerFor(r.Model).CountTokens(text)
+	}
+	return 0
+}
+
+// answer returns the generated answer and the approximate number of prompt tokens
+func (r *chatRequest) answer() (string, int) {
+	tokenizer := ai.TokenizerFor(r.Model)
+	prompt := 0
+	last := ""
+	for _, msg := range r.Messages {
+		text := contentText(msg.Content)
+		prompt += tokenizer.CountTokens(text)
+		if msg.Role == ai.RoleUser {
+			last = text
+		}
+	}
+	return fmt.Sprintf("This is a fake answer of %s to: %s", r.Model, last), prompt
+}
+
+// contentText returns the text of the string content or of the text parts of the content array
+func contentText(content json.RawMessage) string {
+	var text string
+	if json.Unmarshal(content, &text) == nil {
+		return text
+	}
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	_ = json.Unmarshal(content, &parts)
+	var texts []string
+	for _, part := range parts {
+		if part.Type == "text" {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+func openaiChatStream(body []byte) []byte {
+	var request chatRequest
+	_ = json.Unmarshal(body, &request)
+	answer, prompt := request.answer()
+	tokenizer := ai.TokenizerFor(request.Model)
+	reasoning := request.reasoningTokens()
+	completion := tokenizer.CountTokens(answer) + reasoning
+
+	var sb bytes.Buffer
+	for _, text := range splitWords(request.reasoning()) {
+		writeEvent(&sb, "", openai.ChatCompletionChunk{
+			ID:      "fake",
+			Choices: []openai.ChatCompletionChunkChoice{{Delta: openai.ChatCompletionChunkDelta{ReasoningContent: text}}},
+		})
+	}
+	for _, text := range splitWords(answer) {
+		writeEvent(&sb, "", openai.ChatCompletionChunk{
+			ID:      "fake",
+			Choices: []openai.ChatCompletionChunkChoice{{Delta: openai.ChatCompletionChunkDelta{Content: text}}},
+		})
+	}
+	writeEvent(&sb, "", openai.ChatCompletionChunk{
+		ID:      "fake",
+		Choices: []openai.ChatCompletionChunkChoice{{FinishReason: "stop"}},
+	})
+	writeEvent(&sb, "", openai.ChatCompletionChunk{
+		ID: "fake",
+		Usage: &openai.Usage{
+			PromptTokens:            prompt,
+			CompletionTokens:        completion,
+			TotalTokens:             prompt + completion,
+			CompletionTokensDetails: openai.CompletionTokensDetails{ReasoningTokens: reasoning},
+		},
+	})
+	sb.WriteString("data: [DONE]\n\n")
+	return sb.Bytes()
+}
+
+func anthropicChatStream(body []byte) []byte {
+	var request chatRequest
+	_ = json.Unmarshal(body, &request)
+	answer, prompt := request.answer()
+	tokenizer := ai.TokenizerFor(request.Model)
+	completion := tokenizer.CountTokens(answer) + request.reasoningTokens()
+
+	var sb bytes.Buffer
+	writeEvent(&sb, anthropic.EventMessageStart, anthropic.StreamEvent{
+		Type:    anthropic.EventMessageStart,
+		Message: &anthropic.StreamMessage{ID: "fake", Model: request.Model, Usage: anthropic.Usage{InputTokens: prompt}},
+	})
+	for _, text := range splitWords(request.reasoning()) {
+		writeEvent(&sb, anthropic.EventContentBlockDelta, anthropic.StreamEvent{
+			Type:  anthropic.EventContentBlockDelta,
+			Delta: &anthropic.StreamDelta{Type: anthropic.DeltaThinking, Thinking: text},
+		})
+	}
+	for _, text := range splitWords(answer) {
+		writeEvent(&sb, anthropic.EventContentBlockDelta, anthropic.StreamEvent{
+			Type:  anthropic.EventContentBlockDelta,
+			Delta: &anthropic.StreamDelta{Type: anthropic.DeltaText, Text: text},
+		})
+	}
+	writeEvent(&sb, anthropic.EventMessageDelta, anthropic.StreamEvent{
+		Type:  anthropic.EventMessageDelta,
+		Delta: &anthropic.StreamDelta{StopReason: "end_turn"},
+		Usage: &anthropic.Usage{OutputTokens: completion},
+	})
+	writeEvent(&sb, anthropic.EventMessageStop, anthropic.StreamEvent{Type: anthropic.EventMessageStop})
+	return sb.Bytes()
+}
+
+func writeEvent(sb *bytes.Buffer, event string, data any) {
+	bData, _ := json.Marshal(data)
+	if event != "" {
+		sb.WriteString("event: " + event + "\n")
+	}
+	sb.WriteString("data: ")
+	sb.Write(bData)
+	sb.WriteString("\n\n")
+}
+
+// splitWords splits the text into pieces of a few words, keeping the spaces
+func splitWords(text string) []string {
+	words := strings.SplitAfter(text, " ")
+	var res []string
+	for len(words) > 0 {
+		n := min(wordsPerEvent, len(words))
+		res = append(res, strings.Join(words[:n], ""))
+		words = words[n:]
+	}
+	return res
+}
+
+// placeholderImage returns a gray PNG in base64
+func placeholderImage() string {
+	img := image.NewGray(image.Rect(0, 0, 256, 256))
+	for i := range img.Pix {
+		img.Pix[i] = color.Gray{Y: 0xc0}.Y
+	}
+	var buf bytes.Buffer
+	_ = png.Encode(&buf, img)
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+// placeholderSpeech returns a second of silence in Ogg Opus
+func placeholderSpeech() []byte {
+	head := []byte("OpusHead")
+	// Version 1, one channel, pre-skip, the input rate, no gain, mapping family 0
+	head = append(head, 1, 1)
+	head = binary.LittleEndian.AppendUint16(head, opusPreSkip)
+	head = binary.LittleEndian.AppendUint32(head, 48000)
+	head = append(head, 0, 0, 0)
+	tags := []byte("OpusTags")
+	tags = binary.LittleEndian.AppendUint32(tags, 4)
+	tags = append(tags, "fake"...)
+	tags = binary.LittleEndian.AppendUint32(tags, 0)
+
+	frames := make([][]byte, speechFrames)
+	for i := range frames {
+		frames[i] = []byte(opusSilentFrame)
+	}
+
+	const serial = 0x66616b65
+	var buf bytes.Buffer
+	buf.Write(oggPage(serial, 0, 0x02, 0, [][]byte{head}))
+	buf.Write(oggPage(serial, 1, 0, 0, [][]byte{tags}))
+	buf.Write(oggPage(serial, 2, 0x04, opusPreSkip+speechFrames*opusFrameSamples, frames))
+	return buf.Bytes()
+}
+
+// oggPage returns the page with the packets, each of them shorter than 255 bytes
+func oggPage(serial, sequence uint32, headerType byte, granule uint64, packets [][]byte) []byte {
+	page := []byte("OggS")
+	page = append(page, 0, headerType)
+	page = binary.LittleEndian.AppendUint64(page, granule)
+	page = binary.LittleEndian.AppendUint32(page, serial)
+	page = binary.LittleEndian.AppendUint32(page, sequence)
+	// The checksum is filled in after the page is built
+	page = append(page, 0, 0, 0, 0, byte(len(packets)))
+	for _, packet := range packets {
+		page = append(page, byte(len(packet)))
+	}
+	for _, packet := range packets {
+		page = append(page, packet...)
+	}
+	binary.LittleEndian.PutUint32(page[22:], oggChecksum(page))
+	return page
+}
+
+// oggChecksum is the CRC-32 of Ogg: polynomial 0x04c11db7 without reflection, zero initial value
+func oggChecksum(data []byte) uint32 {
+	var crc uint32
+	for _, b := range data {
+		crc ^= uint32(b) << 24
+		for range 8 {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04c11db7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
@@ -0,0 +1,96 @@
+package fake
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Only these headers are recorded, the rest may identify the account
+var recordedHeaders = []string{"Content-Type", "Retry-After"}
+
+// Recorder saves the responses of real providers as fixtures for the Transport.
+// Streamed responses are written to the file while they are read.
+type Recorder struct {
+	dir string
+
+	mu      sync.Mutex
+	counter map[string]int // [fixture key] number of the last fixture
+}
+
+func NewRecorder(dir string) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("NewRecorder(): %w", err)
+	}
+	return &Recorder{dir: dir, counter: map[string]int{}}, nil
+}
+
+// Wrap returns the transport recording the responses of next, nil next is http.DefaultTransport
+func (r *Recorder) Wrap(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &recordingTransport{recorder: r, next: next}
+}
+
+type recordingTransport struct {
+	recorder *Recorder
+	next     http.RoundTripper
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Create(t.recorder.nextFixturePath(fixtureKey(req)))
+	if err != nil {
+		// Recording must not break the request
+		return resp, nil
+	}
+	_, _ = fmt.Fprintf(file, "HTTP/1.1 %s\r\n", resp.Status)
+	for _, name := range recordedHeaders {
+		if value := resp.Header.Get(name); value != "" {
+			_, _ = fmt.Fprintf(file, "%s: %s\r\n", name, value)
+		}
+	}
+	_, _ = io.WriteString(file, "\r\n")
+
+	resp.Body = &recordedBody{ReadCloser: resp.Body, file: file}
+	return resp, nil
+}
+
+// nextFixturePath numbers the fixtures of the endpoint after the ones already in the directory
+func (r *Recorder) nextFixturePath(key string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for {
+		r.counter[key]++
+		path := filepath.Join(r.dir, fmt.Sprintf("%s_%04d%s", key, r.counter[key], FixtureExt))
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return path
+		}
+	}
+}
+
+type recordedBody struct {
+	io.ReadCloser
+	file *os.File
+}
+
+func (b *recordedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		_, _ = b.file.Write(p[:n])
+	}
+	return n, err
+}
+
+func (b *recordedBody) Close() error {
+	_ = b.file.Close()
+	return b.ReadCloser.Close()
+}
@@ -0,0 +1,22 @@
+package ai
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// NewHTTPClient creates the client for a provider API. Requests go through the proxy if it is set,
+// otherwise the proxy from the environment (HTTP_PROXY, HTTPS_PROXY) is used.
+func NewHTTPClient(proxy string, timeout time.Duration) (http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if proxy != "" {
+		proxyURL, err := url.Parse(proxy)
+		if err != nil {
+			return http.Client{}, fmt.Errorf("NewHTTPClient(): invalid proxy: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	return http.Client{Transport: transport, Timeout: timeout}, nil
+}
@@ -0,0 +1,21 @@
+package ai
+
+import "context"
+
+type ImageModel interface {
+	GenerateImage(ctx context.Context, request ImageRequest) (*Image, error)
+}
+
+type ImageRequest struct {
+	Model  string
+	Prompt string
+	Size   string
+	User   string
+}
+
+// Image contains either the image data or the URL to download it from
+type Image struct {
+	Data          []byte
+	URL           string
+	RevisedPrompt string
+}
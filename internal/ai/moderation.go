@@ -0,0 +1,61 @@
+package ai
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+// Moderator checks texts for content that violates the usage policies
+type Moderator interface {
+	Moderate(ctx context.Context, request ModerationRequest) (*ModerationResult, error)
+}
+
+type ModerationRequest struct {
+	// Model of the moderation API, ignored by local moderators
+	Model string
+	Text  string
+}
+
+type ModerationResult struct {
+	Flagged bool
+	// Flagged categories, sorted
+	Categories []string
+}
+
+// PatternModerator flags texts matching regular expressions, it needs no API and is meant
+// for tests and simple keyword lists
+type PatternModerator struct {
+	patterns map[string][]*regexp.Regexp // [category] patterns
+}
+
+// NewPatternModerator compiles the patterns by category, the patterns are case-insensitive
+func NewPatternModerator(patterns map[string][]string) (*PatternModerator, error) {
+	res := &PatternModerator{patterns: make(map[string][]*regexp.Regexp, len(patterns))}
+	for category, list := range patterns {
+		for _, pattern := range list {
+			re, err := regexp.Compile("(?i)" + pattern)
+			if err != nil {
+				return nil, fmt.Errorf("NewPatternModerator(): category %s: %w", category, err)
+			}
+			res.patterns[category] = append(res.patterns[category], re)
+		}
+	}
+	return res, nil
+}
+
+func (m *PatternModerator) Moderate(_ context.Context, request ModerationRequest) (*ModerationResult, error) {
+	res := &ModerationResult{}
+	for category, list := range m.patterns {
+		for _, re := range list {
+			if re.MatchString(request.Text) {
+				res.Categories = append(res.Categories, category)
+				break
+			}
+		}
+	}
+	sort.Strings(res.Categories)
+	res.Flagged = len(res.Categories) > 0
+	return res, nil
+}
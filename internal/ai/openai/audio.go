@@ -0,0 +1,121 @@
+package openai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"tgbot/internal/ai"
+)
+
+const (
+	AudioTranscriptions = "audio/transcriptions"
+	AudioSpeech         = "audio/speech"
+	DefaultVoice        = "alloy"
+)
+
+func (api *OpenAI) Transcribe(ctx context.Context, request ai.TranscriptionRequest) (*ai.Transcription, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	fields := map[string]string{
+		"model":           request.Model,
+		"language":        request.Language,
+		"response_format": "json",
+	}
+	for name, value := range fields {
+		if value == "" {
+			continue
+		}
+		if err := form.WriteField(name, value); err != nil {
+			return nil, err
+		}
+	}
+	file, err := form.CreateFormFile("file", request.FileName)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = file.Write(request.Audio); err != nil {
+		return nil, err
+	}
+	if err = form.Close(); err != nil {
+		return nil, err
+	}
+
+	req, err := api.newRequest(ctx, request.Model, AudioTranscriptions, &body, form.FormDataContentType())
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := api.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("%w: %w", ai.ErrCanceled, err)
+		}
+		return nil, newTransportError(api.cfg.Name, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	bd, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &ai.Error{Provider: api.cfg.Name, Kind: ai.KindServer, Message: "failed to read response", Err: err}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, newResponseError(api.cfg.Name, resp, bd)
+	}
+
+	var trResponse TranscriptionResponse
+	if err := json.Unmarshal(bd, &trResponse); err != nil {
+		return nil, &ai.Error{Provider: api.cfg.Name, Message: "failed to decode response", Err: err}
+	}
+	return &ai.Transcription{Text: trResponse.Text}, nil
+}
+
+func (api *OpenAI) Synthesize(ctx context.Context, request ai.SpeechRequest) (*ai.Speech, error) {
+	speechRequest := SpeechRequest{
+		Model:          request.Model,
+		Input:          request.Text,
+		Voice:          request.Voice,
+		ResponseFormat: request.Format,
+	}
+	if speechRequest.Voice == "" {
+		speechRequest.Voice = DefaultVoice
+	}
+	if speechRequest.ResponseFormat == "" {
+		speechRequest.ResponseFormat = ai.SpeechFormatMP3
+	}
+
+	bData, err := json.Marshal(speechRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := api.newRequest(ctx, request.Model, AudioSpeech, bytes.NewReader(bData), "application/json")
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := api.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("%w: %w", ai.ErrCanceled, err)
+		}
+		return nil, newTransportError(api.cfg.Name, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	bd, err := io.ReadAll(resp.Body)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("%w: %w", ai.ErrCanceled, err)
+		}
+		return nil, &ai.Error{Provider: api.cfg.Name, Kind: ai.KindServer, Message: "failed to read response", Err: err}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, newResponseError(api.cfg.Name, resp, bd)
+	}
+
+	return &ai.Speech{Data: bd, Format: speechRequest.ResponseFormat}, nil
+}
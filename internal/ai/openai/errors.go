@@ -0,0 +1,88 @@
+package openai
+
+import (
+	"encoding/json"
+	"net/http"
+	"tgbot/internal/ai"
+)
+
+// Error codes and types from the error body
+const (
+	codeInvalidAPIKey         = "invalid_api_key"
+	codeInsufficientQuota     = "insufficient_quota"
+	codeRateLimitExceeded     = "rate_limit_exceeded"
+	codeContextLengthExceeded = "context_length_exceeded"
+	typeServerError           = "server_error"
+)
+
+type ErrorResponse struct {
+	Error *ErrorBody `json:"error"`
+}
+
+type ErrorBody struct {
+	Message string `json:"message"`
+	Type    string `json:"type"`
+	Code    string `json:"code"`
+}
+
+// newResponseError converts a non-200 response to a typed provider error
+func newResponseError(provider string, resp *http.Response, body []byte) *ai.Error {
+	res := &ai.Error{
+		Provider:   provider,
+		Kind:       ai.KindByStatus(resp.StatusCode),
+		StatusCode: resp.StatusCode,
+		Message:    string(body),
+		RetryAfter: ai.RetryAfter(resp.Header),
+	}
+
+	var errResp ErrorResponse
+	if json.Unmarshal(body, &errResp) != nil || errResp.Error == nil {
+		return res
+	}
+	res.Message = errResp.Error.Message
+	if kind := errorKind(errResp.Error); kind != ai.KindUnknown {
+		res.Kind = kind
+	}
+	return res
+}
+
+func errorKind(body *ErrorBody) ai.ErrorKind {
+	switch {
+	case body.Code == codeInvalidAPIKey:
+		return ai.KindInvalidKey
+	// Exhausted quota is also reported with status 429, but retrying doesn't help
+	case body.Code == codeInsufficientQuota || body.Type == codeInsufficientQuota:
+		return ai.KindQuotaExceeded
+	case body.Code == codeRateLimitExceeded:
+		return ai.KindRateLimited
+	case body.Code == codeContextLengthExceeded:
+		return ai.KindContextTooLong
+	case body.Type == typeServerError:
+		return ai.KindServer
+	}
+	return ai.KindUnknown
+}
+
+// newTransportError is returned when the request didn't get a response
+func newTransportError(provider string, err error) *ai.Error {
+	return &ai.Error{Provider: provider, Kind: ai.KindServer, Err: err}
+}
+
+// newStreamError converts an error sent inside the event stream. Backends send either
+// the usual error response or the bare error body, after the headers with status 200.
+func newStreamError(provider string, data []byte) *ai.Error {
+	res := &ai.Error{Provider: provider, Kind: ai.KindServer, Message: string(data)}
+
+	var errResp ErrorResponse
+	body := errResp.Error
+	if json.Unmarshal(data, &errResp) == nil && errResp.Error != nil {
+		body = errResp.Error
+	} else if json.Unmarshal(data, &body) != nil || body == nil {
+		return res
+	}
+	res.Message = body.Message
+	if kind := errorKind(body); kind != ai.KindUnknown {
+		res.Kind = kind
+	}
+	return res
+}
@@ -0,0 +1,80 @@
+package openai
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"tgbot/internal/ai"
+)
+
+const (
+	ImagesGenerations = "images/generations"
+	DefaultImageSize  = "1024x1024"
+)
+
+func (api *OpenAI) GenerateImage(ctx context.Context, request ai.ImageRequest) (*ai.Image, error) {
+	imgRequest := ImageGenerationRequest{
+		Model:  request.Model,
+		Prompt: request.Prompt,
+		N:      1,
+		Size:   request.Size,
+		User:   request.User,
+	}
+	if imgRequest.Size == "" {
+		imgRequest.Size = DefaultImageSize
+	}
+	// gpt-image models always return base64 and reject the parameter
+	if strings.HasPrefix(request.Model, "dall-e") {
+		imgRequest.ResponseFormat = "b64_json"
+	}
+
+	bData, err := json.Marshal(imgRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := api.newRequest(ctx, request.Model, ImagesGenerations, bytes.NewReader(bData), "application/json")
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := api.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("%w: %w", ai.ErrCanceled, err)
+		}
+		return nil, newTransportError(api.cfg.Name, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	bd, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &ai.Error{Provider: api.cfg.Name, Kind: ai.KindServer, Message: "failed to read response", Err: err}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, newResponseError(api.cfg.Name, resp, bd)
+	}
+
+	var imgResponse ImageGenerationResponse
+	if err := json.Unmarshal(bd, &imgResponse); err != nil {
+		return nil, &ai.Error{Provider: api.cfg.Name, Message: "failed to decode response", Err: err}
+	}
+	if len(imgResponse.Data) == 0 {
+		return nil, &ai.Error{Provider: api.cfg.Name, Message: "no images in response"}
+	}
+
+	data := imgResponse.Data[0]
+	res := &ai.Image{URL: data.URL, RevisedPrompt: data.RevisedPrompt}
+	if data.B64JSON != "" {
+		res.Data, err = base64.StdEncoding.DecodeString(data.B64JSON)
+		if err != nil {
+			return nil, &ai.Error{Provider: api.cfg.Name, Message: "failed to decode image", Err: err}
+		}
+	}
+	return res, nil
+}
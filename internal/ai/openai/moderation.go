@@ -0,0 +1,63 @@
+package openai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"tgbot/internal/ai"
+)
+
+const Moderations = "moderations"
+
+func (api *OpenAI) Moderate(ctx context.Context, request ai.ModerationRequest) (*ai.ModerationResult, error) {
+	bData, err := json.Marshal(ModerationRequest{Model: request.Model, Input: request.Text})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := api.newRequest(ctx, request.Model, Moderations, bytes.NewReader(bData), "application/json")
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := api.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("%w: %w", ai.ErrCanceled, err)
+		}
+		return nil, newTransportError(api.cfg.Name, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	bd, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &ai.Error{Provider: api.cfg.Name, Kind: ai.KindServer, Message: "failed to read response", Err: err}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, newResponseError(api.cfg.Name, resp, bd)
+	}
+
+	var modResponse ModerationResponse
+	if err := json.Unmarshal(bd, &modResponse); err != nil {
+		return nil, &ai.Error{Provider: api.cfg.Name, Message: "failed to decode response", Err: err}
+	}
+
+	// Long inputs may be split into several results, the text is flagged if any of them is
+	res := &ai.ModerationResult{}
+	categories := map[string]bool{}
+	for _, result := range modResponse.Results {
+		res.Flagged = res.Flagged || result.Flagged
+		for category, flagged := range result.Categories {
+			if flagged && !categories[category] {
+				categories[category] = true
+				res.Categories = append(res.Categories, category)
+			}
+		}
+	}
+	sort.Strings(res.Categories)
+	return res, nil
+}
@@ -1,95 +1,281 @@
 package openai
 
 import (
-	"bufio"
 	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"net/url"
 	"tgbot/internal/ai"
 	"time"
 )
 
-const ChatCompletions = "chat/completions"
+const (
+	ChatCompletions = "chat/completions"
+	// Type of the events some compatible backends use for errors in the stream
+	eventError = "error"
+)
+
+type AuthStyle string
+
+const (
+	// AuthBearer sends the token in the Authorization header, used by OpenAI and most compatible backends
+	AuthBearer AuthStyle = "bearer"
+	// AuthAPIKey sends the token in the api-key header
+	AuthAPIKey AuthStyle = "api-key"
+	// AuthAzure sends the token in the api-key header and addresses models as Azure deployments
+	AuthAzure AuthStyle = "azure"
+)
+
+type Config struct {
+	// Provider name in the errors, ai.ProviderOpenAI if empty
+	Name    string
+	BaseURL string
+	Token   string
+	// AuthBearer if empty
+	Auth AuthStyle
+	// api-version query parameter, required by Azure
+	APIVersion string
+	Proxy      string
+	Timeout    time.Duration
+	// Extra headers sent with every request
+	Headers      map[string]string
+	Organization string
+	Project      string
+}
 
 type OpenAI struct {
 	client http.Client
-	host   string
-	token  string
+	cfg    Config
 }
 
-func New(host string, token string, timeout time.Duration) ai.ChatModel {
-	return &OpenAI{
-		client: http.Client{Timeout: timeout},
-		host:   host,
-		token:  token,
+func New(cfg Config) (*OpenAI, error) {
+	if cfg.Name == "" {
+		cfg.Name = ai.ProviderOpenAI
+	}
+	switch cfg.Auth {
+	case "":
+		cfg.Auth = AuthBearer
+	case AuthBearer, AuthAPIKey:
+	case AuthAzure:
+		if cfg.APIVersion == "" {
+			return nil, errors.New("New(): api version is required for Azure")
+		}
+	default:
+		return nil, fmt.Errorf("New(): unknown auth style '%s'", cfg.Auth)
 	}
+	if _, err := url.Parse(cfg.BaseURL); err != nil {
+		return nil, fmt.Errorf("New(): invalid base url: %w", err)
+	}
+
+	client, err := ai.NewHTTPClient(cfg.Proxy, cfg.Timeout)
+	if err != nil {
+		return nil, fmt.Errorf("New(): %w", err)
+	}
+	return &OpenAI{client: client, cfg: cfg}, nil
 }
 
-func (api *OpenAI) GetStreamMessages(request ai.ChatRequest) (<-chan string, error) {
-	bData, err := json.Marshal(request)
+// newRequest creates a request to the API path for the model. Azure serves
+// every model as a deployment with its own path, the model name is the deployment name.
+func (api *OpenAI) newRequest(ctx context.Context, model, path string, body io.Reader, contentType string) (*http.Request, error) {
+	endpoint, err := url.JoinPath(api.cfg.BaseURL, path)
+	if api.cfg.Auth == AuthAzure {
+		endpoint, err = url.JoinPath(api.cfg.BaseURL, "openai/deployments", model, path)
+	}
 	if err != nil {
 		return nil, err
 	}
+	if api.cfg.APIVersion != "" {
+		endpoint += "?" + url.Values{"api-version": {api.cfg.APIVersion}}.Encode()
+	}
 
-	endpoint, err := url.JoinPath(api.host, ChatCompletions)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, body)
 	if err != nil {
 		return nil, err
 	}
 
-	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(bData))
+	req.Header.Set("Content-Type", contentType)
+	switch api.cfg.Auth {
+	case AuthBearer:
+		req.Header.Set("Authorization", "Bearer "+api.cfg.Token)
+	case AuthAPIKey, AuthAzure:
+		req.Header.Set("Api-Key", api.cfg.Token)
+	}
+	if api.cfg.Organization != "" {
+		req.Header.Set("OpenAI-Organization", api.cfg.Organization)
+	}
+	if api.cfg.Project != "" {
+		req.Header.Set("OpenAI-Project", api.cfg.Project)
+	}
+	for name, value := range api.cfg.Headers {
+		req.Header.Set(name, value)
+	}
+	return req, nil
+}
+
+// WrapTransport wraps the HTTP transport of the client, e.g. to record or fake the responses
+func (api *OpenAI) WrapTransport(wrap func(next http.RoundTripper) http.RoundTripper) {
+	api.client.Transport = wrap(api.client.Transport)
+}
+
+func (api *OpenAI) GetStreamMessages(ctx context.Context, request ai.ChatRequest) (*ai.Stream, error) {
+	bData, err := json.Marshal(newChatCompletionRequest(request))
 	if err != nil {
 		return nil, err
 	}
 
-	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("Authorization", "Bearer "+api.token)
+	req, err := api.newRequest(ctx, request.Model, ChatCompletions, bytes.NewReader(bData), "application/json")
+	if err != nil {
+		return nil, err
+	}
 
 	resp, err := api.client.Do(req)
 	if err != nil {
-		return nil, err
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("%w: %w", ai.ErrCanceled, err)
+		}
+		return nil, newTransportError(api.cfg.Name, err)
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		log.Print("Ошибка: статус", resp.Status)
+		defer func() { _ = resp.Body.Close() }()
 		bd, _ := io.ReadAll(resp.Body)
-		log.Print(string(bd))
-		_ = resp.Body.Close()
-		return nil, errors.New("Некорректный код ответа")
+		return nil, newResponseError(api.cfg.Name, resp, bd)
 	}
 
-	ch := make(chan string)
-	scanner := bufio.NewScanner(resp.Body)
+	stream := ai.NewStream()
+	events := ai.NewEventReader(resp.Body, ai.DefaultMaxEventSize)
 	go func() {
 		defer func() { _ = resp.Body.Close() }()
-		defer close(ch)
-		for scanner.Scan() {
-			line := scanner.Bytes()
+		var toolCalls []ai.ToolCall
+		for {
+			event, err := events.Next()
+			if err != nil {
+				if ctx.Err() != nil {
+					stream.Close(ctx.Err())
+					return
+				}
+				if errors.Is(err, io.EOF) {
+					// Some compatible backends close the stream without [DONE]
+					stream.SetToolCalls(toolCalls)
+					stream.Close(nil)
+					return
+				}
+				stream.Close(&ai.Error{Provider: api.cfg.Name, Kind: ai.KindServer, Message: "failed to read stream", Err: err})
+				return
+			}
 
-			if len(line) == 0 {
-				continue
+			// Usage arrives in a separate chunk after the finish reason, so the stream is read up to [DONE]
+			if event.Done() {
+				stream.SetToolCalls(toolCalls)
+				stream.Close(nil)
+				return
 			}
-			if len(line) >= 6 {
-				line = line[6:]
+			if event.Event == eventError {
+				stream.Close(newStreamError(api.cfg.Name, event.Data))
+				return
 			}
 			var chunk ChatCompletionChunk
-			if err = json.Unmarshal(line, &chunk); err != nil {
-				log.Print("Ошибка десириализации: ", err)
+			if err := json.Unmarshal(event.Data, &chunk); err != nil {
+				stream.Close(&ai.Error{Provider: api.cfg.Name, Kind: ai.KindServer, Message: "failed to decode stream chunk", Err: err})
+				return
+			}
+			if chunk.Error != nil {
+				stream.Close(newStreamError(api.cfg.Name, event.Data))
 				return
 			}
+			if chunk.Usage != nil {
+				stream.SetUsage(chunk.Usage.toAI())
+			}
 			for _, v := range chunk.Choices {
-				if v.FinishReason != "" {
+				toolCalls = appendToolCallDeltas(toolCalls, v.Delta.ToolCalls)
+				chunk := ai.Chunk{Content: v.Delta.Content, Reasoning: v.Delta.ReasoningContent + v.Delta.Reasoning}
+				if (chunk.Content != "" || chunk.Reasoning != "") && !stream.Send(ctx, chunk) {
+					stream.Close(ctx.Err())
 					return
 				}
-				if v.Delta.Content != "" {
-					ch <- v.Delta.Content
-				}
 			}
 		}
 	}()
 
-	return ch, nil
+	return stream, nil
+}
+
+func newChatCompletionRequest(request ai.ChatRequest) ChatCompletionRequest {
+	res := ChatCompletionRequest{
+		Model:               request.Model,
+		Stream:              request.Stream,
+		User:                request.User,
+		Temperature:         request.Temperature,
+		TopP:                request.TopP,
+		MaxCompletionTokens: request.MaxTokens,
+		ReasoningEffort:     request.ReasoningEffort,
+	}
+	for _, msg := range request.Messages {
+		res.Messages = append(res.Messages, newMessage(msg))
+	}
+	for _, tool := range request.Tools {
+		res.Tools = append(res.Tools, Tool{
+			Type:     ToolTypeFunction,
+			Function: Function{Name: tool.Name, Description: tool.Description, Parameters: tool.Parameters},
+		})
+	}
+	if request.Stream {
+		res.StreamOptions = &StreamOptions{IncludeUsage: true}
+	}
+	return res
+}
+
+// newMessage uses the plain string content for text-only messages, which is supported by all compatible backends
+func newMessage(msg ai.Message) Message {
+	if len(msg.ToolCalls) > 0 {
+		res := Message{Role: msg.Role}
+		if text := msg.Text(); text != "" {
+			res.Content = text
+		}
+		for _, call := range msg.ToolCalls {
+			res.ToolCalls = append(res.ToolCalls, ToolCall{
+				ID:       call.ID,
+				Type:     ToolTypeFunction,
+				Function: FunctionCall{Name: call.Name, Arguments: call.Arguments},
+			})
+		}
+		return res
+	}
+	if !msg.HasImages() {
+		return Message{Role: msg.Role, Content: msg.Text(), ToolCallID: msg.ToolCallID}
+	}
+
+	parts := make([]ContentPart, 0, len(msg.Content))
+	for _, part := range msg.Content {
+		switch part.Type {
+		case ai.PartText:
+			parts = append(parts, ContentPart{Type: ContentPartText, Text: part.Text})
+		case ai.PartImage:
+			dataURL := "data:" + part.Image.MIMEType + ";base64," + base64.StdEncoding.EncodeToString(part.Image.Data)
+			parts = append(parts, ContentPart{Type: ContentPartImageURL, ImageURL: &ImageURL{URL: dataURL}})
+		}
+	}
+	return Message{Role: msg.Role, Content: parts}
+}
+
+// appendToolCallDeltas merges streamed fragments into the tool calls. The id and the name come
+// in the first fragment of a call, the arguments are split across all of them.
+func appendToolCallDeltas(calls []ai.ToolCall, deltas []ToolCallDelta) []ai.ToolCall {
+	for _, delta := range deltas {
+		for len(calls) <= delta.Index {
+			calls = append(calls, ai.ToolCall{})
+		}
+		call := &calls[delta.Index]
+		if delta.ID != "" {
+			call.ID = delta.ID
+		}
+		call.Name += delta.Function.Name
+		call.Arguments += delta.Function.Arguments
+	}
+	return calls
 }
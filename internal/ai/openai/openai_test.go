@@ -0,0 +1,221 @@
+package openai
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"tgbot/internal/ai"
+)
+
+// stubResponse is the answer of the stub server to every request
+type stubResponse struct {
+	status int
+	header http.Header
+	body   string
+}
+
+// newStubServer answers with the response and sends the received requests to the channel
+func newStubServer(t *testing.T, res stubResponse, requests chan<- *http.Request) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- r.Clone(context.Background())
+		for name, values := range res.header {
+			w.Header()[name] = values
+		}
+		w.WriteHeader(res.status)
+		_, _ = io.WriteString(w, res.body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestRequestAuthStyles(t *testing.T) {
+	tests := []struct {
+		name      string
+		cfg       Config
+		response  stubResponse
+		wantPath  string
+		wantQuery string
+		// Headers of the request, an empty value means the header must be missing
+		wantHeaders map[string]string
+		wantKind    ai.ErrorKind
+	}{
+		{
+			name: "bearer",
+			cfg: Config{Token: "key", Organization: "org", Project: "proj",
+				Headers: map[string]string{"X-Title": "bot"}},
+			response: stubResponse{status: http.StatusUnauthorized,
+				body: `{"error":{"message":"bad key","type":"invalid_request_error","code":"invalid_api_key"}}`},
+			wantPath: "/v1/chat/completions",
+			wantHeaders: map[string]string{"Authorization": "Bearer key", "Api-Key": "", "X-Title": "bot",
+				"OpenAI-Organization": "org", "OpenAI-Project": "proj"},
+			wantKind: ai.KindInvalidKey,
+		},
+		{
+			name: "api-key",
+			cfg:  Config{Name: "openrouter", Token: "key", Auth: AuthAPIKey},
+			response: stubResponse{status: http.StatusTooManyRequests,
+				body: `{"error":{"message":"no money","type":"insufficient_quota"}}`},
+			wantPath:    "/v1/chat/completions",
+			wantHeaders: map[string]string{"Authorization": "", "Api-Key": "key"},
+			wantKind:    ai.KindQuotaExceeded,
+		},
+		{
+			name: "azure",
+			cfg:  Config{Name: "azure", Token: "key", Auth: AuthAzure, APIVersion: "2024-10-21"},
+			response: stubResponse{status: http.StatusBadRequest,
+				body: `{"error":{"message":"too long","code":"context_length_exceeded"}}`},
+			wantPath:    "/v1/openai/deployments/gpt-4o/chat/completions",
+			wantQuery:   "api-version=2024-10-21",
+			wantHeaders: map[string]string{"Authorization": "", "Api-Key": "key"},
+			wantKind:    ai.KindContextTooLong,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests := make(chan *http.Request, 1)
+			srv := newStubServer(t, tt.response, requests)
+			tt.cfg.BaseURL = srv.URL + "/v1"
+			api, err := New(tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = api.GetStreamMessages(context.Background(), ai.ChatRequest{
+				Model:    "gpt-4o",
+				Stream:   true,
+				Messages: []ai.Message{ai.TextMessage(ai.RoleUser, "Hi")},
+			})
+
+			r := <-requests
+			if r.URL.Path != tt.wantPath || r.URL.RawQuery != tt.wantQuery {
+				t.Errorf("url = %s?%s, want %s?%s", r.URL.Path, r.URL.RawQuery, tt.wantPath, tt.wantQuery)
+			}
+			for name, want := range tt.wantHeaders {
+				if got := r.Header.Get(name); got != want {
+					t.Errorf("header %s = %q, want %q", name, got, want)
+				}
+			}
+
+			wantProvider := tt.cfg.Name
+			if wantProvider == "" {
+				wantProvider = ai.ProviderOpenAI
+			}
+			var aiErr *ai.Error
+			if !errors.As(err, &aiErr) {
+				t.Fatalf("error = %v, want *ai.Error", err)
+			}
+			if aiErr.Kind != tt.wantKind || aiErr.Provider != wantProvider || aiErr.StatusCode != tt.response.status {
+				t.Errorf("error = %+v, want kind %v of %s with status %d", aiErr, tt.wantKind, wantProvider, tt.response.status)
+			}
+		})
+	}
+}
+
+func TestNewConfig(t *testing.T) {
+	if _, err := New(Config{Auth: AuthAzure}); err == nil {
+		t.Error("Azure without api version is accepted")
+	}
+	if _, err := New(Config{Auth: "basic"}); err == nil {
+		t.Error("unknown auth style is accepted")
+	}
+}
+
+func TestNewResponseError(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		header     http.Header
+		body       string
+		wantKind   ai.ErrorKind
+		wantMsg    string
+		retryAfter time.Duration
+	}{
+		{name: "rate limit", status: http.StatusTooManyRequests, header: http.Header{"Retry-After": {"3"}},
+			body:     `{"error":{"message":"slow down","code":"rate_limit_exceeded"}}`,
+			wantKind: ai.KindRateLimited, wantMsg: "slow down", retryAfter: 3 * time.Second},
+		{name: "quota by code", status: http.StatusTooManyRequests,
+			body:     `{"error":{"message":"no money","code":"insufficient_quota"}}`,
+			wantKind: ai.KindQuotaExceeded, wantMsg: "no money"},
+		{name: "server error type", status: http.StatusOK,
+			body:     `{"error":{"message":"oops","type":"server_error"}}`,
+			wantKind: ai.KindServer, wantMsg: "oops"},
+		{name: "unknown code keeps the status kind", status: http.StatusTooManyRequests,
+			body:     `{"error":{"message":"busy","code":"something_else"}}`,
+			wantKind: ai.KindRateLimited, wantMsg: "busy"},
+		{name: "not json", status: http.StatusBadGateway, body: "<html>bad gateway</html>",
+			wantKind: ai.KindByStatus(http.StatusBadGateway), wantMsg: "<html>bad gateway</html>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{StatusCode: tt.status, Header: tt.header}
+			if resp.Header == nil {
+				resp.Header = http.Header{}
+			}
+			err := newResponseError("azure", resp, []byte(tt.body))
+			if err.Kind != tt.wantKind || err.Message != tt.wantMsg || err.RetryAfter != tt.retryAfter || err.Provider != "azure" {
+				t.Errorf("error = %+v, want kind %v, message %q, retry after %v", err, tt.wantKind, tt.wantMsg, tt.retryAfter)
+			}
+		})
+	}
+}
+
+func TestNewStreamError(t *testing.T) {
+	tests := []struct {
+		data     string
+		wantKind ai.ErrorKind
+		wantMsg  string
+	}{
+		{data: `{"error":{"message":"too long","code":"context_length_exceeded"}}`, wantKind: ai.KindContextTooLong, wantMsg: "too long"},
+		{data: `{"message":"bad key","code":"invalid_api_key"}`, wantKind: ai.KindInvalidKey, wantMsg: "bad key"},
+		{data: `{"message":"upstream failed"}`, wantKind: ai.KindServer, wantMsg: "upstream failed"},
+		{data: "not json", wantKind: ai.KindServer, wantMsg: "not json"},
+	}
+
+	for _, tt := range tests {
+		err := newStreamError("openrouter", []byte(tt.data))
+		if err.Kind != tt.wantKind || err.Message != tt.wantMsg || err.Provider != "openrouter" {
+			t.Errorf("newStreamError(%q) = %+v, want kind %v, message %q", tt.data, err, tt.wantKind, tt.wantMsg)
+		}
+	}
+}
+
+// Errors sent inside a stream with status 200 fail the stream with the provider name
+func TestGetStreamMessagesStreamError(t *testing.T) {
+	requests := make(chan *http.Request, 1)
+	srv := newStubServer(t, stubResponse{
+		status: http.StatusOK,
+		header: http.Header{"Content-Type": {"text/event-stream"}},
+		body: "data: {\"choices\":[{\"delta\":{\"content\":\"Hel\"}}]}\n\n" +
+			"data: {\"error\":{\"message\":\"overloaded\",\"type\":\"server_error\"}}\n\n",
+	}, requests)
+	api, err := New(Config{Name: "openrouter", BaseURL: srv.URL + "/v1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream, err := api.GetStreamMessages(context.Background(), ai.ChatRequest{
+		Model:    "gpt-4o",
+		Stream:   true,
+		Messages: []ai.Message{ai.TextMessage(ai.RoleUser, "Hi")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var text strings.Builder
+	for chunk := range stream.Chunks() {
+		text.WriteString(chunk.Content)
+	}
+	var aiErr *ai.Error
+	if text.String() != "Hel" || !errors.As(stream.Err(), &aiErr) || aiErr.Kind != ai.KindServer || aiErr.Provider != "openrouter" {
+		t.Errorf("text %q, error %v", text.String(), stream.Err())
+	}
+}
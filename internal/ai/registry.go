@@ -0,0 +1,88 @@
+package ai
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+const (
+	ProviderOpenAI    = "openai"
+	ProviderAnthropic = "anthropic"
+)
+
+var ErrProviderNotFound = errors.New("AI provider not found")
+
+// Registry holds AI backends keyed by provider name, so every AI model row
+// can be routed to its own backend.
+type Registry struct {
+	chatModels    sync.Map // [string] ChatModel
+	imageModels   sync.Map // [string] ImageModel
+	speechToTexts sync.Map // [string] SpeechToText
+	textToSpeechs sync.Map // [string] TextToSpeech
+	moderators    sync.Map // [string] Moderator
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) RegisterChatModel(provider string, model ChatModel) {
+	r.chatModels.Store(provider, model)
+}
+
+func (r *Registry) ChatModel(provider string) (ChatModel, error) {
+	model, ok := r.chatModels.Load(provider)
+	if !ok {
+		return nil, fmt.Errorf("ChatModel(): %w: '%s'", ErrProviderNotFound, provider)
+	}
+	return model.(ChatModel), nil
+}
+
+func (r *Registry) RegisterImageModel(provider string, model ImageModel) {
+	r.imageModels.Store(provider, model)
+}
+
+func (r *Registry) ImageModel(provider string) (ImageModel, error) {
+	model, ok := r.imageModels.Load(provider)
+	if !ok {
+		return nil, fmt.Errorf("ImageModel(): %w: '%s'", ErrProviderNotFound, provider)
+	}
+	return model.(ImageModel), nil
+}
+
+func (r *Registry) RegisterSpeechToText(provider string, model SpeechToText) {
+	r.speechToTexts.Store(provider, model)
+}
+
+func (r *Registry) SpeechToText(provider string) (SpeechToText, error) {
+	model, ok := r.speechToTexts.Load(provider)
+	if !ok {
+		return nil, fmt.Errorf("SpeechToText(): %w: '%s'", ErrProviderNotFound, provider)
+	}
+	return model.(SpeechToText), nil
+}
+
+func (r *Registry) RegisterTextToSpeech(provider string, model TextToSpeech) {
+	r.textToSpeechs.Store(provider, model)
+}
+
+func (r *Registry) TextToSpeech(provider string) (TextToSpeech, error) {
+	model, ok := r.textToSpeechs.Load(provider)
+	if !ok {
+		return nil, fmt.Errorf("TextToSpeech(): %w: '%s'", ErrProviderNotFound, provider)
+	}
+	return model.(TextToSpeech), nil
+}
+
+func (r *Registry) RegisterModerator(provider string, moderator Moderator) {
+	r.moderators.Store(provider, moderator)
+}
+
+func (r *Registry) Moderator(provider string) (Moderator, error) {
+	moderator, ok := r.moderators.Load(provider)
+	if !ok {
+		return nil, fmt.Errorf("Moderator(): %w: '%s'", ErrProviderNotFound, provider)
+	}
+	return moderator.(Moderator), nil
+}
@@ -0,0 +1,126 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultBreakerThreshold = 5
+	DefaultBreakerCooldown  = 30 * time.Second
+)
+
+type RetryPolicy struct {
+	// Attempts including the first one
+	MaxAttempts int
+	BaseDelay   time.Duration
+	// Requests asking for a longer Retry-After are not retried
+	MaxDelay time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{MaxAttempts: 3, BaseDelay: 500 * time.Millisecond, MaxDelay: 8 * time.Second}
+
+// delay returns the pause before the next attempt, false if the request shouldn't be retried
+func (p RetryPolicy) delay(attempt int, err error) (time.Duration, bool) {
+	var aiErr *Error
+	if attempt >= p.MaxAttempts || !errors.As(err, &aiErr) || !aiErr.Retryable() {
+		return 0, false
+	}
+
+	backoff := min(p.MaxDelay, p.BaseDelay<<(attempt-1))
+	// Jitter spreads the retries of requests that failed at the same time
+	res := backoff/2 + rand.N(backoff/2+1)
+	if aiErr.RetryAfter > p.MaxDelay {
+		return 0, false
+	}
+	return max(res, aiErr.RetryAfter), true
+}
+
+// CircuitBreaker stops requests to a provider for a cooldown after consecutive server failures.
+// After the cooldown a single request is let through to check whether the provider has recovered.
+type CircuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{threshold: threshold, cooldown: cooldown}
+}
+
+// Allow reports whether a request may be sent, every allowed request must be reported
+func (cb *CircuitBreaker) Allow() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if cb.failures < cb.threshold {
+		return true
+	}
+	if cb.probing || time.Now().Before(cb.openUntil) {
+		return false
+	}
+	cb.probing = true
+	return true
+}
+
+// Report records the outcome of an allowed request
+func (cb *CircuitBreaker) Report(err error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.probing = false
+	switch {
+	case errors.Is(err, ErrCanceled):
+		// Says nothing about the provider
+	case ErrorKindOf(err) == KindServer:
+		cb.failures++
+		if cb.failures >= cb.threshold {
+			cb.openUntil = time.Now().Add(cb.cooldown)
+		}
+	default:
+		cb.failures = 0
+	}
+}
+
+// ResilientChatModel retries retryable errors before the stream starts
+// and stops calling the provider while its circuit breaker is open
+type ResilientChatModel struct {
+	provider string
+	model    ChatModel
+	policy   RetryPolicy
+	breaker  *CircuitBreaker
+}
+
+func NewResilientChatModel(provider string, model ChatModel, policy RetryPolicy, breaker *CircuitBreaker) *ResilientChatModel {
+	return &ResilientChatModel{provider: provider, model: model, policy: policy, breaker: breaker}
+}
+
+func (m *ResilientChatModel) GetStreamMessages(ctx context.Context, request ChatRequest) (*Stream, error) {
+	for attempt := 1; ; attempt++ {
+		if !m.breaker.Allow() {
+			return nil, &Error{Provider: m.provider, Kind: KindUnavailable, Message: "circuit breaker is open"}
+		}
+
+		stream, err := m.model.GetStreamMessages(ctx, request)
+		m.breaker.Report(err)
+		if err == nil {
+			return stream, nil
+		}
+
+		delay, ok := m.policy.delay(attempt, err)
+		if !ok {
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%w: %w", ErrCanceled, ctx.Err())
+		case <-time.After(delay):
+		}
+	}
+}
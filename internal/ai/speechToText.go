@@ -0,0 +1,20 @@
+package ai
+
+import "context"
+
+type SpeechToText interface {
+	Transcribe(ctx context.Context, request TranscriptionRequest) (*Transcription, error)
+}
+
+type TranscriptionRequest struct {
+	Model string
+	Audio []byte
+	// File name with an extension, providers detect the audio format by it
+	FileName string
+	// Optional ISO-639-1 language of the audio, improves accuracy
+	Language string
+}
+
+type Transcription struct {
+	Text string
+}
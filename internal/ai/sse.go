@@ -0,0 +1,123 @@
+package ai
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+)
+
+// DefaultMaxEventSize limits a single line of a server-sent event stream. Chunks of streamed
+// answers are small, but tool call arguments and in-stream errors can be long.
+const DefaultMaxEventSize = 4 << 20
+
+var ErrEventTooLarge = errors.New("server-sent event is too large")
+
+// Event is a server-sent event
+type Event struct {
+	// Event type, empty if the server didn't send it
+	Event string
+	ID    string
+	// Data lines of the event joined with "\n"
+	Data []byte
+}
+
+// Done reports whether the event is the end of stream marker of OpenAI compatible backends
+func (e *Event) Done() bool {
+	return string(e.Data) == "[DONE]"
+}
+
+// EventReader reads server-sent events (text/event-stream). Comments and keep-alives,
+// events without data and unknown fields are skipped.
+type EventReader struct {
+	scanner *bufio.Scanner
+	lastID  string
+}
+
+// NewEventReader creates the reader, lines longer than maxEventSize fail with ErrEventTooLarge
+func NewEventReader(r io.Reader, maxEventSize int) *EventReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, min(maxEventSize, 64<<10)), maxEventSize)
+	scanner.Split(scanEventLines)
+	return &EventReader{scanner: scanner}
+}
+
+// Next returns the next event with data. io.EOF is returned at the end of the stream,
+// an event interrupted by the end of the stream is still returned.
+func (r *EventReader) Next() (*Event, error) {
+	var event Event
+	var data bytes.Buffer
+	hasData := false
+	for r.scanner.Scan() {
+		line := r.scanner.Bytes()
+		if len(line) == 0 {
+			if hasData {
+				event.ID = r.lastID
+				event.Data = data.Bytes()
+				return &event, nil
+			}
+			event = Event{}
+			continue
+		}
+		if line[0] == ':' {
+			continue
+		}
+
+		field, value, _ := bytes.Cut(line, []byte(":"))
+		value = bytes.TrimPrefix(value, []byte(" "))
+		switch string(field) {
+		case "event":
+			event.Event = string(value)
+		case "data":
+			if hasData {
+				data.WriteByte('\n')
+			}
+			data.Write(value)
+			hasData = true
+		case "id":
+			if bytes.IndexByte(value, 0) < 0 {
+				r.lastID = string(value)
+			}
+		}
+	}
+
+	if err := r.scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return nil, ErrEventTooLarge
+		}
+		return nil, err
+	}
+	if hasData {
+		event.ID = r.lastID
+		event.Data = data.Bytes()
+		return &event, nil
+	}
+	return nil, io.EOF
+}
+
+// scanEventLines splits the stream into lines ended by "\r\n", "\n" or "\r"
+func scanEventLines(data []byte, atEOF bool) (int, []byte, error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		if data[i] == '\n' {
+			return i + 1, data[:i], nil
+		}
+		// "\r" may be the first half of "\r\n"
+		if i+1 < len(data) {
+			if data[i+1] == '\n' {
+				return i + 2, data[:i], nil
+			}
+			return i + 1, data[:i], nil
+		}
+		if atEOF {
+			return i + 1, data[:i], nil
+		}
+		return 0, nil, nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
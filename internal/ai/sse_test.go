@@ -0,0 +1,128 @@
+package ai
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestEventReader(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream string
+		// Events formatted as "event|id|data"
+		want    []string
+		wantErr error
+	}{
+		{
+			name:   "single event",
+			stream: "data: {\"a\":1}\n\n",
+			want:   []string{`||{"a":1}`},
+		},
+		{
+			name:   "multi-line data",
+			stream: "data: line 1\ndata:line 2\ndata\n\n",
+			want:   []string{"||line 1\nline 2\n"},
+		},
+		{
+			name:   "done marker",
+			stream: "data: {}\n\ndata: [DONE]\n\n",
+			want:   []string{"||{}", "||[DONE]"},
+		},
+		{
+			name:   "comments and keep-alives",
+			stream: ": keep-alive\n\n:\n\nevent: ping\n\ndata: x\n: inside\n\n",
+			want:   []string{"||x"},
+		},
+		{
+			name:   "crlf line endings",
+			stream: "event: delta\r\ndata: a\r\ndata: b\r\n\r\ndata: c\r\n\r\n",
+			want:   []string{"delta||a\nb", "||c"},
+		},
+		{
+			name:   "cr line endings",
+			stream: "event: delta\rdata: a\r\rdata: b\r\r",
+			want:   []string{"delta||a", "||b"},
+		},
+		{
+			name:   "ids are kept until changed",
+			stream: "id: 1\ndata: a\n\ndata: b\n\nid\ndata: c\n\n",
+			want:   []string{"|1|a", "|1|b", "||c"},
+		},
+		{
+			name:   "unknown fields",
+			stream: "retry: 1000\nfoo: bar\ndata: a\n\n",
+			want:   []string{"||a"},
+		},
+		{
+			name:   "error event",
+			stream: "event: error\ndata: {\"error\":{\"type\":\"overloaded_error\"}}\n\n",
+			want:   []string{`error||{"error":{"type":"overloaded_error"}}`},
+		},
+		{
+			name:   "in-stream error payload",
+			stream: "data: {\"choices\":[]}\n\ndata: {\"error\":{\"message\":\"server_error\"}}\n\n",
+			want:   []string{`||{"choices":[]}`, `||{"error":{"message":"server_error"}}`},
+		},
+		{
+			name:   "eof in the middle of an event",
+			stream: "data: a\n\nevent: delta\ndata: b",
+			want:   []string{"||a", "delta||b"},
+		},
+		{
+			name:   "eof after an event without data",
+			stream: "data: a\n\nevent: delta\n",
+			want:   []string{"||a"},
+		},
+		{
+			name:    "line over the limit",
+			stream:  "data: a\n\ndata: " + strings.Repeat("x", 200) + "\n\n",
+			want:    []string{"||a"},
+			wantErr: ErrEventTooLarge,
+		},
+	}
+
+	for _, tt := range tests {
+		for _, oneByte := range []bool{false, true} {
+			name := tt.name
+			var r io.Reader = strings.NewReader(tt.stream)
+			if oneByte {
+				// Line endings are split between reads
+				name += " by byte"
+				r = iotest.OneByteReader(r)
+			}
+			t.Run(name, func(t *testing.T) {
+				reader := NewEventReader(r, 128)
+				var got []string
+				var err error
+				for {
+					var event *Event
+					event, err = reader.Next()
+					if err != nil {
+						break
+					}
+					got = append(got, event.Event+"|"+event.ID+"|"+string(event.Data))
+				}
+
+				if strings.Join(got, "\n---\n") != strings.Join(tt.want, "\n---\n") {
+					t.Errorf("events = %q, want %q", got, tt.want)
+				}
+				wantErr := tt.wantErr
+				if wantErr == nil {
+					wantErr = io.EOF
+				}
+				if !errors.Is(err, wantErr) {
+					t.Errorf("error = %v, want %v", err, wantErr)
+				}
+			})
+		}
+	}
+}
+
+func TestEventDone(t *testing.T) {
+	if !(&Event{Data: []byte("[DONE]")}).Done() || (&Event{Data: []byte("[DONE] ")}).Done() {
+		t.Error("Done() doesn't match the end of stream marker exactly")
+	}
+}
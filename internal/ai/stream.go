@@ -0,0 +1,108 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+type StreamStatus int
+
+const (
+	StreamFinished StreamStatus = iota
+	StreamCanceled
+	StreamFailed
+)
+
+type Chunk struct {
+	Content string
+	// Reasoning summary or thinking of reasoning models, streamed before the answer
+	Reasoning string
+}
+
+// Stream delivers an answer chunk by chunk. The result of the generation is
+// available through Err and Status once the Chunks channel is closed.
+type Stream struct {
+	chunks    chan Chunk
+	err       error
+	usage     Usage
+	toolCalls []ToolCall
+}
+
+func NewStream() *Stream {
+	return &Stream{chunks: make(chan Chunk)}
+}
+
+func (s *Stream) Chunks() <-chan Chunk {
+	return s.chunks
+}
+
+// Err returns nil if the answer is complete, ErrCanceled if the request was
+// canceled and the provider error otherwise. Must be called after Chunks is closed.
+func (s *Stream) Err() error {
+	return s.err
+}
+
+// Usage returns the token usage reported by the provider. Must be called after Chunks is closed.
+func (s *Stream) Usage() Usage {
+	return s.usage
+}
+
+func (s *Stream) Status() StreamStatus {
+	switch {
+	case s.err == nil:
+		return StreamFinished
+	case errors.Is(s.err, ErrCanceled):
+		return StreamCanceled
+	default:
+		return StreamFailed
+	}
+}
+
+// Send is used by providers, it returns false if ctx is done before the chunk is received
+func (s *Stream) Send(ctx context.Context, chunk Chunk) bool {
+	select {
+	case s.chunks <- chunk:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// SetUsage is used by providers before Close
+func (s *Stream) SetUsage(usage Usage) {
+	if usage.TotalTokens == 0 {
+		usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	}
+	s.usage = usage
+}
+
+// ToolCalls returns the tools the model called instead of answering or along with the answer.
+// Must be called after Chunks is closed.
+func (s *Stream) ToolCalls() []ToolCall {
+	return s.toolCalls
+}
+
+// SetToolCalls is used by providers before Close
+func (s *Stream) SetToolCalls(calls []ToolCall) {
+	s.toolCalls = calls
+}
+
+// Close is used by providers to finish the stream. Context cancellation is reported as ErrCanceled.
+func (s *Stream) Close(err error) {
+	if errors.Is(err, context.Canceled) {
+		err = fmt.Errorf("%w: %w", ErrCanceled, err)
+	}
+	s.err = err
+	close(s.chunks)
+}
+
+// Collect reads the whole stream and returns the answer text
+func Collect(stream *Stream) (string, error) {
+	var sb strings.Builder
+	for chunk := range stream.Chunks() {
+		sb.WriteString(chunk.Content)
+	}
+	return sb.String(), stream.Err()
+}
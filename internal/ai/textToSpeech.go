@@ -0,0 +1,26 @@
+package ai
+
+import "context"
+
+const (
+	// SpeechFormatOpus is Opus in an OGG container
+	SpeechFormatOpus = "opus"
+	SpeechFormatMP3  = "mp3"
+)
+
+type TextToSpeech interface {
+	Synthesize(ctx context.Context, request SpeechRequest) (*Speech, error)
+}
+
+type SpeechRequest struct {
+	Model string
+	Text  string
+	Voice string
+	// Preferred audio format, providers may return another one
+	Format string
+}
+
+type Speech struct {
+	Data   []byte
+	Format string
+}
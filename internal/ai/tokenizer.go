@@ -0,0 +1,59 @@
+package ai
+
+import (
+	"math"
+	"strings"
+	"unicode/utf8"
+)
+
+type Tokenizer interface {
+	CountTokens(text string) int
+}
+
+// ApproxTokenizer estimates token counts from the text length. Non-ASCII text
+// (e.g. Cyrillic) is split into noticeably more tokens than English, so both
+// kinds of characters have their own ratio.
+type ApproxTokenizer struct {
+	ASCIICharsPerToken float64
+	OtherCharsPerToken float64
+	// Tokens added by the chat format to every message
+	MessageOverhead int
+}
+
+func (t ApproxTokenizer) CountTokens(text string) int {
+	if text == "" {
+		return t.MessageOverhead
+	}
+	ascii, other := 0, 0
+	for _, r := range text {
+		if r < utf8.RuneSelf {
+			ascii++
+		} else {
+			other++
+		}
+	}
+	tokens := float64(ascii)/t.ASCIICharsPerToken + float64(other)/t.OtherCharsPerToken
+	return int(math.Ceil(tokens)) + t.MessageOverhead
+}
+
+var (
+	tokenizerO200k   = ApproxTokenizer{ASCIICharsPerToken: 4, OtherCharsPerToken: 3, MessageOverhead: 4}
+	tokenizerCl100k  = ApproxTokenizer{ASCIICharsPerToken: 4, OtherCharsPerToken: 2, MessageOverhead: 4}
+	tokenizerClaude  = ApproxTokenizer{ASCIICharsPerToken: 3.5, OtherCharsPerToken: 2.5, MessageOverhead: 5}
+	tokenizerDefault = ApproxTokenizer{ASCIICharsPerToken: 3.5, OtherCharsPerToken: 2, MessageOverhead: 5}
+)
+
+// TokenizerFor returns the tokenizer matching the model API name
+func TokenizerFor(model string) Tokenizer {
+	switch {
+	case strings.HasPrefix(model, "gpt-4o"), strings.HasPrefix(model, "gpt-4.1"), strings.HasPrefix(model, "gpt-5"),
+		strings.HasPrefix(model, "o1"), strings.HasPrefix(model, "o3"), strings.HasPrefix(model, "o4"):
+		return tokenizerO200k
+	case strings.HasPrefix(model, "gpt-4"), strings.HasPrefix(model, "gpt-3.5"):
+		return tokenizerCl100k
+	case strings.HasPrefix(model, "claude"):
+		return tokenizerClaude
+	default:
+		return tokenizerDefault
+	}
+}
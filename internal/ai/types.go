@@ -1,14 +1,119 @@
 package ai
 
+import (
+	"encoding/json"
+	"strings"
+)
+
+const (
+	RoleSystem    = "system"
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+	// RoleTool messages carry tool results, ToolCallID links them to the call
+	RoleTool = "tool"
+)
+
 type ChatRequest struct {
 	Model    string    `json:"model"`
 	Messages []Message `json:"messages"`
 	Stream   bool      `json:"stream"`
 	User     string    `json:"user"`
+	// Tools the model may call instead of answering
+	Tools []Tool `json:"tools"`
+	// Generation parameters, nil and empty values leave the defaults of the model
+	Temperature *float64 `json:"temperature"`
+	TopP        *float64 `json:"top_p"`
+	// Limit of generated tokens, including the reasoning tokens of reasoning models
+	MaxTokens       int    `json:"max_tokens"`
+	ReasoningEffort string `json:"reasoning_effort"`
+}
+
+// Reasoning effort of reasoning models
+const (
+	EffortLow    = "low"
+	EffortMedium = "medium"
+	EffortHigh   = "high"
+)
+
+// Tool describes a function the model can call
+type Tool struct {
+	Name        string
+	Description string
+	// JSON schema of the arguments object
+	Parameters json.RawMessage
+}
+
+// ToolCall is a request of the model to run a tool, Arguments is a JSON object
+type ToolCall struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+type Usage struct {
+	PromptTokens     int
+	CompletionTokens int
+	TotalTokens      int
+	CachedTokens     int
+	ReasoningTokens  int
 }
 
 type Message struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
-	Refusal string `json:"refusal"`
+	Role    string
+	Content []ContentPart
+	// Tools called by the assistant
+	ToolCalls []ToolCall
+	// Call answered by a RoleTool message
+	ToolCallID string
+}
+
+type ContentPartType int
+
+const (
+	PartText ContentPartType = iota
+	PartImage
+)
+
+type ContentPart struct {
+	Type  ContentPartType
+	Text  string
+	Image *ImageContent
+}
+
+type ImageContent struct {
+	MIMEType string
+	Data     []byte
+}
+
+func TextMessage(role string, text string) Message {
+	return Message{Role: role, Content: []ContentPart{{Type: PartText, Text: text}}}
+}
+
+// Text returns all text parts of the message
+func (m Message) Text() string {
+	var sb strings.Builder
+	for _, part := range m.Content {
+		if part.Type != PartText {
+			continue
+		}
+		if sb.Len() > 0 {
+			sb.WriteString("\n")
+		}
+		sb.WriteString(part.Text)
+	}
+	return sb.String()
+}
+
+// HasImages reports whether the message contains image parts
+func (m Message) HasImages() bool {
+	for _, part := range m.Content {
+		if part.Type == PartImage {
+			return true
+		}
+	}
+	return false
+}
+
+func ToolResultMessage(toolCallID string, result string) Message {
+	return Message{Role: RoleTool, Content: []ContentPart{{Type: PartText, Text: result}}, ToolCallID: toolCallID}
 }
@@ -1,8 +1,13 @@
 package config
 
 import (
+	"errors"
 	"flag"
+	"fmt"
+	"net/url"
 	"os"
+	"regexp"
+	"time"
 
 	cleanenv "github.com/ilyakaznacheev/cleanenv"
 )
@@ -13,7 +18,119 @@ type Config struct {
 	TgAdmin     int64  `yaml:"tg_admin" env-required:"true"`
 	DbDriver    string `yaml:"db_driver" env-default:"sqlite"`
 	StoragePath string `yaml:"storage_path" env-required:"true"`
-	OpenAiToken string `yaml:"openai_token" env-required:"true"`
+	// Shortcuts for the openai and anthropic providers with the default settings
+	OpenAiToken    string `yaml:"openai_token"`
+	AnthropicToken string `yaml:"anthropic_token"`
+	// AI provider APIs by the provider names of the AI models
+	Providers          map[string]*ProviderConfig `yaml:"providers"`
+	ContextTokenBudget int                        `yaml:"context_token_budget" env-default:"16000"`
+	// "fake" answers AI requests with fixtures or generated responses instead of the providers
+	AIBackend     string        `yaml:"ai_backend" env-default:"real"`
+	FakeFixtures  string        `yaml:"fake_fixtures"`
+	FakeDelay     time.Duration `yaml:"fake_delay" env-default:"50ms"`
+	FakeFailEvery int           `yaml:"fake_fail_every"`
+	// Responses of the real providers are saved as fixtures to this directory if set
+	RecordFixtures string           `yaml:"record_fixtures"`
+	Moderation     ModerationConfig `yaml:"moderation"`
+	// "polling" gets updates with getUpdates, "webhook" receives them with the HTTP server of the webhook config
+	Updates    string           `yaml:"updates" env-default:"polling"`
+	Webhook    WebhookConfig    `yaml:"webhook"`
+	Dispatcher DispatcherConfig `yaml:"dispatcher"`
+	// Time the requests in progress have to finish at shutdown, answers still streaming
+	// after it are interrupted and saved as they are
+	ShutdownTimeout time.Duration  `yaml:"shutdown_timeout" env-default:"30s"`
+	TgLimits        TgLimitsConfig `yaml:"tg_limits"`
+}
+
+// TgLimitsConfig are the rates of the requests sent to Telegram
+type TgLimitsConfig struct {
+	GlobalPerSecond float64 `yaml:"global_per_second" env-default:"30"`
+	ChatPerSecond   float64 `yaml:"chat_per_second" env-default:"1"`
+	GroupPerMinute  float64 `yaml:"group_per_minute" env-default:"20"`
+	// Requests a chat can send at once before its rate applies
+	ChatBurst int `yaml:"chat_burst" env-default:"3"`
+}
+
+type DispatcherConfig struct {
+	// Updates handled at once, updates of one user are always handled one by one
+	Workers int `yaml:"workers" env-default:"32"`
+	// Updates waiting or running at once, receiving the updates waits when it's reached
+	MaxQueued int `yaml:"max_queued" env-default:"1000"`
+	// Updates of one user waiting at once, the next ones are dropped
+	MaxUserQueued int `yaml:"max_user_queued" env-default:"10"`
+}
+
+const (
+	UpdatesPolling = "polling"
+	UpdatesWebhook = "webhook"
+)
+
+type WebhookConfig struct {
+	// Public HTTPS URL Telegram sends the updates to, e.g. https://bot.example.com/tg/webhook
+	URL    string `yaml:"url"`
+	Listen string `yaml:"listen" env-default:":8443"`
+	// Path the server handles, the path of the URL if empty. Differs from it behind a reverse proxy
+	// that rewrites paths
+	Path string `yaml:"path"`
+	// Sent by Telegram in the X-Telegram-Bot-Api-Secret-Token header, requests without it are rejected.
+	// Required, otherwise anyone who finds the URL could send updates
+	SecretToken string `yaml:"secret_token"`
+	// The server serves TLS with the certificate and the key, plain HTTP behind a reverse proxy if empty
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// The certificate is uploaded to Telegram with setWebhook
+	SelfSigned         bool `yaml:"self_signed"`
+	MaxConnections     int  `yaml:"max_connections" env-default:"40"`
+	DropPendingUpdates bool `yaml:"drop_pending_updates"`
+}
+
+// ModeratorLocal checks texts with the patterns of the moderation config instead of a provider API
+const ModeratorLocal = "local"
+
+type ModerationConfig struct {
+	// Provider name whose moderation API is used or "local", empty disables the moderation
+	Moderator string `yaml:"moderator"`
+	Model     string `yaml:"model" env-default:"omni-moderation-latest"`
+	// Answers are checked too, flagged answers are deleted
+	CheckAnswers bool `yaml:"check_answers"`
+	// Regular expressions of the local moderator by category
+	Patterns map[string][]string `yaml:"patterns"`
+	// Users whose messages were flagged this many times within the window are blocked, 0 disables blocking
+	BlockAfter  int           `yaml:"block_after" env-default:"3"`
+	BlockWindow time.Duration `yaml:"block_window" env-default:"24h"`
+}
+
+const AIBackendFake = "fake"
+
+const (
+	// ProviderTypeOpenAI is the API of OpenAI and compatible backends: Azure OpenAI, OpenRouter, vLLM, LM Studio
+	ProviderTypeOpenAI    = "openai"
+	ProviderTypeAnthropic = "anthropic"
+)
+
+// Base URLs used when a provider of the same name has none
+var defaultBaseURLs = map[string]string{
+	ProviderTypeOpenAI:    "https://api.openai.com/v1",
+	ProviderTypeAnthropic: "https://api.anthropic.com/v1",
+}
+
+const defaultProviderTimeout = time.Minute
+
+type ProviderConfig struct {
+	// API of the provider, the provider name if empty
+	Type    string `yaml:"type"`
+	BaseURL string `yaml:"base_url"`
+	Token   string `yaml:"token"`
+	// bearer (default), api-key or azure, for the openai type only
+	Auth string `yaml:"auth"`
+	// api-version query parameter, required by Azure
+	APIVersion string        `yaml:"api_version"`
+	Proxy      string        `yaml:"proxy"`
+	Timeout    time.Duration `yaml:"timeout"`
+	// Extra headers sent with every request
+	Headers      map[string]string `yaml:"headers"`
+	Organization string            `yaml:"organization"`
+	Project      string            `yaml:"project"`
 }
 
 func MustLoad() *Config {
@@ -31,6 +148,21 @@ func MustLoad() *Config {
 	if err := cleanenv.ReadConfig(path, &cfg); err != nil {
 		panic("failed to read config: " + err.Error())
 	}
+	if err := cfg.setupProviders(); err != nil {
+		panic("failed to read config: " + err.Error())
+	}
+	if err := cfg.checkModeration(); err != nil {
+		panic("failed to read config: " + err.Error())
+	}
+	if err := cfg.setupUpdates(); err != nil {
+		panic("failed to read config: " + err.Error())
+	}
+	if err := cfg.checkDispatcher(); err != nil {
+		panic("failed to read config: " + err.Error())
+	}
+	if err := cfg.checkTgLimits(); err != nil {
+		panic("failed to read config: " + err.Error())
+	}
 
 	return &cfg
 }
@@ -47,3 +179,112 @@ func fetchConfigPath() string {
 
 	return res
 }
+
+// setupProviders adds the providers set by the token shortcuts and fills the defaults
+func (cfg *Config) setupProviders() error {
+	if cfg.Providers == nil {
+		cfg.Providers = map[string]*ProviderConfig{}
+	}
+	shortcuts := map[string]string{
+		ProviderTypeOpenAI:    cfg.OpenAiToken,
+		ProviderTypeAnthropic: cfg.AnthropicToken,
+	}
+	for name, token := range shortcuts {
+		// The fake backend needs no tokens
+		if _, ok := cfg.Providers[name]; !ok && (token != "" || cfg.AIBackend == AIBackendFake) {
+			cfg.Providers[name] = &ProviderConfig{Token: token}
+		}
+	}
+	if len(cfg.Providers) == 0 {
+		return errors.New("no AI providers configured")
+	}
+
+	for name, provider := range cfg.Providers {
+		if provider.Type == "" {
+			provider.Type = name
+		}
+		if _, ok := defaultBaseURLs[provider.Type]; !ok {
+			return fmt.Errorf("provider %s: unknown type '%s'", name, provider.Type)
+		}
+		if provider.BaseURL == "" {
+			if name != provider.Type {
+				return fmt.Errorf("provider %s: base_url is required", name)
+			}
+			provider.BaseURL = defaultBaseURLs[provider.Type]
+		}
+		if provider.Timeout == 0 {
+			provider.Timeout = defaultProviderTimeout
+		}
+	}
+	return nil
+}
+
+func (cfg *Config) checkModeration() error {
+	moderator := cfg.Moderation.Moderator
+	if moderator == "" || moderator == ModeratorLocal {
+		return nil
+	}
+	provider, ok := cfg.Providers[moderator]
+	if !ok {
+		return fmt.Errorf("moderation: unknown provider '%s'", moderator)
+	}
+	if provider.Type != ProviderTypeOpenAI {
+		return fmt.Errorf("moderation: provider %s has no moderation API", moderator)
+	}
+	return nil
+}
+
+// Characters allowed by Telegram in the secret token of a webhook
+var secretTokenRe = regexp.MustCompile(`^[A-Za-z0-9_-]{1,256}$`)
+
+// setupUpdates checks the webhook config and fills the handler path
+func (cfg *Config) setupUpdates() error {
+	switch cfg.Updates {
+	case UpdatesPolling:
+		return nil
+	case UpdatesWebhook:
+	default:
+		return fmt.Errorf("updates: unknown mode '%s'", cfg.Updates)
+	}
+
+	wh := &cfg.Webhook
+	u, err := url.Parse(wh.URL)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("webhook: url must be an absolute https URL, got '%s'", wh.URL)
+	}
+	if wh.Path == "" {
+		wh.Path = u.Path
+	}
+	if wh.Path == "" {
+		wh.Path = "/"
+	}
+	if wh.SecretToken == "" {
+		return errors.New("webhook: secret_token is required")
+	}
+	if !secretTokenRe.MatchString(wh.SecretToken) {
+		return errors.New("webhook: secret_token must be 1-256 characters A-Z, a-z, 0-9, _ and -")
+	}
+	if (wh.CertFile == "") != (wh.KeyFile == "") {
+		return errors.New("webhook: cert_file and key_file must be set together")
+	}
+	if wh.SelfSigned && wh.CertFile == "" {
+		return errors.New("webhook: self_signed requires cert_file")
+	}
+	return nil
+}
+
+func (cfg *Config) checkDispatcher() error {
+	d := cfg.Dispatcher
+	if d.Workers < 1 || d.MaxQueued < 1 || d.MaxUserQueued < 1 {
+		return errors.New("dispatcher: workers, max_queued and max_user_queued must be positive")
+	}
+	return nil
+}
+
+func (cfg *Config) checkTgLimits() error {
+	l := cfg.TgLimits
+	if l.GlobalPerSecond <= 0 || l.ChatPerSecond <= 0 || l.GroupPerMinute <= 0 || l.ChatBurst < 1 {
+		return errors.New("tg_limits: all limits must be positive")
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package extract
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"mime"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+var (
+	ErrUnsupportedType = errors.New("unsupported file type")
+	ErrNoText          = errors.New("no text found")
+)
+
+// Extractor extracts plain text from the contents of a file
+type Extractor interface {
+	Extract(data []byte) (string, error)
+}
+
+type ExtractorFunc func(data []byte) (string, error)
+
+func (f ExtractorFunc) Extract(data []byte) (string, error) {
+	return f(data)
+}
+
+var (
+	mu         sync.RWMutex
+	extractors = map[string]Extractor{} // [MIME type] Extractor
+	extensions = map[string]string{}    // [extension] MIME type
+)
+
+func init() {
+	Register(ExtractorFunc(extractText), "text/plain", ".txt")
+	Register(ExtractorFunc(extractText), "text/markdown", ".md")
+	Register(ExtractorFunc(extractText), "text/csv", ".csv")
+	Register(ExtractorFunc(extractText), "text/x-go", ".go")
+	Register(ExtractorFunc(extractPDF), "application/pdf", ".pdf")
+}
+
+// Register sets the extractor for the MIME type. Extensions are used when the MIME type
+// of a file is unknown or generic, e.g. application/octet-stream.
+func Register(e Extractor, mimeType string, exts ...string) {
+	mu.Lock()
+	defer mu.Unlock()
+	extractors[mimeType] = e
+	for _, ext := range exts {
+		extensions[strings.ToLower(ext)] = mimeType
+	}
+}
+
+// Extract finds the extractor by the MIME type or the file extension and extracts the text
+func Extract(mimeType, fileName string, data []byte) (string, error) {
+	e, ok := find(mimeType, fileName)
+	if !ok {
+		return "", fmt.Errorf("Extract(): %w: '%s'", ErrUnsupportedType, mimeType)
+	}
+	text, err := e.Extract(data)
+	if err != nil {
+		return "", fmt.Errorf("Extract(): %w", err)
+	}
+	if strings.TrimSpace(text) == "" {
+		return "", fmt.Errorf("Extract(): %w", ErrNoText)
+	}
+	return text, nil
+}
+
+// Supported reports whether there is an extractor for the file
+func Supported(mimeType, fileName string) bool {
+	_, ok := find(mimeType, fileName)
+	return ok
+}
+
+// Extensions returns the registered file extensions
+func Extensions() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	res := make([]string, 0, len(extensions))
+	for ext := range extensions {
+		res = append(res, ext)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func find(mimeType, fileName string) (Extractor, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	if mediaType, _, err := mime.ParseMediaType(mimeType); err == nil {
+		if e, ok := extractors[mediaType]; ok {
+			return e, true
+		}
+	}
+	if mimeType, ok := extensions[strings.ToLower(path.Ext(fileName))]; ok {
+		return extractors[mimeType], true
+	}
+	return nil, false
+}
+
+func extractText(data []byte) (string, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	if !utf8.Valid(data) {
+		return "", errors.New("text is not valid UTF-8")
+	}
+	return strings.ReplaceAll(string(data), "\r\n", "\n"), nil
+}
@@ -0,0 +1,50 @@
+package extract
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestExtract(t *testing.T) {
+	tests := []struct {
+		name     string
+		mimeType string
+		fileName string
+		data     string
+		want     string
+		wantErr  bool
+		// Error in the chain, if any is expected
+		errIs error
+	}{
+		{name: "text", mimeType: "text/plain; charset=utf-8", fileName: "a.txt", data: "line 1\r\nline 2", want: "line 1\nline 2"},
+		{name: "bom", mimeType: "text/markdown", fileName: "a.md", data: "\xef\xbb\xbf# Title", want: "# Title"},
+		{name: "by extension", mimeType: "application/octet-stream", fileName: "main.GO", data: "package main", want: "package main"},
+		{name: "unsupported", mimeType: "image/png", fileName: "a.png", data: "png", wantErr: true, errIs: ErrUnsupportedType},
+		{name: "empty", mimeType: "text/plain", fileName: "a.txt", data: " \n ", wantErr: true, errIs: ErrNoText},
+		{name: "invalid utf-8", mimeType: "text/csv", fileName: "a.csv", data: "a,\xff", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, err := Extract(tt.mimeType, tt.fileName, []byte(tt.data))
+			if tt.wantErr {
+				if err == nil || (tt.errIs != nil && !errors.Is(err, tt.errIs)) {
+					t.Fatalf("error = %v, want %v", err, tt.errIs)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if text != tt.want {
+				t.Errorf("text = %q, want %q", text, tt.want)
+			}
+		})
+	}
+}
+
+func TestSupported(t *testing.T) {
+	if !Supported("", "report.pdf") || Supported("", "photo.jpg") {
+		t.Error("files are matched by extension incorrectly")
+	}
+}
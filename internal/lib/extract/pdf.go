@@ -0,0 +1,314 @@
+package extract
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	// Limit of a single decompressed stream, protects from zip bombs
+	pdfStreamMaxSize = 16 << 20
+	// Limit of all decompressed streams of a document, protects from many small zip bombs
+	pdfDecodedMaxSize = 32 << 20
+	// Share of readable runes below which the text is considered to be in an unsupported encoding
+	pdfMinReadableShare = 0.8
+)
+
+var errPDFEncrypted = errors.New("PDF is encrypted")
+
+// Streams that never contain page text
+var pdfSkipStreams = [][]byte{
+	[]byte("/Image"), []byte("/FontFile"), []byte("/Length1"), []byte("/XRef"),
+	[]byte("/ObjStm"), []byte("/Metadata"), []byte("/ICCBased"), []byte("/N "),
+}
+
+// extractPDF extracts text from the content streams of a PDF. Only uncompressed and
+// FlateDecode streams and single byte font encodings are supported, which covers text
+// exported by most office tools. Scanned documents have no text at all.
+func extractPDF(data []byte) (string, error) {
+	if !bytes.HasPrefix(data, []byte("%PDF")) {
+		return "", errors.New("not a PDF file")
+	}
+	// Streams of encrypted documents can't be read without the key
+	if bytes.Contains(data, []byte("/Encrypt")) {
+		return "", errPDFEncrypted
+	}
+
+	var sb strings.Builder
+	for _, stream := range pdfStreams(data) {
+		pdfContentText(&sb, stream)
+	}
+
+	text := strings.TrimSpace(sb.String())
+	if text == "" {
+		return "", ErrNoText
+	}
+	if readableShare(text) < pdfMinReadableShare {
+		return "", errors.New("text of the PDF is in an unsupported encoding")
+	}
+	return text, nil
+}
+
+// pdfStreams returns the decoded streams that may contain page content.
+// Streams after pdfDecodedMaxSize bytes were decompressed are left out.
+func pdfStreams(data []byte) [][]byte {
+	var res [][]byte
+	decodedSize := 0
+	for pos := 0; decodedSize < pdfDecodedMaxSize; {
+		i := bytes.Index(data[pos:], []byte("stream"))
+		if i < 0 {
+			return res
+		}
+		start := pos + i
+		pos = start + len("stream")
+
+		// The keyword must follow the stream dictionary, this also skips "endstream"
+		before := bytes.TrimRight(data[:start], " \t\r\n")
+		if !bytes.HasSuffix(before, []byte(">>")) {
+			continue
+		}
+		objStart := bytes.LastIndex(before, []byte(" obj"))
+		if objStart < 0 {
+			continue
+		}
+		dict := before[objStart:]
+
+		body := data[pos:]
+		body = bytes.TrimPrefix(body, []byte("\r"))
+		body = bytes.TrimPrefix(body, []byte("\n"))
+		end := bytes.Index(body, []byte("endstream"))
+		if end < 0 {
+			return res
+		}
+		body = body[:end]
+		pos += end
+
+		if skipPDFStream(dict) {
+			continue
+		}
+		if !bytes.Contains(dict, []byte("/Filter")) {
+			res = append(res, body)
+			continue
+		}
+		if !bytes.Contains(dict, []byte("/FlateDecode")) || bytes.Count(dict, []byte("Decode")) > 1 {
+			continue
+		}
+		zr, err := zlib.NewReader(bytes.NewReader(body))
+		if err != nil {
+			continue
+		}
+		// Truncated streams are still useful, so read errors are ignored
+		decoded, _ := io.ReadAll(io.LimitReader(zr, int64(min(pdfStreamMaxSize, pdfDecodedMaxSize-decodedSize))))
+		_ = zr.Close()
+		decodedSize += len(decoded)
+		res = append(res, decoded)
+	}
+	return res
+}
+
+func skipPDFStream(dict []byte) bool {
+	for _, s := range pdfSkipStreams {
+		if bytes.Contains(dict, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// pdfContentText writes the text shown by the operators of a content stream
+func pdfContentText(sb *strings.Builder, content []byte) {
+	var operands []string
+	var spaced bool
+	inText := false
+	for i := 0; i < len(content); {
+		c := content[i]
+		switch {
+		case c == '%':
+			for i < len(content) && content[i] != '\n' && content[i] != '\r' {
+				i++
+			}
+		case c == '(':
+			s, n := pdfLiteralString(content[i:])
+			operands = append(operands, s)
+			i += n
+		case c == '<' && i+1 < len(content) && content[i+1] != '<':
+			end := bytes.IndexByte(content[i:], '>')
+			if end < 0 {
+				return
+			}
+			operands = append(operands, pdfHexString(content[i+1:i+end]))
+			i += end + 1
+		case c == '/':
+			// Names are only operands of operators without text
+			i++
+			for i < len(content) && !isPDFSpace(content[i]) && !isPDFDelimiter(content[i]) {
+				i++
+			}
+		case isPDFDelimiter(c) || isPDFSpace(c):
+			i++
+		default:
+			start := i
+			for i < len(content) && !isPDFSpace(content[i]) && !isPDFDelimiter(content[i]) {
+				i++
+			}
+			token := string(content[start:i])
+			if token == "" {
+				i++
+				continue
+			}
+			if c == '-' || c == '+' || c == '.' || (c >= '0' && c <= '9') {
+				// Large negative kerning in TJ arrays separates words
+				if n, err := strconv.ParseFloat(token, 64); err == nil && n < -200 && !spaced {
+					operands = append(operands, " ")
+					spaced = true
+				}
+				continue
+			}
+
+			switch token {
+			case "BT":
+				inText = true
+			case "ET":
+				inText = false
+				sb.WriteString("\n")
+			case "Tj", "TJ", "'", "\"":
+				if inText {
+					if token != "Tj" && token != "TJ" {
+						sb.WriteString("\n")
+					}
+					sb.WriteString(strings.Join(operands, ""))
+				}
+			case "Td", "TD", "T*":
+				if inText {
+					sb.WriteString("\n")
+				}
+			}
+			operands = operands[:0]
+			spaced = false
+		}
+	}
+}
+
+// pdfLiteralString decodes a (string) with escapes and balanced parentheses,
+// returns the string and the number of consumed bytes
+func pdfLiteralString(data []byte) (string, int) {
+	var res []rune
+	depth := 0
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch c {
+		case '(':
+			depth++
+			if depth == 1 {
+				continue
+			}
+		case ')':
+			depth--
+			if depth == 0 {
+				return string(res), i + 1
+			}
+		case '\\':
+			i++
+			if i >= len(data) {
+				return string(res), i
+			}
+			switch e := data[i]; e {
+			case 'n':
+				res = append(res, '\n')
+			case 'r', 'b', 'f':
+			case 't':
+				res = append(res, '\t')
+			case '\r', '\n':
+				// Line continuation
+			default:
+				if e >= '0' && e <= '7' {
+					n := 0
+					j := i
+					for ; j < len(data) && j < i+3 && data[j] >= '0' && data[j] <= '7'; j++ {
+						n = n*8 + int(data[j]-'0')
+					}
+					res = append(res, rune(n&0xff))
+					i = j - 1
+					continue
+				}
+				res = append(res, rune(e))
+			}
+			continue
+		}
+		// Single byte encodings are close enough to Latin-1 for text extraction
+		res = append(res, rune(c))
+	}
+	return string(res), len(data)
+}
+
+// pdfHexString decodes a <hex> string. Two byte strings with zero high bytes are
+// treated as UTF-16, which is how many generators encode plain Latin text.
+func pdfHexString(data []byte) string {
+	var b []byte
+	var hi byte
+	odd := false
+	for _, c := range data {
+		v, ok := hexValue(c)
+		if !ok {
+			continue
+		}
+		if odd {
+			b = append(b, hi<<4|v)
+		} else {
+			hi = v
+		}
+		odd = !odd
+	}
+	if odd {
+		b = append(b, hi<<4)
+	}
+
+	utf16 := len(b)%2 == 0 && len(b) > 0
+	for i := 0; utf16 && i < len(b); i += 2 {
+		utf16 = b[i] == 0
+	}
+	var res []rune
+	for i := 0; i < len(b); i++ {
+		if utf16 {
+			i++
+		}
+		res = append(res, rune(b[i]))
+	}
+	return string(res)
+}
+
+func hexValue(c byte) (byte, bool) {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0', true
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10, true
+	case c >= 'A' && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
+}
+
+func isPDFSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '\f' || c == 0
+}
+
+func isPDFDelimiter(c byte) bool {
+	return strings.IndexByte("()<>[]{}/%", c) >= 0
+}
+
+func readableShare(text string) float64 {
+	total, readable := 0, 0
+	for _, r := range text {
+		total++
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsSpace(r) || unicode.IsPunct(r) {
+			readable++
+		}
+	}
+	return float64(readable) / float64(total)
+}
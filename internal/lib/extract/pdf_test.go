@@ -0,0 +1,81 @@
+package extract
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestExtractPDF(t *testing.T) {
+	tests := []struct {
+		file    string
+		want    string
+		wantErr error
+	}{
+		{file: "plain.pdf", want: "Hello, PDF!\nKerned words(escaped)\n\nSecond"},
+		{file: "flate.pdf", want: "Hello, PDF!\nKerned words(escaped)\n\nSecond"},
+		// The content of the last page is cut off
+		{file: "truncated.pdf", want: "Hello, PDF!\nKerned words(escaped)"},
+		{file: "encrypted.pdf", wantErr: errPDFEncrypted},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			data, err := os.ReadFile("testdata/" + tt.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			text, err := extractPDF(data)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if text != tt.want {
+				t.Errorf("text = %q, want %q", text, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractPDFNotPDF(t *testing.T) {
+	if _, err := extractPDF([]byte("hello")); err == nil {
+		t.Error("no error for a file without the PDF header")
+	}
+}
+
+// Many small streams that inflate to pdfStreamMaxSize/4 each must not be decompressed past the total limit
+func TestPDFStreamsDecodedLimit(t *testing.T) {
+	var bomb bytes.Buffer
+	zw := zlib.NewWriter(&bomb)
+	_, _ = zw.Write(bytes.Repeat([]byte("A"), pdfStreamMaxSize/4))
+	_ = zw.Close()
+
+	var data bytes.Buffer
+	data.WriteString("%PDF-1.4\n")
+	streams := 4*pdfDecodedMaxSize/pdfStreamMaxSize + 4
+	for i := range streams {
+		fmt.Fprintf(&data, "%d 0 obj\n<< /Length %d /Filter /FlateDecode >>\nstream\n", i+1, bomb.Len())
+		data.Write(bomb.Bytes())
+		data.WriteString("\nendstream\nendobj\n")
+	}
+
+	size := 0
+	decoded := pdfStreams(data.Bytes())
+	for _, stream := range decoded {
+		size += len(stream)
+	}
+	if size > pdfDecodedMaxSize {
+		t.Errorf("decoded %d bytes, limit %d", size, pdfDecodedMaxSize)
+	}
+	if len(decoded) >= streams {
+		t.Errorf("all %d streams are decoded", streams)
+	}
+}
@@ -0,0 +1,454 @@
+// Package tgmarkdown converts Markdown written by AI models to Telegram MarkdownV2.
+//
+// The text is parsed to a CommonMark AST with the GFM extensions and every node is rendered
+// with the MarkdownV2 entity Telegram has for it. Nodes without one are rendered as text:
+// headings become bold lines, lists get bullets and numbers, tables become preformatted blocks.
+// Everything that is not markup is escaped, so the result is always accepted by Telegram.
+package tgmarkdown
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	east "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+var md = goldmark.New(goldmark.WithExtensions(extension.Table, extension.Strikethrough, extension.TaskList))
+
+// Characters that must be escaped in MarkdownV2 text
+const specialChars = "_*[]()~`>#+-=|{}.!\\"
+
+// Languages of code blocks, e.g. go, c++, c#, objective-c
+var languageRe = regexp.MustCompile(`^[A-Za-z0-9_+#.-]*$`)
+
+// URL schemes Telegram accepts in links, links with other ones are rendered as their text
+var linkSchemes = map[string]bool{"http": true, "https": true, "tg": true, "mailto": true}
+
+const (
+	bulletMarker  = "•"
+	thematicBreak = "——————"
+	taskDone      = "☑"
+	taskTodo      = "☐"
+)
+
+// Render converts the Markdown to MarkdownV2
+func Render(markdown string) string {
+	source := []byte(markdown)
+	doc := md.Parser().Parse(text.NewReader(source))
+	r := &renderer{source: source, active: map[string]bool{}}
+	return r.blocks(doc, "\n\n")
+}
+
+type renderer struct {
+	source []byte
+	// Entities the inline being rendered is inside of, Telegram doesn't allow them to be nested in themselves
+	active map[string]bool
+	// Blockquotes can't be nested, the inner ones are rendered as plain lines
+	inQuote bool
+	// Blockquotes must start lines, in list items they are rendered as plain lines
+	inList int
+}
+
+// blocks renders the children of the node separated by sep
+func (r *renderer) blocks(n ast.Node, sep string) string {
+	var parts []string
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		if s := r.block(c); s != "" {
+			parts = append(parts, s)
+		}
+	}
+	return strings.Join(parts, sep)
+}
+
+func (r *renderer) block(n ast.Node) string {
+	switch n := n.(type) {
+	case *ast.Paragraph, *ast.TextBlock:
+		return r.inlines(n)
+	case *ast.Heading:
+		return r.styled(n, "*")
+	case *ast.ThematicBreak:
+		return thematicBreak
+	case *ast.CodeBlock:
+		return r.pre("", r.lines(n))
+	case *ast.FencedCodeBlock:
+		return r.pre(string(n.Language(r.source)), r.lines(n))
+	case *ast.HTMLBlock:
+		lines := r.lines(n)
+		if n.HasClosure() {
+			lines += string(n.ClosureLine.Value(r.source))
+		}
+		return escape(strings.TrimRight(lines, "\n"))
+	case *ast.Blockquote:
+		return r.blockquote(n)
+	case *ast.List:
+		return r.list(n)
+	case *east.Table:
+		return r.table(n)
+	}
+	return r.blocks(n, "\n\n")
+}
+
+func (r *renderer) lines(n ast.Node) string {
+	var sb strings.Builder
+	lines := n.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		line := lines.At(i)
+		sb.Write(line.Value(r.source))
+	}
+	return sb.String()
+}
+
+// pre renders a code block. Code blocks can't be inside blockquotes, there they are plain lines
+func (r *renderer) pre(lang, code string) string {
+	code = strings.TrimRight(code, "\n")
+	if r.inQuote {
+		return escape(code)
+	}
+	if !languageRe.MatchString(lang) {
+		lang = ""
+	}
+	return "```" + lang + "\n" + escapeCode(code) + "\n```"
+}
+
+func (r *renderer) blockquote(n *ast.Blockquote) string {
+	if r.inQuote || r.inList > 0 {
+		return r.blocks(n, "\n")
+	}
+	r.inQuote = true
+	content := r.blocks(n, "\n")
+	r.inQuote = false
+	return ">" + strings.ReplaceAll(content, "\n", "\n>")
+}
+
+func (r *renderer) list(n *ast.List) string {
+	sep := "\n"
+	if !n.IsTight {
+		sep = "\n\n"
+	}
+	var items []string
+	number := n.Start
+	for item := n.FirstChild(); item != nil; item = item.NextSibling() {
+		marker := bulletMarker
+		if n.IsOrdered() {
+			marker = fmt.Sprintf("%d%c", number, n.Marker)
+			number++
+		}
+		r.inList++
+		content := r.blocks(item, sep)
+		r.inList--
+		// Lines of the item are indented under its text
+		indent := strings.Repeat(" ", utf8.RuneCountInString(marker)+1)
+		items = append(items, escape(marker)+" "+strings.ReplaceAll(content, "\n", "\n"+indent))
+	}
+	return strings.Join(items, sep)
+}
+
+// table renders the table as a preformatted block with aligned columns
+func (r *renderer) table(n *east.Table) string {
+	var rows [][]string
+	for row := n.FirstChild(); row != nil; row = row.NextSibling() {
+		var cells []string
+		for cell := row.FirstChild(); cell != nil; cell = cell.NextSibling() {
+			cells = append(cells, strings.TrimSpace(r.plain(cell)))
+		}
+		rows = append(rows, cells)
+	}
+
+	widths := make([]int, len(n.Alignments))
+	for _, cells := range rows {
+		for i, cell := range cells {
+			if i < len(widths) {
+				widths[i] = max(widths[i], utf8.RuneCountInString(cell))
+			}
+		}
+	}
+
+	var lines []string
+	for i, cells := range rows {
+		var line []string
+		for j, width := range widths {
+			cell := ""
+			if j < len(cells) {
+				cell = cells[j]
+			}
+			pad := strings.Repeat(" ", width-utf8.RuneCountInString(cell))
+			if n.Alignments[j] == east.AlignRight {
+				line = append(line, pad+cell)
+			} else {
+				line = append(line, cell+pad)
+			}
+		}
+		lines = append(lines, strings.TrimRight(strings.Join(line, " | "), " "))
+		// The header is the first row
+		if i == 0 {
+			var sep []string
+			for _, width := range widths {
+				sep = append(sep, strings.Repeat("-", width))
+			}
+			lines = append(lines, strings.Join(sep, "-|-"))
+		}
+	}
+	return r.pre("", strings.Join(lines, "\n"))
+}
+
+// inlines renders the inline children of the node
+func (r *renderer) inlines(n ast.Node) string {
+	var w writer
+	r.inlineChildren(&w, n)
+	return strings.TrimSpace(w.String())
+}
+
+func (r *renderer) inlineChildren(w *writer, n ast.Node) {
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		r.inline(w, c)
+	}
+}
+
+func (r *renderer) inline(w *writer, n ast.Node) {
+	switch n := n.(type) {
+	case *ast.Text:
+		w.text(escape(r.textValue(n)))
+		if n.SoftLineBreak() || n.HardLineBreak() {
+			w.text("\n")
+		}
+	case *ast.String:
+		w.text(escape(string(n.Value)))
+	case *ast.CodeSpan:
+		w.text("`" + escapeCode(r.plain(n)) + "`")
+	case *ast.Emphasis:
+		marker := "_"
+		if n.Level >= 2 {
+			marker = "*"
+		}
+		r.styledInline(w, n, marker)
+	case *east.Strikethrough:
+		r.styledInline(w, n, "~")
+	case *ast.Link:
+		r.link(w, n, string(n.Destination))
+	case *ast.Image:
+		r.link(w, n, string(n.Destination))
+	case *ast.AutoLink:
+		w.text(escape(string(n.Label(r.source))))
+	case *ast.RawHTML:
+		for i := 0; i < n.Segments.Len(); i++ {
+			segment := n.Segments.At(i)
+			w.text(escape(string(segment.Value(r.source))))
+		}
+	case *east.TaskCheckBox:
+		if n.IsChecked {
+			w.text(taskDone + " ")
+		} else {
+			w.text(taskTodo + " ")
+		}
+	default:
+		r.inlineChildren(w, n)
+	}
+}
+
+// textValue returns the text with the backslash escapes and the character references resolved,
+// code is left as it is
+func (r *renderer) textValue(n *ast.Text) string {
+	value := n.Value(r.source)
+	if n.IsRaw() {
+		return string(value)
+	}
+	return string(util.ResolveEntityNames(util.ResolveNumericReferences(util.UnescapePunctuations(value))))
+}
+
+// styled renders the inline children of the block inside the entity
+func (r *renderer) styled(n ast.Node, marker string) string {
+	var w writer
+	r.styledInline(&w, n, marker)
+	return strings.TrimSpace(w.String())
+}
+
+// styledInline renders the children inside the entity, or as they are if it's already open
+func (r *renderer) styledInline(w *writer, n ast.Node, marker string) {
+	if r.active[marker] {
+		r.inlineChildren(w, n)
+		return
+	}
+	var inner writer
+	r.active[marker] = true
+	r.inlineChildren(&inner, n)
+	r.active[marker] = false
+	if strings.TrimSpace(inner.String()) == "" {
+		w.text(inner.String())
+		return
+	}
+	w.marker(marker)
+	w.append(&inner)
+	w.marker(marker)
+}
+
+// link renders the link entity, links Telegram won't accept are rendered as their text
+func (r *renderer) link(w *writer, n ast.Node, destination string) {
+	// The parser keeps the escapes and the entities of the destination
+	destination = string(util.ResolveEntityNames(util.ResolveNumericReferences(util.UnescapePunctuations([]byte(destination)))))
+	u, err := url.Parse(destination)
+	if err != nil || !linkSchemes[strings.ToLower(u.Scheme)] || r.active["link"] {
+		r.inlineChildren(w, n)
+		return
+	}
+	var inner writer
+	r.active["link"] = true
+	r.inlineChildren(&inner, n)
+	r.active["link"] = false
+	label := inner.String()
+	if strings.TrimSpace(label) == "" {
+		label = escape(destination)
+	}
+	w.text("[" + label + "](" + escapeURL(destination) + ")")
+}
+
+// plain returns the text of the inline children without markup
+func (r *renderer) plain(n ast.Node) string {
+	var sb strings.Builder
+	_ = ast.Walk(n, func(c ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch c := c.(type) {
+		case *ast.Text:
+			sb.WriteString(r.textValue(c))
+			if c.SoftLineBreak() || c.HardLineBreak() {
+				sb.WriteByte(' ')
+			}
+		case *ast.String:
+			sb.Write(c.Value)
+		case *ast.AutoLink:
+			sb.Write(c.Label(r.source))
+		case *ast.RawHTML:
+			for i := 0; i < c.Segments.Len(); i++ {
+				segment := c.Segments.At(i)
+				sb.Write(segment.Value(r.source))
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+	return sb.String()
+}
+
+// writer collects the rendered inlines. Italic markers written one after another would be
+// read as underline, so they are separated.
+type writer struct {
+	sb strings.Builder
+	// The last thing written is an italic marker
+	afterItalic bool
+}
+
+func (w *writer) text(s string) {
+	if s == "" {
+		return
+	}
+	w.sb.WriteString(s)
+	w.afterItalic = false
+}
+
+func (w *writer) marker(m string) {
+	if m == "_" && w.afterItalic {
+		w.sb.WriteString("\r")
+	}
+	w.sb.WriteString(m)
+	w.afterItalic = m == "_"
+}
+
+func (w *writer) append(inner *writer) {
+	if inner.sb.Len() == 0 {
+		return
+	}
+	if strings.HasPrefix(inner.String(), "_") && w.afterItalic {
+		w.sb.WriteString("\r")
+	}
+	w.sb.WriteString(inner.String())
+	w.afterItalic = inner.afterItalic
+}
+
+func (w *writer) String() string {
+	return w.sb.String()
+}
+
+func escape(s string) string {
+	return escapeChars(s, specialChars)
+}
+
+// Inside code only ` and \ are escaped
+func escapeCode(s string) string {
+	return escapeChars(s, "`\\")
+}
+
+// Inside the URL of a link only ) and \ are escaped
+func escapeURL(s string) string {
+	return escapeChars(s, ")\\")
+}
+
+func escapeChars(s, chars string) string {
+	var sb strings.Builder
+	sb.Grow(len(s))
+	for _, c := range s {
+		if strings.ContainsRune(chars, c) {
+			sb.WriteByte('\\')
+		}
+		sb.WriteRune(c)
+	}
+	return sb.String()
+}
+
+// Strip turns MarkdownV2 back to plain text: the entity markers are removed, links are
+// written as "text (url)" and the escaped characters are unescaped. It's used to send
+// a text again when Telegram rejects its entities.
+func Strip(v2 string) string {
+	var sb strings.Builder
+	runes := []rune(v2)
+	lineStart := true
+	// Inside code only the escapes are removed
+	inCode, inPre := false, false
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		switch {
+		case c == '\\' && i+1 < len(runes):
+			i++
+			sb.WriteRune(runes[i])
+		case c == '`' && !inCode && strings.HasPrefix(string(runes[i:]), "```"):
+			i += 2
+			inPre = !inPre
+			// The language line of an opening fence
+			if inPre {
+				for i+1 < len(runes) && runes[i+1] != '\n' {
+					i++
+				}
+				i++
+			}
+		case c == '`' && !inPre:
+			inCode = !inCode
+		case inPre && c == '\n' && strings.HasPrefix(string(runes[i+1:]), "```"):
+			// The line break before a closing fence
+		case inCode || inPre:
+			sb.WriteRune(c)
+		case c == '>' && lineStart:
+		case c == ']' && i+1 < len(runes) && runes[i+1] == '(':
+			end := i + 2
+			for end < len(runes) && runes[end] != ')' {
+				if runes[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			sb.WriteString(" (" + strings.ReplaceAll(string(runes[i+2:min(end, len(runes))]), "\\", "") + ")")
+			i = end
+		case strings.ContainsRune("*_~|[\r", c):
+		default:
+			sb.WriteRune(c)
+		}
+		lineStart = c == '\n'
+	}
+	return sb.String()
+}
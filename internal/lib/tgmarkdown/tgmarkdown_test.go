@@ -0,0 +1,87 @@
+package tgmarkdown
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files with the current output")
+
+// checkGolden compares the output with the golden file, -update rewrites the file instead
+func checkGolden(t *testing.T, file, got string) {
+	t.Helper()
+	if *update {
+		if err := os.WriteFile(file, []byte(got), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Errorf("%s differs:\n--- got\n%s\n--- want\n%s", file, got, want)
+	}
+}
+
+// Every testdata/<name>.md is rendered to <name>.golden and the rendered text is stripped to <name>.strip.golden
+func TestGolden(t *testing.T) {
+	tests := []string{
+		"nested_emphasis",
+		"code_fences",
+		"links",
+		"tables_lists",
+		"reserved_chars",
+		// Answers of the models: long mixed markup, code, unterminated markup of a streaming
+		// prefix and non-Latin text
+		"answer_mixed",
+		"answer_code",
+		"stream_prefixes",
+		"non_latin",
+	}
+
+	for _, name := range tests {
+		t.Run(name, func(t *testing.T) {
+			source, err := os.ReadFile(filepath.Join("testdata", name+".md"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			rendered := Render(string(source))
+			checkGolden(t, filepath.Join("testdata", name+".golden"), rendered)
+			checkGolden(t, filepath.Join("testdata", name+".strip.golden"), Strip(rendered))
+		})
+	}
+}
+
+// Text without markup keeps every reserved character through Render and Strip
+func TestRenderStripText(t *testing.T) {
+	tests := []struct {
+		name     string
+		markdown string
+		want     string
+	}{
+		{name: "escaped reserved chars", markdown: `\_ \* \[ \] \( \) \~ ` + "\\`" + ` \> \# \+ \- \= \| \{ \} \. \! \\`,
+			want: "_ * [ ] ( ) ~ ` > # + - = | { } . ! \\"},
+		{name: "reserved chars in words", markdown: "1.5 2+2=4 a-b x|y {z} (w)!", want: "1.5 2+2=4 a-b x|y {z} (w)!"},
+		{name: "every reserved char", markdown: "x" + strings.Join(strings.Split(specialChars, ""), "\\"),
+			want: "x" + specialChars},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rendered := Render(tt.markdown)
+			for i, r := range rendered {
+				if strings.ContainsRune(specialChars, r) && (i == 0 || rendered[i-1] != '\\') && r != '\\' {
+					t.Errorf("%q is not escaped in %q", r, rendered)
+				}
+			}
+			if got := Strip(rendered); got != tt.want {
+				t.Errorf("Strip(Render()) = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
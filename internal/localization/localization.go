@@ -33,6 +33,43 @@ const (
 	MTypeMsgProfile                   MessageType = "msg_profile"
 	MTypeMsgLimitReached              MessageType = "msg_limit_reached"
 	MTypeMsgMaintenance               MessageType = "msg_maintenance"
+	MTypeMsgTokens                    MessageType = "msg_tokens"
+	MTypeMsgModelNotInTariff          MessageType = "msg_model_not_in_tariff"
+	MTypeMsgImagePromptRequest        MessageType = "msg_image_prompt_request"
+	MTypeMsgImageGenerating           MessageType = "msg_image_generating"
+	MTypeMsgUnsupportedMessage        MessageType = "msg_unsupported_message"
+	MTypeMsgModelNoVision             MessageType = "msg_model_no_vision"
+	MTypeMsgFileTooBig                MessageType = "msg_file_too_big"
+	MTypeMsgSpeechNotRecognized       MessageType = "msg_speech_not_recognized"
+	MTypeMsgDocumentUnsupported       MessageType = "msg_document_unsupported"
+	MTypeMsgDocumentNoText            MessageType = "msg_document_no_text"
+	MTypeMsgDocumentTruncated         MessageType = "msg_document_truncated"
+	MTypeMsgAIRateLimited             MessageType = "msg_ai_rate_limited"
+	MTypeMsgAIQuotaExceeded           MessageType = "msg_ai_quota_exceeded"
+	MTypeMsgAIContextTooLong          MessageType = "msg_ai_context_too_long"
+	MTypeMsgAIInvalidKey              MessageType = "msg_ai_invalid_key"
+	MTypeMsgAIServerError             MessageType = "msg_ai_server_error"
+	MTypeMsgAIUnavailable             MessageType = "msg_ai_unavailable"
+	MTypeMsgPersonas                  MessageType = "msg_personas"
+	MTypeMsgPersonaSelected           MessageType = "msg_persona_selected"
+	MTypeMsgPersonaNextSelected       MessageType = "msg_persona_next_selected"
+	MTypeMsgPersonaAdded              MessageType = "msg_persona_added"
+	MTypeMsgPersonaDeleted            MessageType = "msg_persona_deleted"
+	MTypeMsgPersonaNoTitle            MessageType = "msg_persona_no_title"
+	MTypeMsgPersonaNoPrompt           MessageType = "msg_persona_no_prompt"
+	MTypeMsgPersonaTooLong            MessageType = "msg_persona_too_long"
+	MTypeMsgPersonaLimit              MessageType = "msg_persona_limit"
+	MTypeMsgSettings                  MessageType = "msg_settings"
+	MTypeMsgSettingsUser              MessageType = "msg_settings_user"
+	MTypeMsgSettingsDialog            MessageType = "msg_settings_dialog"
+	MTypeMsgParamDefault              MessageType = "msg_param_default"
+	MTypeMsgParamInherited            MessageType = "msg_param_inherited"
+	MTypeMsgParamUnsupported          MessageType = "msg_param_unsupported"
+	MTypeMsgThinking                  MessageType = "msg_thinking"
+	MTypeMsgModerationFlagged         MessageType = "msg_moderation_flagged"
+	MTypeMsgAnswerModerated           MessageType = "msg_answer_moderated"
+	MTypeMsgModerationBlocked         MessageType = "msg_moderation_blocked"
+	MTypeMsgRequestInterrupted        MessageType = "msg_request_interrupted"
 	MTypeBtnViewAllMessages           MessageType = "btn_view_all_messages"
 	MTypeBtnDeleteDialog              MessageType = "btn_delete_dialog"
 	MTypeBtnCancel                    MessageType = "btn_cancel"
@@ -43,6 +80,10 @@ const (
 	MTypeBtnCancelPreviousRequest     MessageType = "btn_cancel_previous_request"
 	MTypeBtnDeleteAllDialogs          MessageType = "btn_delete_all_dialogs"
 	MTypeBtnToggleNewDialog           MessageType = "btn_toggle_new_dialog"
+	MTypeBtnToggleVoiceAnswer         MessageType = "btn_toggle_voice_answer"
+	MTypeBtnNoPersona                 MessageType = "btn_no_persona"
+	MTypeBtnSettingsUser              MessageType = "btn_settings_user"
+	MTypeBtnSettingsDialog            MessageType = "btn_settings_dialog"
 	MTypeAnswerDeleteDialog           MessageType = "answer_delete_dialog"
 	MTypeAnswerDeleteAllDialogs       MessageType = "answer_delete_all_dialogs"
 	MTypeAnswerCreateNewDialog        MessageType = "answer_create_new_dialog"
@@ -122,6 +163,43 @@ func allMessageTypes() []MessageType {
 		MTypeMsgProfile,
 		MTypeMsgLimitReached,
 		MTypeMsgMaintenance,
+		MTypeMsgTokens,
+		MTypeMsgModelNotInTariff,
+		MTypeMsgImagePromptRequest,
+		MTypeMsgImageGenerating,
+		MTypeMsgUnsupportedMessage,
+		MTypeMsgModelNoVision,
+		MTypeMsgFileTooBig,
+		MTypeMsgSpeechNotRecognized,
+		MTypeMsgDocumentUnsupported,
+		MTypeMsgDocumentNoText,
+		MTypeMsgDocumentTruncated,
+		MTypeMsgAIRateLimited,
+		MTypeMsgAIQuotaExceeded,
+		MTypeMsgAIContextTooLong,
+		MTypeMsgAIInvalidKey,
+		MTypeMsgAIServerError,
+		MTypeMsgAIUnavailable,
+		MTypeMsgPersonas,
+		MTypeMsgPersonaSelected,
+		MTypeMsgPersonaNextSelected,
+		MTypeMsgPersonaAdded,
+		MTypeMsgPersonaDeleted,
+		MTypeMsgPersonaNoTitle,
+		MTypeMsgPersonaNoPrompt,
+		MTypeMsgPersonaTooLong,
+		MTypeMsgPersonaLimit,
+		MTypeMsgSettings,
+		MTypeMsgSettingsUser,
+		MTypeMsgSettingsDialog,
+		MTypeMsgParamDefault,
+		MTypeMsgParamInherited,
+		MTypeMsgParamUnsupported,
+		MTypeMsgThinking,
+		MTypeMsgModerationFlagged,
+		MTypeMsgAnswerModerated,
+		MTypeMsgModerationBlocked,
+		MTypeMsgRequestInterrupted,
 		MTypeBtnViewAllMessages,
 		MTypeBtnDeleteDialog,
 		MTypeBtnCancel,
@@ -138,5 +216,9 @@ func allMessageTypes() []MessageType {
 		MTypeNotifyRequestCanceled,
 		MTypeNotifyRequestAlreadyCanceled,
 		MTypeBtnToggleNewDialog,
+		MTypeBtnToggleVoiceAnswer,
+		MTypeBtnNoPersona,
+		MTypeBtnSettingsUser,
+		MTypeBtnSettingsDialog,
 	}
 }
@@ -1,9 +1,11 @@
 package maincontroller
 
 import (
+	"errors"
 	"fmt"
 	"log"
-	"strings"
+	"tgbot/internal/ai"
+	"tgbot/internal/lib/tgmarkdown"
 	"tgbot/internal/localization"
 	"tgbot/internal/store"
 	"time"
@@ -16,187 +18,26 @@ func localeText(locale string, mType localization.MessageType, args ...any) stri
 }
 
 func newTgMessage(userID int64, text string) tgbotapi.MessageConfig {
-	res := tgbotapi.NewMessage(userID, prepareTxtToTgMarkdown(text))
+	res := tgbotapi.NewMessage(userID, tgmarkdown.Render(text))
 	res.ParseMode = tgbotapi.ModeMarkdownV2
 	return res
 }
 
-func newTgEditMessage(userID int64, messageID int, text string) tgbotapi.EditMessageTextConfig {
-	res := tgbotapi.NewEditMessageText(userID, messageID, prepareTxtToTgMarkdown(text))
+// newTgPhoto creates a photo message, the caption is cut to the Telegram limit
+func newTgPhoto(userID int64, file tgbotapi.RequestFileData, caption string) tgbotapi.PhotoConfig {
+	if r := []rune(caption); len(r) > TgCaptionMaxLength {
+		caption = string(r[:TgCaptionMaxLength-3]) + "..."
+	}
+	res := tgbotapi.NewPhoto(userID, file)
+	res.Caption = tgmarkdown.Render(caption)
 	res.ParseMode = tgbotapi.ModeMarkdownV2
 	return res
 }
 
-type MdType string
-
-const (
-	mdTypeNone          MdType = ""
-	mdTypeBold          MdType = "::bold::"
-	mdTypeItalic        MdType = "::italic::"
-	mdTypeStrikethrough MdType = "::strikethrough::"
-	mdTypeMono          MdType = "::mono::"
-	mdTypeMonorows      MdType = "::monorows::"
-)
-
-func prepareTxtToTgMarkdown(text string) string {
-	var result strings.Builder
-	runes := []rune(text)
-	l := len(runes)
-	prevEscape := mdTypeNone
-
-	for i := 0; i < l; {
-		//fmt.Println("i", i, "l", l, "runes[i]", string(runes[i]), "prevEscape", prevEscape)
-		if runes[i] == '\n' && prevEscape != mdTypeNone && prevEscape != mdTypeMonorows {
-			var repl string
-			if prevEscape == mdTypeBold {
-				repl = "**"
-			}
-			if prevEscape == mdTypeItalic {
-				repl = "*"
-			}
-			if prevEscape == mdTypeStrikethrough {
-				repl = "~~"
-			}
-			if prevEscape == mdTypeMono {
-				repl = "`"
-			}
-			tmp := result.String()
-			result.Reset()
-			result.WriteString(ReplaceLast(tmp, string(prevEscape), repl))
-			prevEscape = mdTypeNone
-			result.WriteRune(runes[i])
-			i++
-			continue
-		}
-
-		// monorows
-		if i+2 < l && runes[i] == '`' && runes[i+1] == '`' && runes[i+2] == '`' {
-			if prevEscape == mdTypeNone { // open
-				prevEscape = mdTypeMonorows
-				result.WriteString(string(mdTypeMonorows))
-			} else if prevEscape == mdTypeMonorows { // close
-				prevEscape = ""
-				result.WriteString(string(mdTypeMonorows))
-			} else { // if inactive
-				result.WriteRune(runes[i])
-			}
-			i += 3
-			continue
-		}
-
-		// bold
-		if i+1 < l && runes[i] == '*' && runes[i+1] == '*' {
-			if prevEscape == mdTypeNone { // open
-				prevEscape = mdTypeBold
-				result.WriteString(string(mdTypeBold))
-			} else if prevEscape == mdTypeBold { // close
-				prevEscape = ""
-				result.WriteString(string(mdTypeBold))
-			} else { // if inactive
-				result.WriteRune(runes[i])
-				result.WriteRune(runes[i+1])
-			}
-			i += 2
-			continue
-		}
-
-		// italic
-		if runes[i] == '*' {
-			if prevEscape == mdTypeNone { // open
-				prevEscape = mdTypeItalic
-				result.WriteString(string(mdTypeItalic))
-			} else if prevEscape == mdTypeItalic { // close
-				prevEscape = ""
-				result.WriteString(string(mdTypeItalic))
-			} else { // if inactive
-				result.WriteRune(runes[i])
-			}
-			i += 1
-			continue
-		}
-
-		// strikethrough
-		if i+1 < l && runes[i] == '~' && runes[i+1] == '~' {
-			if prevEscape == mdTypeNone { // open
-				prevEscape = mdTypeStrikethrough
-				result.WriteString(string(mdTypeStrikethrough))
-			} else if prevEscape == mdTypeStrikethrough { // close
-				prevEscape = ""
-				result.WriteString(string(mdTypeStrikethrough))
-			} else { // if inactive
-				result.WriteRune(runes[i])
-			}
-			i += 2
-			continue
-		}
-
-		// mono
-		if runes[i] == '`' {
-			if prevEscape == mdTypeNone { // open
-				prevEscape = mdTypeMono
-				result.WriteString(string(mdTypeMono))
-			} else if prevEscape == mdTypeMono { // close
-				prevEscape = ""
-				result.WriteString(string(mdTypeMono))
-			} else { // if inactive
-				result.WriteRune(runes[i])
-			}
-			i += 1
-			continue
-		}
-
-		result.WriteRune(runes[i])
-		i++
-	}
-
-	if prevEscape != "" {
-		if prevEscape == mdTypeMonorows {
-			result.WriteString(string(mdTypeMonorows))
-		} else {
-			var repl string
-			if prevEscape == mdTypeBold {
-				repl = "**"
-			}
-			if prevEscape == mdTypeItalic {
-				repl = "*"
-			}
-			if prevEscape == mdTypeStrikethrough {
-				repl = "~~"
-			}
-			if prevEscape == mdTypeMono {
-				repl = "`"
-			}
-			tmp := result.String()
-			result.Reset()
-			result.WriteString(ReplaceLast(tmp, string(prevEscape), repl))
-		}
-	}
-
-	resultText := result.String()
-	specialChars := []string{
-		"_", "*", "[", "]", "(", ")", "~", "`", ">", "#", "+", "-", "=", "|", "{", "}", ".", "!",
-	}
-	for _, char := range specialChars {
-		resultText = strings.ReplaceAll(resultText, char, "\\"+char)
-	}
-
-	//fmt.Println(resultText)
-
-	resultText = strings.ReplaceAll(resultText, string(mdTypeBold), "*")
-	resultText = strings.ReplaceAll(resultText, string(mdTypeItalic), "_")
-	resultText = strings.ReplaceAll(resultText, string(mdTypeStrikethrough), "~")
-	resultText = strings.ReplaceAll(resultText, string(mdTypeMono), "`")
-	resultText = strings.ReplaceAll(resultText, string(mdTypeMonorows), "```")
-
-	return resultText
-}
-
-func ReplaceLast(s, old, new string) string {
-	index := strings.LastIndex(s, old)
-	if index == -1 {
-		return s
-	}
-	return s[:index] + new + s[index+len(old):]
+func newTgEditMessage(userID int64, messageID int, text string) tgbotapi.EditMessageTextConfig {
+	res := tgbotapi.NewEditMessageText(userID, messageID, tgmarkdown.Render(text))
+	res.ParseMode = tgbotapi.ModeMarkdownV2
+	return res
 }
 
 func kbWithOneButton(emoji, text, data string) *tgbotapi.InlineKeyboardMarkup {
@@ -226,14 +67,27 @@ func prepareAllDialogs(handler *MainController, req *Request, offset int) (strin
 		curDialogID = req.UserShell.Dialog.ID
 	}
 
+	personas, err := handler.store.Personas(req.Ctx, req.UserShell.ID)
+	if err != nil {
+		return "", nil, err
+	}
+	personaTitles := make(map[int64]string, len(personas))
+	for _, persona := range personas {
+		personaTitles[persona.ID] = persona.Title
+	}
+
 	text := localeText(req.UserShell.Locale, localization.MTypeMsgYourDialogs, offset+1, min(allDialogsLen, offset+5), allDialogsLen)
 	kb := tgbotapi.InlineKeyboardMarkup{}
 	for _, v := range dialogs {
+		title := v.Title
+		if personaTitle, ok := personaTitles[v.PersonaID]; ok {
+			title = fmt.Sprintf("%s (🎭 %s)", title, personaTitle)
+		}
 		kb.InlineKeyboard = append(kb.InlineKeyboard,
 			tgbotapi.NewInlineKeyboardRow(
 				tgbotapi.NewInlineKeyboardButtonData(
 					fmt.Sprint(
-						dialogEmoji(curDialogID, v.ID), " ", v.Title),
+						dialogEmoji(curDialogID, v.ID), " ", title),
 					fmt.Sprint(callbackTypeDialog, ";", req.UserShell.ID, ";", v.ID))))
 	}
 
@@ -268,6 +122,61 @@ func prepareAllDialogs(handler *MainController, req *Request, offset int) (strin
 	return text, &kb, nil
 }
 
+const (
+	personaMaxCount        = 10 // personas of a user
+	personaTitleMaxLength  = 64
+	personaPromptMaxLength = 2000
+)
+
+// preparePersonas returns the personas message and the keyboard to choose the persona of the active
+// dialog, or of the next dialog if there is no active one. Personas of the user can be deleted.
+func preparePersonas(mc *MainController, req *Request) (string, *tgbotapi.InlineKeyboardMarkup, error) {
+	us := req.UserShell
+	personas, err := mc.store.Personas(req.Ctx, us.ID)
+	if err != nil {
+		return "", nil, fmt.Errorf("preparePersonas(): %w", err)
+	}
+
+	curPersonaID := us.NextPersonaID
+	selectedType := localization.MTypeMsgPersonaNextSelected
+	if us.Dialog != nil {
+		curPersonaID = us.Dialog.PersonaID
+		selectedType = localization.MTypeMsgPersonaSelected
+	}
+
+	curTitle := localeText(us.Locale, localization.MTypeBtnNoPersona)
+	kb := tgbotapi.InlineKeyboardMarkup{}
+	kb.InlineKeyboard = append(kb.InlineKeyboard,
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(
+				fmt.Sprint(personaEmoji(curPersonaID, 0), " ", curTitle),
+				fmt.Sprint(callbackTypePersona, ";", us.ID, ";", 0))))
+	for _, persona := range personas {
+		if persona.ID == curPersonaID {
+			curTitle = persona.Title
+		}
+		row := tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(
+				fmt.Sprint(personaEmoji(curPersonaID, persona.ID), " ", persona.Title),
+				fmt.Sprint(callbackTypePersona, ";", us.ID, ";", persona.ID)))
+		if persona.UserID == us.ID {
+			row = append(row, tgbotapi.NewInlineKeyboardButtonData(
+				"🗑", fmt.Sprint(callbackTypeDeletePersona, ";", us.ID, ";", persona.ID)))
+		}
+		kb.InlineKeyboard = append(kb.InlineKeyboard, row)
+	}
+
+	text := localeText(us.Locale, localization.MTypeMsgPersonas) + "\n\n" + localeText(us.Locale, selectedType, curTitle)
+	return text, &kb, nil
+}
+
+func personaEmoji(curPersonaID int64, personaID int64) string {
+	if curPersonaID != personaID {
+		return "🎭"
+	}
+	return "✅"
+}
+
 func dialogEmoji(curDialogID int64, dialogID int64) string {
 	if curDialogID != dialogID {
 		return "💬"
@@ -277,12 +186,65 @@ func dialogEmoji(curDialogID int64, dialogID int64) string {
 
 func newChatMessage(dialogID int64, order int, role store.ChatMessageRole, content string) *store.ChatMessage {
 	return &store.ChatMessage{
-		DialogID: dialogID,
-		Order:    order,
-		Role:     role,
-		Content:  content,
-		Created:  time.Now().UTC(),
+		DialogID:     dialogID,
+		Order:        order,
+		Role:         role,
+		Content:      content,
+		Created:      time.Now().UTC(),
+		SummaryUntil: -1,
+	}
+}
+
+// checkUserUsage sends the reason to the user if the model can't be used
+func checkUserUsage(mc *MainController, msgEx *MessageManager, us *store.UserShell, modelID int32) (bool, error) {
+	checkLimit, err := mc.store.CheckUserUsage(us, modelID)
+	if errors.Is(err, store.ErrModelNotInTariff) {
+		_, _ = msgEx.send(newTgMessage(us.ID, localeText(us.Locale, localization.MTypeMsgModelNotInTariff)))
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !checkLimit {
+		_, _ = msgEx.send(newTgMessage(us.ID, localeText(us.Locale, localization.MTypeMsgLimitReached)))
+		return false, nil
+	}
+	return true, nil
+}
+
+func aiRole(role store.ChatMessageRole) string {
+	switch role {
+	case store.RoleAssistant:
+		return ai.RoleAssistant
+	case store.RoleSystem:
+		return ai.RoleSystem
+	case store.RoleUser:
+		return ai.RoleUser
+	case store.RoleToolCall:
+		return ai.RoleAssistant
+	case store.RoleToolResult:
+		return ai.RoleTool
 	}
+	return ""
+}
+
+// errorMessageType returns the message shown to the user for the error of an AI provider
+func errorMessageType(err error) localization.MessageType {
+	switch ai.ErrorKindOf(err) {
+	case ai.KindRateLimited:
+		return localization.MTypeMsgAIRateLimited
+	case ai.KindQuotaExceeded:
+		return localization.MTypeMsgAIQuotaExceeded
+	case ai.KindContextTooLong:
+		return localization.MTypeMsgAIContextTooLong
+	case ai.KindInvalidKey:
+		return localization.MTypeMsgAIInvalidKey
+	case ai.KindServer:
+		return localization.MTypeMsgAIServerError
+	case ai.KindUnavailable:
+		return localization.MTypeMsgAIUnavailable
+	}
+	return localization.MTypeMsgCommonError
 }
 
 func fixedSentFrom(update *tgbotapi.Update) *tgbotapi.User {
@@ -317,15 +279,25 @@ func prepareProfileMessage(mc *MainController, us *store.UserShell) (string, *tg
 		localization.MTypeMsgProfile,
 		us.ID,
 		tariff.Tariff.Title,
-		mc.store.UserUsageToString(tariff.Limits, userUsage))
+		mc.store.UserUsageToString(tariff.Limits, userUsage, localeText(us.Locale, localization.MTypeMsgTokens)))
 
 	em := "✅"
 	if us.User.SkipNewDialogMessage {
 		em = "❌"
 	}
+	voiceEm := "❌"
+	if us.User.SendVoiceAnswer {
+		voiceEm = "✅"
+	}
 
-	kb := kbWithOneButton(em,
-		localeText(us.Locale, localization.MTypeBtnToggleNewDialog),
-		fmt.Sprint(callbackTypeToggleNewDialog, ";", us.ID))
-	return text, kb, nil
+	kb := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(
+				fmt.Sprintf("%s %s", em, localeText(us.Locale, localization.MTypeBtnToggleNewDialog)),
+				fmt.Sprint(callbackTypeToggleNewDialog, ";", us.ID))),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(
+				fmt.Sprintf("%s %s", voiceEm, localeText(us.Locale, localization.MTypeBtnToggleVoiceAnswer)),
+				fmt.Sprint(callbackTypeToggleVoiceAnswer, ";", us.ID))))
+	return text, &kb, nil
 }
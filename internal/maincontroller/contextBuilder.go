@@ -0,0 +1,217 @@
+package maincontroller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"tgbot/internal/ai"
+	"tgbot/internal/store"
+)
+
+const (
+	summaryPrefix = "Summary of the earlier part of the conversation:\n"
+	summaryPrompt = "You compress chat history. Summarize the conversation below so it can replace it as context " +
+		"for the assistant. Keep facts, names, numbers, decisions, open questions and the user's preferences. " +
+		"Merge the previous summary if there is one. Be concise, do not address the user, " +
+		"write in the language of the conversation."
+	// Share of the budget reserved for the summary when older turns are folded
+	summaryBudgetShare = 5
+	// Rough cost of an image for vision models
+	imageTokens      = 800
+	imagePlaceholder = "[image]"
+)
+
+// ContextBuilder selects dialog messages that fit into the model context window
+type ContextBuilder struct {
+	tokenizer ai.Tokenizer
+	budget    int
+}
+
+type DialogContext struct {
+	// System prompt of the dialog persona, empty if the dialog has no persona
+	Persona string
+	// Summary of the messages before Messages, nil if nothing was folded yet
+	Summary *store.ChatMessage
+	// Most recent messages that fit into the budget
+	Messages []*store.ChatMessage
+	// Older messages that don't fit and must be folded into a new summary
+	Overflow []*store.ChatMessage
+}
+
+func NewContextBuilder(tokenizer ai.Tokenizer, budget int) *ContextBuilder {
+	return &ContextBuilder{tokenizer: tokenizer, budget: budget}
+}
+
+// Build splits the dialog history into the latest summary, the most recent turns that fit
+// into the budget and the older turns that are left out. The latest turn is always kept.
+// The persona prompt is always sent, so it is taken from the budget first.
+func (cb *ContextBuilder) Build(persona string, history []*store.ChatMessage) *DialogContext {
+	res := &DialogContext{Persona: persona}
+	for _, msg := range history {
+		if msg.IsSummary() && (res.Summary == nil || msg.SummaryUntil >= res.Summary.SummaryUntil) {
+			res.Summary = msg
+		}
+	}
+
+	var candidates []*store.ChatMessage
+	for _, msg := range history {
+		if msg.IsSummary() || (res.Summary != nil && msg.Order <= res.Summary.SummaryUntil) {
+			continue
+		}
+		candidates = append(candidates, msg)
+	}
+
+	if len(candidates) == 0 {
+		return res
+	}
+
+	budget := cb.budget - cb.tokenizer.CountTokens(persona)
+	if res.Summary != nil {
+		budget -= cb.tokenizer.CountTokens(res.Summary.Content)
+	}
+	if cb.countTokens(candidates) <= budget {
+		res.Messages = candidates
+		return res
+	}
+
+	// Older turns will be replaced by a new summary, reserve space for it
+	budget = cb.budget - cb.budget/summaryBudgetShare - cb.tokenizer.CountTokens(persona)
+	turns := splitTurns(candidates)
+	first := len(turns) - 1
+	used := cb.countTokens(turns[first])
+	for first > 0 {
+		tokens := cb.countTokens(turns[first-1])
+		if used+tokens > budget {
+			break
+		}
+		used += tokens
+		first--
+	}
+
+	for i, turn := range turns {
+		if i < first {
+			res.Overflow = append(res.Overflow, turn...)
+		} else {
+			res.Messages = append(res.Messages, turn...)
+		}
+	}
+	return res
+}
+
+// Summarize folds the previous summary and the overflow into a new summary message
+func (cb *ContextBuilder) Summarize(ctx context.Context, chatModel ai.ChatModel, model string, dc *DialogContext) (*store.ChatMessage, error) {
+	var sb strings.Builder
+	if dc.Summary != nil {
+		sb.WriteString("Previous summary:\n")
+		sb.WriteString(strings.TrimPrefix(dc.Summary.Content, summaryPrefix))
+		sb.WriteString("\n\nConversation:\n")
+	}
+	for _, msg := range dc.Overflow {
+		if msg.Role == store.RoleToolCall {
+			continue
+		}
+		sb.WriteString(aiRole(msg.Role))
+		sb.WriteString(": ")
+		if msg.ImageFileID != "" {
+			sb.WriteString(imagePlaceholder + " ")
+		}
+		sb.WriteString(msg.Content)
+		sb.WriteString("\n\n")
+	}
+
+	stream, err := chatModel.GetStreamMessages(ctx, ai.ChatRequest{
+		Model:  model,
+		Stream: true,
+		Messages: []ai.Message{
+			ai.TextMessage(ai.RoleSystem, summaryPrompt),
+			ai.TextMessage(ai.RoleUser, sb.String()),
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Summarize(): %w", err)
+	}
+	summary, err := ai.Collect(stream)
+	if err != nil {
+		return nil, fmt.Errorf("Summarize(): %w", err)
+	}
+	if strings.TrimSpace(summary) == "" {
+		return nil, fmt.Errorf("Summarize(): empty summary")
+	}
+
+	usage := stream.Usage()
+	msg := newChatMessage(0, 0, store.RoleSystem, summaryPrefix+summary)
+	msg.SummaryUntil = dc.Overflow[len(dc.Overflow)-1].Order
+	msg.PromptTokens = usage.PromptTokens
+	msg.CompletionTokens = usage.CompletionTokens
+	msg.CachedTokens = usage.CachedTokens
+	msg.ReasoningTokens = usage.ReasoningTokens
+	return msg, nil
+}
+
+// AIMessages converts the context to messages for the chat model, the persona prompt goes first. Images missing
+// in images (e.g. for models without vision) are replaced with a placeholder.
+// Tool calls and results are left out for models without tools.
+func (dc *DialogContext) AIMessages(images map[string]*ai.ImageContent, tools bool) []ai.Message {
+	res := make([]ai.Message, 0, len(dc.Messages)+2)
+	if dc.Persona != "" {
+		res = append(res, ai.TextMessage(ai.RoleSystem, dc.Persona))
+	}
+	if dc.Summary != nil {
+		res = append(res, ai.TextMessage(ai.RoleSystem, dc.Summary.Content))
+	}
+	for _, msg := range dc.Messages {
+		if msg.IsTool() && !tools {
+			continue
+		}
+		switch {
+		case msg.Role == store.RoleToolCall:
+			var calls []ai.ToolCall
+			if err := json.Unmarshal([]byte(msg.Content), &calls); err == nil {
+				res = append(res, ai.Message{Role: ai.RoleAssistant, ToolCalls: calls})
+			}
+			continue
+		case msg.Role == store.RoleToolResult:
+			res = append(res, ai.ToolResultMessage(msg.ToolCallID, msg.Content))
+			continue
+		case msg.ImageFileID == "":
+			res = append(res, ai.TextMessage(aiRole(msg.Role), msg.Content))
+			continue
+		}
+
+		image, ok := images[msg.ImageFileID]
+		if !ok {
+			res = append(res, ai.TextMessage(aiRole(msg.Role), strings.TrimSpace(imagePlaceholder+" "+msg.Content)))
+			continue
+		}
+		message := ai.Message{Role: aiRole(msg.Role), Content: []ai.ContentPart{{Type: ai.PartImage, Image: image}}}
+		if msg.Content != "" {
+			message.Content = append(message.Content, ai.ContentPart{Type: ai.PartText, Text: msg.Content})
+		}
+		res = append(res, message)
+	}
+	return res
+}
+
+func (cb *ContextBuilder) countTokens(msgs []*store.ChatMessage) int {
+	res := 0
+	for _, msg := range msgs {
+		res += cb.tokenizer.CountTokens(msg.Content)
+		if msg.ImageFileID != "" {
+			res += imageTokens
+		}
+	}
+	return res
+}
+
+// splitTurns groups messages into turns, each turn starts with a user message
+func splitTurns(msgs []*store.ChatMessage) [][]*store.ChatMessage {
+	var res [][]*store.ChatMessage
+	for _, msg := range msgs {
+		if len(res) == 0 || msg.Role == store.RoleUser {
+			res = append(res, nil)
+		}
+		res[len(res)-1] = append(res[len(res)-1], msg)
+	}
+	return res
+}
@@ -0,0 +1,171 @@
+package maincontroller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"tgbot/internal/ai"
+	"tgbot/internal/store"
+)
+
+// wordTokenizer counts a token per word, so the budgets of the tests are easy to follow
+type wordTokenizer struct{}
+
+func (wordTokenizer) CountTokens(text string) int {
+	return len(strings.Fields(text))
+}
+
+// stubChatModel answers every request with the same text and keeps the last request
+type stubChatModel struct {
+	answer  string
+	request ai.ChatRequest
+}
+
+func (m *stubChatModel) GetStreamMessages(ctx context.Context, request ai.ChatRequest) (*ai.Stream, error) {
+	m.request = request
+	stream := ai.NewStream()
+	go func() {
+		stream.Send(ctx, ai.Chunk{Content: m.answer})
+		stream.SetUsage(ai.Usage{PromptTokens: 100, CompletionTokens: 10})
+		stream.Close(nil)
+	}()
+	return stream, nil
+}
+
+// newHistory returns turns of a user message and an assistant answer, every message has the given number of words
+func newHistory(turns, words int) []*store.ChatMessage {
+	var res []*store.ChatMessage
+	for i := range turns {
+		text := strings.TrimSpace(strings.Repeat(fmt.Sprintf("w%d ", i), words))
+		res = append(res,
+			newChatMessage(1, 2*i, store.RoleUser, text),
+			newChatMessage(1, 2*i+1, store.RoleAssistant, text))
+	}
+	return res
+}
+
+func TestContextBuilderBuild(t *testing.T) {
+	const persona = "You are a pirate."
+	summary := newChatMessage(1, 0, store.RoleSystem, summaryPrefix+"Earlier talk.")
+	summary.SummaryUntil = 3
+
+	tests := []struct {
+		name    string
+		budget  int
+		history []*store.ChatMessage
+		// Number of the most recent messages that fit
+		wantMessages int
+		wantSummary  bool
+	}{
+		{name: "everything fits", budget: 100, history: newHistory(4, 5), wantMessages: 8},
+		{name: "older turns overflow", budget: 50, history: newHistory(10, 5), wantMessages: 6},
+		{name: "latest turn only", budget: 20, history: newHistory(3, 5), wantMessages: 2},
+		{name: "summary fits", budget: 100, history: append(newHistory(6, 5), summary), wantMessages: 8, wantSummary: true},
+		{name: "summary and overflow", budget: 40, history: append(newHistory(8, 5), summary), wantMessages: 4, wantSummary: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cb := NewContextBuilder(wordTokenizer{}, tt.budget)
+			dc := cb.Build(persona, tt.history)
+
+			if len(dc.Messages) != tt.wantMessages {
+				t.Fatalf("got %d messages, want %d", len(dc.Messages), tt.wantMessages)
+			}
+			if (dc.Summary != nil) != tt.wantSummary {
+				t.Fatalf("summary = %v, want %v", dc.Summary, tt.wantSummary)
+			}
+
+			// The budget is kept, a part of it is reserved for the new summary if there is overflow
+			used := cb.tokenizer.CountTokens(persona) + cb.countTokens(dc.Messages)
+			limit := tt.budget
+			if len(dc.Overflow) > 0 {
+				limit -= tt.budget / summaryBudgetShare
+			} else if dc.Summary != nil {
+				used += cb.tokenizer.CountTokens(dc.Summary.Content)
+			}
+			if used > limit {
+				t.Errorf("context takes %d tokens, budget %d", used, limit)
+			}
+
+			// Turns are not split and summarized messages are not sent again
+			if len(dc.Messages) > 0 && dc.Messages[0].Role != store.RoleUser {
+				t.Errorf("context starts with role %d, want a user message", dc.Messages[0].Role)
+			}
+			if len(dc.Overflow) > 0 && dc.Overflow[len(dc.Overflow)-1].Role != store.RoleAssistant {
+				t.Errorf("overflow ends with role %d, want an assistant message", dc.Overflow[len(dc.Overflow)-1].Role)
+			}
+			for _, msg := range append(dc.Overflow, dc.Messages...) {
+				if msg.IsSummary() || (dc.Summary != nil && msg.Order <= dc.Summary.SummaryUntil) {
+					t.Errorf("message %d is already summarized", msg.Order)
+				}
+			}
+
+			// The persona goes first, then the summary
+			messages := dc.AIMessages(nil, false)
+			if messages[0].Role != ai.RoleSystem || messages[0].Text() != persona {
+				t.Errorf("first message = %+v, want the persona", messages[0])
+			}
+			if tt.wantSummary && messages[1].Text() != summary.Content {
+				t.Errorf("second message = %+v, want the summary", messages[1])
+			}
+		})
+	}
+}
+
+func TestSplitTurns(t *testing.T) {
+	history := []*store.ChatMessage{
+		newChatMessage(1, 0, store.RoleAssistant, "greeting"),
+		newChatMessage(1, 1, store.RoleUser, "q1"),
+		newChatMessage(1, 2, store.RoleToolCall, "[]"),
+		newChatMessage(1, 3, store.RoleToolResult, "result"),
+		newChatMessage(1, 4, store.RoleAssistant, "a1"),
+		newChatMessage(1, 5, store.RoleUser, "q2"),
+	}
+	turns := splitTurns(history)
+
+	var sizes []int
+	for _, turn := range turns {
+		sizes = append(sizes, len(turn))
+	}
+	if fmt.Sprint(sizes) != "[1 4 1]" {
+		t.Errorf("turn sizes = %v, want [1 4 1]", sizes)
+	}
+}
+
+func TestContextBuilderSummarize(t *testing.T) {
+	summary := newChatMessage(1, 0, store.RoleSystem, summaryPrefix+"The user is called Ann.")
+	summary.SummaryUntil = 3
+	history := append(newHistory(8, 5), summary)
+
+	cb := NewContextBuilder(wordTokenizer{}, 40)
+	dc := cb.Build("", history)
+	if len(dc.Overflow) == 0 {
+		t.Fatal("no overflow to summarize")
+	}
+
+	model := &stubChatModel{answer: "Ann asked about w4 and w5."}
+	msg, err := cb.Summarize(context.Background(), model, "gpt-4o", dc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prompt := model.request.Messages[1].Text()
+	if strings.Count(prompt, "The user is called Ann.") != 1 || strings.Contains(prompt, summaryPrefix) {
+		t.Errorf("previous summary is not folded in once: %q", prompt)
+	}
+	if strings.Contains(prompt, "w0") || strings.Contains(prompt, "w1") {
+		t.Errorf("summarized messages are sent again: %q", prompt)
+	}
+	if msg.Content != summaryPrefix+model.answer {
+		t.Errorf("summary = %q", msg.Content)
+	}
+	if want := dc.Overflow[len(dc.Overflow)-1].Order; msg.SummaryUntil != want {
+		t.Errorf("summary until = %d, want %d", msg.SummaryUntil, want)
+	}
+	if msg.PromptTokens != 100 || msg.CompletionTokens != 10 {
+		t.Errorf("usage = %d/%d, want 100/10", msg.PromptTokens, msg.CompletionTokens)
+	}
+}
@@ -0,0 +1,217 @@
+package maincontroller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+var errUserQueueFull = errors.New("user queue is full")
+
+// Dispatcher handles the updates with a fixed pool of workers. Updates of the same user are handled
+// one by one in the order they arrive, so handlers of a user never run concurrently. Dispatch blocks
+// while maxQueued updates are waiting or running, which slows down the update source.
+type Dispatcher struct {
+	handle  func(update *tgbotapi.Update)
+	workers int
+	// Updates handled at once by handleUnordered without waiting for the previous updates of the user.
+	// It runs beside the handler of the user, so it must not touch the state of the user.
+	unordered       func(update *tgbotapi.Update) bool
+	handleUnordered func(update *tgbotapi.Update)
+	maxUserQueued   int
+	// A slot is taken by every queued or running update
+	slots chan struct{}
+	// Users with pending updates and no running one, each user is here at most once
+	ready  chan int64
+	mu     sync.Mutex
+	queues map[int64][]*queuedUpdate
+	wg     sync.WaitGroup
+	// Counts the queued and running updates for Drain
+	pending sync.WaitGroup
+	stats   dispatcherCounters
+}
+
+type queuedUpdate struct {
+	update   *tgbotapi.Update
+	queuedAt time.Time
+}
+
+type dispatcherCounters struct {
+	queued    atomic.Int64
+	running   atomic.Int64
+	handled   atomic.Int64
+	dropped   atomic.Int64
+	maxWaitNs atomic.Int64
+}
+
+// DispatcherStats is a snapshot of the dispatcher metrics
+type DispatcherStats struct {
+	Workers int
+	// Updates waiting for a worker or for the previous update of the user
+	Queued  int64
+	Running int64
+	Handled int64
+	// Updates rejected because the queue of the user was full
+	Dropped int64
+	Users   int
+	// Longest time an update waited in the queue
+	MaxWait time.Duration
+}
+
+func NewDispatcher(workers, maxQueued, maxUserQueued int, handle func(update *tgbotapi.Update),
+	unordered func(update *tgbotapi.Update) bool, handleUnordered func(update *tgbotapi.Update)) *Dispatcher {
+	return &Dispatcher{
+		handle:          handle,
+		workers:         workers,
+		unordered:       unordered,
+		handleUnordered: handleUnordered,
+		maxUserQueued:   maxUserQueued,
+		slots:           make(chan struct{}, maxQueued),
+		ready:           make(chan int64, maxQueued),
+		queues:          map[int64][]*queuedUpdate{},
+	}
+}
+
+// Run starts the workers, they stop when ctx is done
+func (d *Dispatcher) Run(ctx context.Context) {
+	for range d.workers {
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			d.work(ctx)
+		}()
+	}
+}
+
+// Wait returns after the workers stopped
+func (d *Dispatcher) Wait() {
+	d.wg.Wait()
+}
+
+// Dispatch queues the update, it waits while the dispatcher is full
+func (d *Dispatcher) Dispatch(ctx context.Context, update *tgbotapi.Update) error {
+	method := "Dispatch()"
+	select {
+	case d.slots <- struct{}{}:
+	case <-ctx.Done():
+		return fmt.Errorf("%s: %w", method, ctx.Err())
+	}
+	d.pending.Add(1)
+
+	if d.unordered != nil && d.unordered(update) {
+		d.stats.running.Add(1)
+		go func() {
+			defer d.release()
+			d.handleUnordered(update)
+		}()
+		return nil
+	}
+
+	// Updates without a user share one queue
+	var userID int64
+	if user := fixedSentFrom(update); user != nil {
+		userID = user.ID
+	}
+	d.mu.Lock()
+	queue, active := d.queues[userID]
+	if len(queue) >= d.maxUserQueued {
+		d.mu.Unlock()
+		<-d.slots
+		d.pending.Done()
+		d.stats.dropped.Add(1)
+		return fmt.Errorf("%s: %w: %d", method, errUserQueueFull, userID)
+	}
+	d.queues[userID] = append(queue, &queuedUpdate{update: update, queuedAt: time.Now()})
+	d.stats.queued.Add(1)
+	d.mu.Unlock()
+
+	// An active user is put back to ready by the worker handling its current update
+	if !active {
+		d.ready <- userID
+	}
+	return nil
+}
+
+func (d *Dispatcher) work(ctx context.Context) {
+	for {
+		select {
+		case userID := <-d.ready:
+			d.handleNext(userID)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// handleNext handles the oldest update of the user and puts the user back to ready if it has more
+func (d *Dispatcher) handleNext(userID int64) {
+	d.mu.Lock()
+	next := d.queues[userID][0]
+	d.queues[userID] = d.queues[userID][1:]
+	d.mu.Unlock()
+
+	d.stats.queued.Add(-1)
+	d.stats.running.Add(1)
+	wait := time.Since(next.queuedAt).Nanoseconds()
+	for {
+		maxWait := d.stats.maxWaitNs.Load()
+		if wait <= maxWait || d.stats.maxWaitNs.CompareAndSwap(maxWait, wait) {
+			break
+		}
+	}
+
+	d.handle(next.update)
+	d.release()
+
+	d.mu.Lock()
+	if len(d.queues[userID]) == 0 {
+		delete(d.queues, userID)
+		d.mu.Unlock()
+		return
+	}
+	d.mu.Unlock()
+	d.ready <- userID
+}
+
+func (d *Dispatcher) release() {
+	d.stats.running.Add(-1)
+	d.stats.handled.Add(1)
+	<-d.slots
+	d.pending.Done()
+}
+
+// Drain waits until the queued and running updates are handled. Dispatch must not be called
+// after Drain is started.
+func (d *Dispatcher) Drain(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		d.pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("Drain(): %w", ctx.Err())
+	}
+}
+
+func (d *Dispatcher) Stats() DispatcherStats {
+	d.mu.Lock()
+	users := len(d.queues)
+	d.mu.Unlock()
+	return DispatcherStats{
+		Workers: d.workers,
+		Queued:  d.stats.queued.Load(),
+		Running: d.stats.running.Load(),
+		Handled: d.stats.handled.Load(),
+		Dropped: d.stats.dropped.Load(),
+		Users:   users,
+		MaxWait: time.Duration(d.stats.maxWaitNs.Load()),
+	}
+}
@@ -0,0 +1,230 @@
+package maincontroller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"tgbot/internal/config"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func newCancelUpdate(updateID int, userID int64) *tgbotapi.Update {
+	return newCallbackUpdate(updateID, userID, fmt.Sprint(callbackTypeCancelRequest))
+}
+
+// runDispatcher starts the workers and stops them when the test ends
+func runDispatcher(t *testing.T, d *Dispatcher) {
+	ctx, cancel := context.WithCancel(context.Background())
+	d.Run(ctx)
+	t.Cleanup(func() {
+		cancel()
+		d.Wait()
+	})
+}
+
+func drain(t *testing.T, d *Dispatcher) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := d.Drain(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// A burst of updates of several users on more workers than users: handlers of a user never
+// overlap and run in the order the updates arrived
+func TestDispatcherUserOrder(t *testing.T) {
+	const users, updates = 4, 50
+	var mu sync.Mutex
+	handled := map[int64][]int{}
+	var running [users]atomic.Int32
+
+	d := NewDispatcher(8, users*updates, updates, func(update *tgbotapi.Update) {
+		userID := update.Message.From.ID
+		if running[userID].Add(1) != 1 {
+			t.Errorf("handlers of user %d overlap", userID)
+		}
+		time.Sleep(100 * time.Microsecond)
+		mu.Lock()
+		handled[userID] = append(handled[userID], update.UpdateID)
+		mu.Unlock()
+		running[userID].Add(-1)
+	}, nil, nil)
+	runDispatcher(t, d)
+
+	for i := range updates {
+		for userID := range int64(users) {
+			if err := d.Dispatch(context.Background(), newTextUpdate(i, userID, "hi")); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	drain(t, d)
+
+	for userID := range int64(users) {
+		ids := handled[userID]
+		if len(ids) != updates {
+			t.Fatalf("user %d: %d updates handled, want %d", userID, len(ids), updates)
+		}
+		for i, id := range ids {
+			if id != i {
+				t.Fatalf("user %d: updates handled in order %v", userID, ids)
+			}
+		}
+	}
+	if stats := d.Stats(); stats.Handled != users*updates || stats.Queued != 0 || stats.Running != 0 || stats.Users != 0 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+// Handlers of different users run at the same time: each of them waits for all the others to start
+func TestDispatcherUsersInParallel(t *testing.T) {
+	const users = 3
+	var started sync.WaitGroup
+	started.Add(users)
+	allStarted := make(chan struct{})
+	go func() {
+		started.Wait()
+		close(allStarted)
+	}()
+
+	d := NewDispatcher(users, users, 1, func(update *tgbotapi.Update) {
+		started.Done()
+		select {
+		case <-allStarted:
+		case <-time.After(5 * time.Second):
+			t.Errorf("user %d: handlers of the other users are not running", update.Message.From.ID)
+		}
+	}, nil, nil)
+	runDispatcher(t, d)
+
+	for userID := range int64(users) {
+		if err := d.Dispatch(context.Background(), newTextUpdate(1, userID, "hi")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	drain(t, d)
+}
+
+func TestDispatcherUserQueueFull(t *testing.T) {
+	d := NewDispatcher(1, 10, 2, func(*tgbotapi.Update) {}, nil, nil)
+
+	// The workers are not running yet, so the updates stay queued
+	for i := range 2 {
+		if err := d.Dispatch(context.Background(), newTextUpdate(i, 1, "hi")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.Dispatch(context.Background(), newTextUpdate(2, 1, "hi")); !errors.Is(err, errUserQueueFull) {
+		t.Fatalf("error = %v, want %v", err, errUserQueueFull)
+	}
+	if err := d.Dispatch(context.Background(), newTextUpdate(3, 2, "hi")); err != nil {
+		t.Fatalf("queue of another user: %v", err)
+	}
+
+	runDispatcher(t, d)
+	drain(t, d)
+	if stats := d.Stats(); stats.Handled != 3 || stats.Dropped != 1 {
+		t.Errorf("stats = %+v, want 3 handled and 1 dropped", stats)
+	}
+}
+
+// The cancel doesn't wait behind the running handler of the user it cancels
+func TestDispatcherUnordered(t *testing.T) {
+	release := make(chan struct{})
+	canceled := make(chan struct{})
+	d := NewDispatcher(1, 10, 10, func(*tgbotapi.Update) {
+		<-release
+	}, isCancelRequestUpdate, func(*tgbotapi.Update) {
+		close(canceled)
+	})
+	runDispatcher(t, d)
+
+	if err := d.Dispatch(context.Background(), newTextUpdate(1, 1, "hi")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Dispatch(context.Background(), newCancelUpdate(2, 1)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-canceled:
+	case <-time.After(5 * time.Second):
+		t.Error("cancel waits for the running handler")
+	}
+	close(release)
+	drain(t, d)
+}
+
+// The cancel runs beside the handlers of the same user, which load and change the user.
+// Under -race this shows the cancel only touches the request pool.
+func TestCancelRequestBesideHandler(t *testing.T) {
+	mc, tg := newTestController(t, func(cfg *config.Config) {
+		cfg.Dispatcher.MaxUserQueued = 20
+	})
+	const userID = 42
+
+	updateID := 0
+	for range 20 {
+		updateID++
+		if err := mc.dispatcher.Dispatch(context.Background(), newTextUpdate(updateID, userID, "Hello there")); err != nil {
+			t.Fatal(err)
+		}
+		updateID++
+		if err := mc.dispatcher.Dispatch(context.Background(), newCancelUpdate(updateID, userID)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	drain(t, mc.dispatcher)
+
+	// An active request is canceled and removed from the pool
+	req := newRequest(newTextUpdate(updateID+1, userID, "Hello there"))
+	mc.addRequestToPool(userID, req)
+	mc.handleCancelRequestUpdate(newCancelUpdate(updateID+2, userID))
+	if req.AICtx.Err() == nil {
+		t.Error("active request is not canceled")
+	}
+	if _, ok := mc.hasActiveUserRequest(userID); ok {
+		t.Error("canceled request is still in the pool")
+	}
+
+	if answered := len(tg.callsOf("answerCallbackQuery")); answered != 21 {
+		t.Errorf("%d callbacks answered, want 21", answered)
+	}
+}
+
+// Two messages of a user arriving at once: both handlers load and change the same user, so
+// without the dispatcher they race under -race. Through it they run one after another.
+func TestUserMessagesInParallel(t *testing.T) {
+	mc, tg := newTestController(t, nil)
+	const userID = 42
+
+	var dispatched sync.WaitGroup
+	for i, text := range []string{"Hello there", "And again"} {
+		dispatched.Add(1)
+		go func() {
+			defer dispatched.Done()
+			if err := mc.dispatcher.Dispatch(context.Background(), newTextUpdate(i+1, userID, text)); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	dispatched.Wait()
+	drain(t, mc.dispatcher)
+
+	us, err := mc.store.GetUserShellByID(context.Background(), userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(us.Context) != 4 {
+		t.Errorf("dialog has %d messages, want both messages and their answers", len(us.Context))
+	}
+	if answers := len(tg.callsOf("sendMessage")); answers != 2 {
+		t.Errorf("%d answers sent, want 2", answers)
+	}
+}
@@ -0,0 +1,293 @@
+package maincontroller
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"tgbot/internal/ai"
+	"tgbot/internal/ai/fake"
+	"tgbot/internal/ai/openai"
+	"tgbot/internal/config"
+	"tgbot/internal/lib/tgmarkdown"
+	"tgbot/internal/localization"
+	"tgbot/internal/store"
+	"tgbot/internal/store/db"
+	"tgbot/migrator"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// The migrations and the locales are read relative to the module root
+func TestMain(m *testing.M) {
+	if err := os.Chdir("../.."); err != nil {
+		panic(err)
+	}
+	localization.MustLoadMessages(localization.LangEN)
+	os.Exit(m.Run())
+}
+
+// tgCall is a request the bot sent to the Telegram stub
+type tgCall struct {
+	Method    string
+	ChatID    string
+	MessageID int
+	// Text without the markup
+	Text      string
+	ParseMode string
+	// Uploaded file
+	File []byte
+	// When the stub received the request
+	Time time.Time
+}
+
+// tgStub answers the Telegram Bot API requests and keeps them
+type tgStub struct {
+	server *httptest.Server
+	mu     sync.Mutex
+	calls  []tgCall
+	nextID int
+	// Latest text by message id
+	texts map[int]string
+	// Answers sent instead of the normal ones by method, one per request
+	failures map[string][]string
+	// Contents of the files by file id
+	files map[string]string
+}
+
+func newTgStub(t *testing.T) *tgStub {
+	stub := &tgStub{texts: map[int]string{}, failures: map[string][]string{}, files: map[string]string{}}
+	stub.server = httptest.NewServer(http.HandlerFunc(stub.serveHTTP))
+	t.Cleanup(stub.server.Close)
+	return stub
+}
+
+func (s *tgStub) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	_ = r.ParseMultipartForm(1 << 20)
+	method := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if strings.HasPrefix(r.URL.Path, "/file/") {
+		content, ok := s.files[method]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = io.WriteString(w, content)
+		return
+	}
+	call := tgCall{Method: method, ChatID: r.FormValue("chat_id"), Text: r.FormValue("text"),
+		ParseMode: r.FormValue("parse_mode"), Time: time.Now()}
+	if r.FormValue("parse_mode") == tgbotapi.ModeMarkdownV2 {
+		call.Text = tgmarkdown.Strip(call.Text)
+	}
+	if r.MultipartForm != nil {
+		for _, headers := range r.MultipartForm.File {
+			if file, err := headers[0].Open(); err == nil {
+				call.File, _ = io.ReadAll(file)
+				_ = file.Close()
+			}
+		}
+	}
+	if failures := s.failures[method]; len(failures) > 0 {
+		s.failures[method] = failures[1:]
+		s.calls = append(s.calls, call)
+		_, _ = io.WriteString(w, failures[0])
+		return
+	}
+	switch method {
+	case "getMe":
+		_, _ = io.WriteString(w, `{"ok":true,"result":{"id":1,"is_bot":true,"first_name":"bot","username":"bot"}}`)
+		return
+	case "sendMessage":
+		s.nextID++
+		call.MessageID = s.nextID
+		s.texts[call.MessageID] = call.Text
+		_, _ = fmt.Fprintf(w, `{"ok":true,"result":{"message_id":%d,"date":0,"chat":{"id":%s}}}`, call.MessageID, call.ChatID)
+	case "getFile":
+		fileID := r.FormValue("file_id")
+		_, _ = fmt.Fprintf(w, `{"ok":true,"result":{"file_id":%q,"file_size":%d,"file_path":%q}}`,
+			fileID, len(s.files[fileID]), fileID)
+	case "editMessageText":
+		_, _ = fmt.Sscan(r.FormValue("message_id"), &call.MessageID)
+		s.texts[call.MessageID] = call.Text
+		_, _ = fmt.Fprintf(w, `{"ok":true,"result":{"message_id":%d,"date":0,"chat":{"id":%s}}}`, call.MessageID, call.ChatID)
+	default:
+		_, _ = io.WriteString(w, `{"ok":true,"result":true}`)
+	}
+	s.calls = append(s.calls, call)
+}
+
+// fail answers the next requests of the method with the error
+func (s *tgStub) fail(method string, times int, code int, description string, retryAfter int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	answer := fmt.Sprintf(`{"ok":false,"error_code":%d,"description":%q,"parameters":{"retry_after":%d}}`,
+		code, description, retryAfter)
+	for range times {
+		s.failures[method] = append(s.failures[method], answer)
+	}
+}
+
+// callsOf returns the requests of the method
+func (s *tgStub) callsOf(method string) []tgCall {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var res []tgCall
+	for _, call := range s.calls {
+		if call.Method == method {
+			res = append(res, call)
+		}
+	}
+	return res
+}
+
+// lastTexts returns the latest texts of the sent messages in the order they were sent
+func (s *tgStub) lastTexts() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := make([]string, 0, len(s.texts))
+	for id := 1; id <= s.nextID; id++ {
+		res = append(res, s.texts[id])
+	}
+	return res
+}
+
+// noUpdates is the source of a controller whose updates are handled by the test itself
+type noUpdates struct{}
+
+func (noUpdates) Start() (<-chan tgbotapi.Update, error) { return nil, nil }
+func (noUpdates) Stop(context.Context) error             { return nil }
+
+func newTestBot(t *testing.T) (*tgbotapi.BotAPI, *tgStub) {
+	t.Helper()
+	stub := newTgStub(t)
+	bot, err := tgbotapi.NewBotAPIWithAPIEndpoint("token", stub.server.URL+"/bot%s/%s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Files are downloaded from the fixed Telegram host
+	bot.Client = &http.Client{Transport: stubTransport{host: strings.TrimPrefix(stub.server.URL, "http://")}}
+	return bot, stub
+}
+
+// stubTransport sends every request to the stub
+type stubTransport struct {
+	host string
+}
+
+func (t stubTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.URL.Scheme, r.URL.Host = "http", t.host
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+// newTestController creates a controller on a new database with the fake AI backend
+// answering the requests of the openai and anthropic models
+func newTestController(t *testing.T, setup func(cfg *config.Config)) (*MainController, *tgStub) {
+	t.Helper()
+	bot, stub := newTestBot(t)
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	cfg := &config.Config{
+		DbDriver:           "sqlite",
+		StoragePath:        filepath.Join(t.TempDir(), "bot.db"),
+		ContextTokenBudget: 16000,
+		Dispatcher:         config.DispatcherConfig{Workers: 4, MaxQueued: 100, MaxUserQueued: 10},
+		TgLimits:           config.TgLimitsConfig{GlobalPerSecond: 1000, ChatPerSecond: 1000, GroupPerMinute: 1000, ChatBurst: 100},
+	}
+	if setup != nil {
+		setup(cfg)
+	}
+
+	m, err := migrator.NewSqliteMigrator(cfg.StoragePath, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.MustMigrate()
+	driver, err := db.NewDBDriver(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := store.New(driver)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transport, err := fake.NewTransport(fake.Options{Delay: cfg.FakeDelay})
+	if err != nil {
+		t.Fatal(err)
+	}
+	providers := ai.NewRegistry()
+	for _, name := range []string{"openai", "anthropic"} {
+		api, err := openai.New(openai.Config{Name: name, BaseURL: "https://api.openai.com/v1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		api.WrapTransport(transport.Wrap)
+		providers.RegisterChatModel(name, api)
+		providers.RegisterTextToSpeech(name, api)
+	}
+	if cfg.Moderation.Moderator == config.ModeratorLocal {
+		moderator, err := ai.NewPatternModerator(cfg.Moderation.Patterns)
+		if err != nil {
+			t.Fatal(err)
+		}
+		providers.RegisterModerator(config.ModeratorLocal, moderator)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	mc, err := New(ctx, bot, noUpdates{}, st, providers, log, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = mc.outbox.Flush(context.Background())
+		cancel()
+		mc.dispatcher.Wait()
+		_ = st.Close()
+	})
+	return mc, stub
+}
+
+func newTextUpdate(updateID int, userID int64, text string) *tgbotapi.Update {
+	return &tgbotapi.Update{
+		UpdateID: updateID,
+		Message: &tgbotapi.Message{
+			MessageID: updateID,
+			From:      &tgbotapi.User{ID: userID, LanguageCode: "en"},
+			Chat:      &tgbotapi.Chat{ID: userID},
+			Text:      text,
+		},
+	}
+}
+
+// newCommandUpdate is a message starting with the command, e.g. "/persona Title"
+func newCommandUpdate(updateID int, userID int64, text string) *tgbotapi.Update {
+	update := newTextUpdate(updateID, userID, text)
+	command, _, _ := strings.Cut(text, " ")
+	command, _, _ = strings.Cut(command, "\n")
+	update.Message.Entities = []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: len(command)}}
+	return update
+}
+
+func newCallbackUpdate(updateID int, userID int64, data string) *tgbotapi.Update {
+	return &tgbotapi.Update{
+		UpdateID: updateID,
+		CallbackQuery: &tgbotapi.CallbackQuery{
+			ID:      fmt.Sprint(updateID),
+			From:    &tgbotapi.User{ID: userID, LanguageCode: "en"},
+			Message: &tgbotapi.Message{MessageID: 1, Chat: &tgbotapi.Chat{ID: userID}},
+			Data:    data,
+		},
+	}
+}
@@ -7,22 +7,37 @@ import (
 	"log/slog"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"tgbot/internal/ai"
+	"tgbot/internal/config"
 	"tgbot/internal/localization"
 	"tgbot/internal/store"
+	"tgbot/internal/updates"
 	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
 type MainController struct {
-	tgBot       *tgbotapi.BotAPI
-	Ctx         context.Context
-	store       *store.Store
-	aiAPI       ai.ChatModel
-	log         *slog.Logger
-	requestPool sync.Map // [UserId] *Request
-	tgAdmin     int64
+	tgBot         *tgbotapi.BotAPI
+	Ctx           context.Context
+	store         *store.Store
+	aiProviders   *ai.Registry
+	tools         *ToolRegistry
+	log           *slog.Logger
+	requestPool   sync.Map // [UserId] *Request
+	tgAdmin       int64
+	contextBudget int          // max tokens of dialog history sent to a model
+	moderator     ai.Moderator // nil if the moderation is disabled
+	moderation    config.ModerationConfig
+	dispatcher    *Dispatcher
+	outbox        *Outbox
+	// Closed by Shutdown to stop dispatching the updates
+	stopping chan struct{}
+	// Closed when the updates are no longer dispatched
+	receiveDone chan struct{}
+	// Set at the shutdown deadline, AI requests started after it are interrupted at once
+	interrupting atomic.Bool
 }
 
 var (
@@ -34,11 +49,36 @@ var (
 
 const (
 	TgMessageMaxLength        int = 3700 // symbols
+	TgCaptionMaxLength        int = 1000 // symbols
 	TgSendingMessageFrequency     = 2000 * time.Millisecond
+	ReasoningStatusMaxLength  int = 1000 // symbols of the reasoning summary shown while the model thinks
+	// Time the interrupted requests have to save their answers after the shutdown deadline
+	ShutdownSaveTimeout = 5 * time.Second
 )
 
-func New(ctx context.Context, tgBot *tgbotapi.BotAPI, st *store.Store, aiAPI ai.ChatModel, log *slog.Logger, tgAdmin int64) (*MainController, error) {
-	mc := MainController{tgBot: tgBot, Ctx: ctx, store: st, aiAPI: aiAPI, log: log, tgAdmin: tgAdmin}
+// New creates the controller and handles the updates of the source until ctx is done
+func New(ctx context.Context, tgBot *tgbotapi.BotAPI, source updates.Source, st *store.Store, aiProviders *ai.Registry, log *slog.Logger, cfg *config.Config) (*MainController, error) {
+	mc := MainController{
+		tgBot:         tgBot,
+		Ctx:           ctx,
+		store:         st,
+		aiProviders:   aiProviders,
+		tools:         newDefaultTools(st),
+		log:           log,
+		tgAdmin:       cfg.TgAdmin,
+		contextBudget: cfg.ContextTokenBudget,
+		moderation:    cfg.Moderation,
+		outbox:        NewOutbox(tgBot, outboxLimits(cfg.TgLimits), log),
+		stopping:      make(chan struct{}),
+		receiveDone:   make(chan struct{}),
+	}
+	if cfg.Moderation.Moderator != "" {
+		moderator, err := aiProviders.Moderator(cfg.Moderation.Moderator)
+		if err != nil {
+			return nil, fmt.Errorf("New(): %w", err)
+		}
+		mc.moderator = moderator
+	}
 
 	// for i := range 25 {
 	// 	_, err := st.AddDialog(context.Background(), &store.UserShell{ID: tgAdmin}, &store.Dialog{Title: fmt.Sprintf("Test dialog %d", i), UserID: tgAdmin})
@@ -48,24 +88,90 @@ func New(ctx context.Context, tgBot *tgbotapi.BotAPI, st *store.Store, aiAPI ai.
 	// 	}
 	// }
 
-	go func() {
-		uConf := tgbotapi.NewUpdate(0)
-		uConf.Timeout = 60
-		tgUpdates := tgBot.GetUpdatesChan(uConf)
-		for {
-			if mc.Ctx.Err() != nil {
+	mc.dispatcher = NewDispatcher(cfg.Dispatcher.Workers, cfg.Dispatcher.MaxQueued, cfg.Dispatcher.MaxUserQueued,
+		mc.handleTgUpdate, isCancelRequestUpdate, mc.handleCancelRequestUpdate)
+	mc.dispatcher.Run(ctx)
+
+	tgUpdates, err := source.Start()
+	if err != nil {
+		return nil, fmt.Errorf("New(): %w", err)
+	}
+	go mc.receiveUpdates(tgUpdates)
+
+	return &mc, nil
+}
+
+// receiveUpdates dispatches the updates until the source is stopped or Shutdown is called
+func (mc *MainController) receiveUpdates(tgUpdates <-chan tgbotapi.Update) {
+	defer close(mc.receiveDone)
+	for {
+		select {
+		case update, ok := <-tgUpdates:
+			if !ok {
 				return
 			}
-			select {
-			case update := <-tgUpdates:
-				go mc.handleTgUpdate(&update)
-			case <-mc.Ctx.Done():
-				return
+			mc.dispatchUpdate(&update)
+		case <-mc.stopping:
+			// Updates already received are handled too, Telegram won't send them again
+			for {
+				select {
+				case update, ok := <-tgUpdates:
+					if !ok {
+						return
+					}
+					mc.dispatchUpdate(&update)
+				default:
+					return
+				}
 			}
+		case <-mc.Ctx.Done():
+			return
+		}
+	}
+}
+
+func (mc *MainController) dispatchUpdate(update *tgbotapi.Update) {
+	if err := mc.dispatcher.Dispatch(mc.Ctx, update); err != nil {
+		mc.log.Warn("Update not dispatched",
+			slog.Attr{Key: "Update id", Value: slog.IntValue(update.UpdateID)},
+			slog.Attr{Key: "Error", Value: slog.StringValue(err.Error())})
+	}
+}
+
+// Shutdown stops dispatching the updates, waits until the updates in progress are handled
+// and their messages are sent. AI requests still running at the deadline of ctx are interrupted,
+// the answers are saved with the text received so far.
+func (mc *MainController) Shutdown(ctx context.Context) error {
+	method := "Shutdown()"
+	close(mc.stopping)
+	<-mc.receiveDone
+
+	if err := mc.dispatcher.Drain(ctx); err == nil {
+		if err := mc.outbox.Flush(ctx); err != nil {
+			return fmt.Errorf("%s: %w", method, err)
 		}
-	}()
+		return nil
+	}
 
-	return &mc, nil
+	mc.interrupting.Store(true)
+	interrupted := 0
+	mc.requestPool.Range(func(_, v any) bool {
+		v.(*Request).interrupt()
+		interrupted++
+		return true
+	})
+	mc.log.Warn("Shutdown deadline reached, requests interrupted",
+		slog.Attr{Key: "Requests", Value: slog.IntValue(interrupted)})
+
+	saveCtx, cancel := context.WithTimeout(context.Background(), ShutdownSaveTimeout)
+	defer cancel()
+	if err := mc.dispatcher.Drain(saveCtx); err != nil {
+		return fmt.Errorf("%s: %w", method, err)
+	}
+	if err := mc.outbox.Flush(saveCtx); err != nil {
+		return fmt.Errorf("%s: %w", method, err)
+	}
+	return nil
 }
 
 func (mc *MainController) handleTgUpdate(update *tgbotapi.Update) {
@@ -146,6 +252,14 @@ func (mc *MainController) handleTgUpdate(update *tgbotapi.Update) {
 		default:
 		}
 	}
+
+	// Edits and deletes are sent in the background, their errors are known once they are sent
+	if syncErr := mc.outbox.Sync(mc.Ctx, user.ID); syncErr != nil {
+		syncErr = mc.checkBlockedBot(user, syncErr)
+		if err == nil {
+			err = fmt.Errorf("handle tg update(): %w", syncErr)
+		}
+	}
 	mc.handledLog(err, update.UpdateID, startTime)
 }
 
@@ -179,32 +293,44 @@ func (mc *MainController) sendMessageToTgBot(user *store.UserShell, message tgbo
 		return tgbotapi.Message{}, fmt.Errorf("sendMessageToTgBot(): %w", errUserBlockedBot)
 	}
 
-	if msg, ok := message.(tgbotapi.CallbackConfig); ok {
+	switch msg := message.(type) {
+	case tgbotapi.CallbackConfig, tgbotapi.ChatActionConfig:
+		// Not messages, Telegram doesn't count them in the limits
 		_, _ = mc.tgBot.Request(msg)
 		return tgbotapi.Message{}, nil
-	}
-	if msg, ok := message.(tgbotapi.DeleteMessageConfig); ok {
-		_, _ = mc.tgBot.Request(msg)
+	case tgbotapi.DeleteMessageConfig:
+		mc.outbox.Post(msg)
+		return tgbotapi.Message{}, nil
+	case tgbotapi.EditMessageTextConfig:
+		// Nobody waits for edits, the newest text of the message is sent when the limits allow
+		mc.outbox.Edit(msg)
 		return tgbotapi.Message{}, nil
 	}
 
-	msg, err := mc.tgBot.Send(message)
-	if err != nil && strings.Contains(err.Error(), "bot was blocked by the user") {
-		mc.store.SetSelfBlockUser(user, true)
-		return tgbotapi.Message{}, fmt.Errorf("sendMessageToTgBot1(): %w", errUserBlockedBot)
-	}
+	msg, err := mc.outbox.Send(message)
 	if err != nil {
-		return tgbotapi.Message{}, fmt.Errorf("sendMessageToTgBot(): %w", err)
+		return tgbotapi.Message{}, fmt.Errorf("sendMessageToTgBot(): %w", mc.checkBlockedBot(user, err))
 	}
 	return msg, nil
 }
 
+// checkBlockedBot marks the user who blocked the bot, the error becomes errUserBlockedBot
+func (mc *MainController) checkBlockedBot(user *store.UserShell, err error) error {
+	if !strings.Contains(err.Error(), "bot was blocked by the user") {
+		return err
+	}
+	if user != nil {
+		mc.store.SetSelfBlockUser(user, true)
+	}
+	return errUserBlockedBot
+}
+
 func (mc *MainController) sendErrorToTgBot(method string, userShell *store.UserShell, err error) error {
 	if err == nil {
 		return nil
 	}
 
-	_, sendErr := mc.sendMessageToTgBot(userShell, newTgMessage(userShell.ID, localeText(userShell.Locale, localization.MTypeMsgCommonError)))
+	_, sendErr := mc.sendMessageToTgBot(userShell, newTgMessage(userShell.ID, localeText(userShell.Locale, errorMessageType(err))))
 	if sendErr != nil {
 		return fmt.Errorf("%s: %w: %w", method, errSendErrorMessage, sendErr)
 	}
@@ -213,6 +339,9 @@ func (mc *MainController) sendErrorToTgBot(method string, userShell *store.UserS
 
 func (mc *MainController) addRequestToPool(userID int64, req *Request) {
 	mc.requestPool.Store(userID, req)
+	if mc.interrupting.Load() {
+		req.interrupt()
+	}
 }
 
 func (mc *MainController) hasActiveUserRequest(userID int64) (*Request, bool) {
@@ -229,6 +358,62 @@ func (mc *MainController) cancelPreviousRequest(userID int64) {
 	}
 }
 
+// chatModel resolves the user's AI model to the backend serving it
+func (mc *MainController) chatModel(modelID int32) (ai.ChatModel, *store.AiModel, error) {
+	model, ok := mc.store.AIModelByID(modelID)
+	if !ok || model.ModelType != store.TypeChat {
+		return nil, nil, fmt.Errorf("chatModel(): %w: %d", store.ErrIncorrectAIModel, modelID)
+	}
+
+	chatModel, err := mc.aiProviders.ChatModel(model.Provider)
+	if err != nil {
+		return nil, nil, fmt.Errorf("chatModel(): %w", err)
+	}
+	return chatModel, model, nil
+}
+
+// imageModel resolves the user's image model to the backend serving it
+func (mc *MainController) imageModel(modelID int32) (ai.ImageModel, *store.AiModel, error) {
+	model, ok := mc.store.AIModelByID(modelID)
+	if !ok || model.ModelType != store.TypeGenerateImage {
+		return nil, nil, fmt.Errorf("imageModel(): %w: %d", store.ErrIncorrectAIModel, modelID)
+	}
+
+	imageModel, err := mc.aiProviders.ImageModel(model.Provider)
+	if err != nil {
+		return nil, nil, fmt.Errorf("imageModel(): %w", err)
+	}
+	return imageModel, model, nil
+}
+
+// speechToText resolves the speech-to-text model to the backend serving it
+func (mc *MainController) speechToText(modelID int32) (ai.SpeechToText, *store.AiModel, error) {
+	model, ok := mc.store.AIModelByID(modelID)
+	if !ok || model.ModelType != store.TypeSpeechToText {
+		return nil, nil, fmt.Errorf("speechToText(): %w: %d", store.ErrIncorrectAIModel, modelID)
+	}
+
+	speechToText, err := mc.aiProviders.SpeechToText(model.Provider)
+	if err != nil {
+		return nil, nil, fmt.Errorf("speechToText(): %w", err)
+	}
+	return speechToText, model, nil
+}
+
+// textToSpeech resolves the text-to-speech model to the backend serving it
+func (mc *MainController) textToSpeech(modelID int32) (ai.TextToSpeech, *store.AiModel, error) {
+	model, ok := mc.store.AIModelByID(modelID)
+	if !ok || model.ModelType != store.TypeTextToSpeech {
+		return nil, nil, fmt.Errorf("textToSpeech(): %w: %d", store.ErrIncorrectAIModel, modelID)
+	}
+
+	textToSpeech, err := mc.aiProviders.TextToSpeech(model.Provider)
+	if err != nil {
+		return nil, nil, fmt.Errorf("textToSpeech(): %w", err)
+	}
+	return textToSpeech, model, nil
+}
+
 func (mc *MainController) itsAdmin(userID int64) bool {
 	return userID == mc.tgAdmin
 }
@@ -3,10 +3,12 @@ package maincontroller
 import (
 	"errors"
 	"fmt"
+	"log/slog"
 	"strconv"
 	"strings"
 	"tgbot/internal/localization"
 	"tgbot/internal/store"
+	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
@@ -30,6 +32,10 @@ const (
 	callbackTypeCancelRequest
 	callbackTypeTariff
 	callbackTypeToggleNewDialog
+	callbackTypeToggleVoiceAnswer
+	callbackTypePersona
+	callbackTypeDeletePersona
+	callbackTypeSettings
 )
 
 type CallbackNotifyType int
@@ -75,12 +81,18 @@ func (mc *MainController) handleTgCallback(req *Request) *MessageManager {
 			handleCallbackAllMessages(req, msgEx)
 		case callbackTypeHandleLastMessage:
 			handleCallbackHandleLastMessage(mc, req, data, msgEx)
-		case callbackTypeCancelRequest:
-			handleCallbackCancelRequest(mc, req, msgEx)
 		case callbackTypeTariff:
 			handleCallbackTariff(mc, req, data, msgEx)
 		case callbackTypeToggleNewDialog:
 			handleCallbackTypeToggleNewDialog(mc, req, data, msgEx)
+		case callbackTypeToggleVoiceAnswer:
+			handleCallbackTypeToggleVoiceAnswer(mc, req, data, msgEx)
+		case callbackTypePersona:
+			handleCallbackPersona(mc, req, data, msgEx)
+		case callbackTypeDeletePersona:
+			handleCallbackDeletePersona(mc, req, data, msgEx)
+		case callbackTypeSettings:
+			handleCallbackSettings(mc, req, data, msgEx)
 		default:
 			msgEx.sendError(fmt.Errorf("%s: %w", method, errFailedMatchCallbackType))
 			return
@@ -273,17 +285,24 @@ func handleCallbackNotify(req *Request, data []string, msgEx *MessageManager) {
 func handleCallbackAllMessages(req *Request, msgEx *MessageManager) {
 	var sb strings.Builder
 	for _, v := range req.UserShell.Context {
+		if v.IsSummary() || v.IsTool() {
+			continue
+		}
 		prefix := fmt.Sprintf("🧑‍💻 %s: ", localeText(req.UserShell.Locale, localization.MTypeMsgYou))
 		if v.Role != store.RoleUser {
 			prefix = fmt.Sprintf("🤖 %s: ", localeText(req.UserShell.Locale, localization.MTypeMsgAssistant))
 		}
-		sb.WriteString(prefix)
-		sb.WriteString(v.Content)
-		sb.WriteString("\n")
-		if sb.Len() > TgMessageMaxLength {
+		content := collapseDocuments(v.Content)
+		if runes := []rune(content); len(runes) > TgMessageMaxLength-len(prefix) {
+			content = string(runes[:TgMessageMaxLength-len(prefix)]) + "…"
+		}
+		if sb.Len() > 0 && sb.Len()+len(prefix)+len(content) > TgMessageMaxLength {
 			_, _ = msgEx.send(newTgMessage(req.UserShell.ID, sb.String()))
 			sb.Reset()
 		}
+		sb.WriteString(prefix)
+		sb.WriteString(content)
+		sb.WriteString("\n")
 	}
 
 	if sb.Len() > 0 {
@@ -308,27 +327,43 @@ func handleCallbackHandleLastMessage(mc *MainController, req *Request, data []st
 		}
 	}
 
-	// TODO
-	//err := mc.handleTgMessage(req, ch)
-	//if err != nil {
-	//	return nil, fmt.Errorf("%s: %w", method, err)
-	//}
+	us := req.UserShell
+	if us.LastText == "" && us.LastImageFileID == "" && us.LastAttachment == nil {
+		return
+	}
+	// Without a message in the update the pending one is answered
+	mc.answerMessage(req, msgEx)
 }
 
-func handleCallbackCancelRequest(mc *MainController, req *Request, msgEx *MessageManager) {
-	_, ok := mc.hasActiveUserRequest(req.UserShell.ID)
-	if !ok {
-		msg := tgbotapi.NewCallbackWithAlert(req.Update.CallbackQuery.ID, notifyMessage(callbackNotifyRequestAlreadyCancelled, req.UserShell.Locale))
-		msg.ShowAlert = false
-		_, _ = msgEx.send(msg)
+// isCancelRequestUpdate reports whether the update cancels the active request of the user.
+// It must not wait in the queue of the user behind the request it cancels, so it is
+// handled by handleCancelRequestUpdate instead of handleTgCallback.
+func isCancelRequestUpdate(update *tgbotapi.Update) bool {
+	if update.CallbackQuery == nil {
+		return false
 	}
+	cbType, _, _ := strings.Cut(update.CallbackQuery.Data, ";")
+	return cbType == fmt.Sprint(callbackTypeCancelRequest)
+}
 
-	mc.cancelPreviousRequest(req.UserShell.ID)
-
-	msg := tgbotapi.NewCallbackWithAlert(req.Update.CallbackQuery.ID, notifyMessage(callbackNotifyRequestCanceled, req.UserShell.Locale))
-	msg.ShowAlert = false
+// handleCancelRequestUpdate cancels the active request of the user. It runs beside the handler
+// of the request, so it doesn't load the user and only touches the request pool.
+func (mc *MainController) handleCancelRequestUpdate(update *tgbotapi.Update) {
+	tgUser := fixedSentFrom(update)
+	startTime := time.Now()
+	mc.log.Info("Get cancel request update",
+		slog.Attr{Key: "Update id", Value: slog.IntValue(update.UpdateID)},
+		slog.Attr{Key: "User id", Value: slog.Int64Value(tgUser.ID)})
+
+	notify := callbackNotifyRequestAlreadyCancelled
+	if _, ok := mc.hasActiveUserRequest(tgUser.ID); ok {
+		mc.cancelPreviousRequest(tgUser.ID)
+		notify = callbackNotifyRequestCanceled
+	}
 
-	_, _ = msgEx.send(msg)
+	msg := tgbotapi.NewCallback(update.CallbackQuery.ID, notifyMessage(notify, tgUser.LanguageCode))
+	_, err := mc.sendMessageToTgBot(nil, msg)
+	mc.handledLog(err, update.UpdateID, startTime)
 }
 
 func handleCallbackTariff(mc *MainController, req *Request, data []string, msgEx *MessageManager) {
@@ -390,3 +425,154 @@ func handleCallbackTypeToggleNewDialog(mc *MainController, req *Request, data []
 
 	_, _ = msgEx.send(msg)
 }
+
+func handleCallbackTypeToggleVoiceAnswer(mc *MainController, req *Request, data []string, msgEx *MessageManager) {
+	method := "handleCallbackTypeToggleVoiceAnswer()"
+	if err := checkDataLen(data, 2, method); err != nil {
+		msgEx.sendError(err)
+		return
+	}
+
+	err := mc.store.ToggleUserVoiceAnswer(req.Ctx, req.UserShell)
+	if err != nil {
+		msgEx.sendError(err)
+		return
+	}
+
+	text, kb, err := prepareProfileMessage(mc, req.UserShell)
+	if err != nil {
+		msgEx.sendError(fmt.Errorf("%s: %w", method, err))
+		return
+	}
+
+	msg := newTgEditMessage(req.UserShell.ID, req.Update.CallbackQuery.Message.MessageID, text)
+	msg.ReplyMarkup = kb
+
+	_, _ = msgEx.send(msg)
+}
+
+// Sets the persona of the active dialog or of the next one, 0 removes the persona
+func handleCallbackPersona(mc *MainController, req *Request, data []string, msgEx *MessageManager) {
+	method := "handleCallbackPersona()"
+	if err := checkDataLen(data, 3, method); err != nil {
+		msgEx.sendError(err)
+		return
+	}
+
+	personaID, err := strconv.ParseInt(data[2], 10, 64)
+	if err != nil {
+		msgEx.sendError(fmt.Errorf("%s: %w", method, err))
+		return
+	}
+	if personaID != 0 {
+		if _, err = mc.store.PersonaByID(req.Ctx, req.UserShell.ID, personaID); err != nil {
+			msgEx.sendError(fmt.Errorf("%s: %w", method, err))
+			return
+		}
+	}
+
+	if err = mc.store.SetPersona(req.Ctx, req.UserShell, personaID); err != nil {
+		msgEx.sendError(fmt.Errorf("%s: %w", method, err))
+		return
+	}
+
+	text, kb, err := preparePersonas(mc, req)
+	if err != nil {
+		msgEx.sendError(fmt.Errorf("%s: %w", method, err))
+		return
+	}
+
+	msg := newTgEditMessage(req.UserShell.ID, req.Update.CallbackQuery.Message.MessageID, text)
+	msg.ReplyMarkup = kb
+	_, _ = msgEx.send(msg)
+}
+
+func handleCallbackDeletePersona(mc *MainController, req *Request, data []string, msgEx *MessageManager) {
+	method := "handleCallbackDeletePersona()"
+	if err := checkDataLen(data, 3, method); err != nil {
+		msgEx.sendError(err)
+		return
+	}
+
+	personaID, err := strconv.ParseInt(data[2], 10, 64)
+	if err != nil {
+		msgEx.sendError(fmt.Errorf("%s: %w", method, err))
+		return
+	}
+
+	persona, err := mc.store.PersonaByID(req.Ctx, req.UserShell.ID, personaID)
+	if err != nil {
+		msgEx.sendError(fmt.Errorf("%s: %w", method, err))
+		return
+	}
+	if persona.UserID != req.UserShell.ID {
+		msgEx.sendError(fmt.Errorf("%s: %w", method, ErrPermissionDenied))
+		return
+	}
+
+	if err = mc.store.DeletePersona(req.Ctx, req.UserShell, personaID); err != nil {
+		msgEx.sendError(fmt.Errorf("%s: %w", method, err))
+		return
+	}
+
+	text, kb, err := preparePersonas(mc, req)
+	if err != nil {
+		msgEx.sendError(fmt.Errorf("%s: %w", method, err))
+		return
+	}
+
+	text = localeText(req.UserShell.Locale, localization.MTypeMsgPersonaDeleted, persona.Title) + "\n\n" + text
+	msg := newTgEditMessage(req.UserShell.ID, req.Update.CallbackQuery.Message.MessageID, text)
+	msg.ReplyMarkup = kb
+	_, _ = msgEx.send(msg)
+}
+
+// Shows the generation settings of the scope, with a parameter and a value also changes the parameter
+func handleCallbackSettings(mc *MainController, req *Request, data []string, msgEx *MessageManager) {
+	method := "handleCallbackSettings()"
+	if err := checkDataLen(data, 3, method); err != nil {
+		msgEx.sendError(err)
+		return
+	}
+
+	scope, err := strconv.Atoi(data[2])
+	if err != nil {
+		msgEx.sendError(fmt.Errorf("%s: %w", method, err))
+		return
+	}
+
+	us := req.UserShell
+	if len(data) >= 5 {
+		param, err := strconv.Atoi(data[3])
+		if err != nil {
+			msgEx.sendError(fmt.Errorf("%s: %w", method, err))
+			return
+		}
+
+		dialogScope := settingsScope(scope) == settingsScopeDialog && us.Dialog != nil
+		params := us.User.Params
+		if dialogScope {
+			params = us.Dialog.Params
+		}
+		params, err = setParam(params, generationParam(param), data[4])
+		if err == nil && dialogScope {
+			err = mc.store.SetDialogParams(req.Ctx, us, params)
+		} else if err == nil {
+			err = mc.store.SetUserParams(req.Ctx, us, params)
+		}
+		if err != nil {
+			msgEx.sendError(fmt.Errorf("%s: %w", method, err))
+			return
+		}
+	}
+
+	text, kb, err := prepareSettings(mc, us, settingsScope(scope))
+	if err != nil {
+		msgEx.sendError(fmt.Errorf("%s: %w", method, err))
+		return
+	}
+
+	msg := newTgEditMessage(us.ID, req.Update.CallbackQuery.Message.MessageID, text)
+	msg.ReplyMarkup = kb
+	_, _ = msgEx.send(msg)
+}
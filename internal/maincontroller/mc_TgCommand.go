@@ -7,6 +7,9 @@ import (
 	"strconv"
 	"strings"
 	"tgbot/internal/localization"
+	"tgbot/internal/store"
+	"time"
+	"unicode/utf8"
 )
 
 type TgCommand string
@@ -17,9 +20,13 @@ const (
 	CmdNew            TgCommand = "new"
 	CmdTariffs        TgCommand = "tariffs"
 	CmdProfile        TgCommand = "profile"
+	CmdImage          TgCommand = "image"
+	CmdPersona        TgCommand = "persona"
+	CmdSettings       TgCommand = "settings"
 	CmdSetMaintenance TgCommand = "setMaintenance"
 	CmdBlockUser      TgCommand = "blockUser"
 	CmdUnblockUser    TgCommand = "unblockUser"
+	CmdStats          TgCommand = "stats"
 )
 
 var adminCommands = map[TgCommand]any{
@@ -27,6 +34,7 @@ var adminCommands = map[TgCommand]any{
 	CmdSetMaintenance: nil,
 	CmdBlockUser:      nil,
 	CmdUnblockUser:    nil,
+	CmdStats:          nil,
 }
 
 var (
@@ -42,6 +50,8 @@ func (mc *MainController) handleTgCommand(req *Request) *MessageManager {
 	go func() {
 		defer msgEx.close()
 
+		req.UserShell.AwaitingImagePrompt = false
+
 		if !checkCommandPermissions(mc.tgAdmin, req.UserShell.ID, cmd) {
 			msgEx.sendError(fmt.Errorf("handleTgCommand(): %w", ErrPermissionDenied))
 			return
@@ -58,12 +68,20 @@ func (mc *MainController) handleTgCommand(req *Request) *MessageManager {
 			handleCommandTariffs(mc, msgEx, req)
 		case CmdProfile:
 			handleCommandProfile(mc, msgEx, req)
+		case CmdImage:
+			handleCommandImage(mc, msgEx, req)
+		case CmdPersona:
+			handleCommandPersona(mc, msgEx, req)
+		case CmdSettings:
+			handleCommandSettings(mc, msgEx, req)
 		case CmdSetMaintenance:
 			handleCommandSetMaintenance(mc, msgEx, req)
 		case CmdBlockUser:
 			handleCommandBlockUser(mc, msgEx, req)
 		case CmdUnblockUser:
 			handleCommandUnblockUser(mc, msgEx, req)
+		case CmdStats:
+			handleCommandStats(mc, msgEx, req)
 		default:
 			msgEx.sendError(fmt.Errorf("handleTgCommand(): %w: '%s'", ErrCommandNotFound, cmd))
 		}
@@ -101,12 +119,21 @@ func handleCommandDialogs(mc *MainController, msgEx *MessageManager, req *Reques
 	_, _ = msgEx.send(msg)
 }
 
+// Resets the active dialog and offers to choose the persona of the next one
 func handleCommandNew(mc *MainController, msgEx *MessageManager, req *Request) {
+	method := "handleCommandNew()"
 	if err := mc.store.ResetActiveDialog(req.Ctx, req.UserShell); err != nil {
-		msgEx.sendError(fmt.Errorf("handleCommandNew(): %w", err))
+		msgEx.sendError(fmt.Errorf("%s: %w", method, err))
 	}
 
-	_, _ = msgEx.send(newTgMessage(req.UserShell.ID, localeText(req.UserShell.Locale, localization.MTypeMsgNewDialogCreated)))
+	msg := newTgMessage(req.UserShell.ID, localeText(req.UserShell.Locale, localization.MTypeMsgNewDialogCreated))
+	_, kb, err := preparePersonas(mc, req)
+	if err != nil {
+		msgEx.sendError(fmt.Errorf("%s: %w", method, err))
+		return
+	}
+	msg.ReplyMarkup = kb
+	_, _ = msgEx.send(msg)
 }
 
 func handleCommandTariffs(mc *MainController, msgEx *MessageManager, req *Request) {
@@ -135,6 +162,107 @@ func handleCommandProfile(mc *MainController, msgEx *MessageManager, req *Reques
 	_, _ = msgEx.send(msg)
 }
 
+// Generates an image from the command arguments, without them the next message is used as a prompt
+func handleCommandImage(mc *MainController, msgEx *MessageManager, req *Request) {
+	prompt := strings.TrimSpace(req.Update.Message.CommandArguments())
+	if prompt == "" {
+		req.UserShell.AwaitingImagePrompt = true
+		_, _ = msgEx.send(newTgMessage(req.UserShell.ID, localeText(req.UserShell.Locale, localization.MTypeMsgImagePromptRequest)))
+		return
+	}
+
+	mc.generateImage(req, msgEx, prompt)
+}
+
+// Shows the personas, with arguments adds a persona of the user: the title on the first line
+// and the prompt on the next lines
+func handleCommandPersona(mc *MainController, msgEx *MessageManager, req *Request) {
+	method := "handleCommandPersona()"
+	us := req.UserShell
+	args := req.Update.Message.CommandArguments()
+	if strings.TrimSpace(args) == "" {
+		text, kb, err := preparePersonas(mc, req)
+		if err != nil {
+			msgEx.sendError(fmt.Errorf("%s: %w", method, err))
+			return
+		}
+		msg := newTgMessage(us.ID, text)
+		msg.ReplyMarkup = kb
+		_, _ = msgEx.send(msg)
+		return
+	}
+
+	// The title is the first line of the arguments, even an empty one
+	title, prompt, _ := strings.Cut(args, "\n")
+	title, prompt = strings.TrimSpace(title), strings.TrimSpace(prompt)
+	if title == "" {
+		_, _ = msgEx.send(newTgMessage(us.ID, localeText(us.Locale, localization.MTypeMsgPersonaNoTitle)))
+		return
+	}
+	if prompt == "" {
+		_, _ = msgEx.send(newTgMessage(us.ID, localeText(us.Locale, localization.MTypeMsgPersonaNoPrompt)))
+		return
+	}
+	if utf8.RuneCountInString(title) > personaTitleMaxLength || utf8.RuneCountInString(prompt) > personaPromptMaxLength {
+		_, _ = msgEx.send(newTgMessage(us.ID,
+			localeText(us.Locale, localization.MTypeMsgPersonaTooLong, personaTitleMaxLength, personaPromptMaxLength)))
+		return
+	}
+
+	personas, err := mc.store.Personas(req.Ctx, us.ID)
+	if err != nil {
+		msgEx.sendError(fmt.Errorf("%s: %w", method, err))
+		return
+	}
+	count := 0
+	for _, persona := range personas {
+		if persona.UserID == us.ID {
+			count++
+		}
+	}
+	if count >= personaMaxCount {
+		_, _ = msgEx.send(newTgMessage(us.ID, localeText(us.Locale, localization.MTypeMsgPersonaLimit, personaMaxCount)))
+		return
+	}
+
+	persona, err := mc.store.AddPersona(req.Ctx, &store.Persona{
+		UserID:  us.ID,
+		Title:   title,
+		Prompt:  prompt,
+		Created: time.Now().UTC(),
+	})
+	if err != nil {
+		msgEx.sendError(fmt.Errorf("%s: %w", method, err))
+		return
+	}
+	if err = mc.store.SetPersona(req.Ctx, us, persona.ID); err != nil {
+		msgEx.sendError(fmt.Errorf("%s: %w", method, err))
+		return
+	}
+
+	text, kb, err := preparePersonas(mc, req)
+	if err != nil {
+		msgEx.sendError(fmt.Errorf("%s: %w", method, err))
+		return
+	}
+	msg := newTgMessage(us.ID, localeText(us.Locale, localization.MTypeMsgPersonaAdded, persona.Title)+"\n\n"+text)
+	msg.ReplyMarkup = kb
+	_, _ = msgEx.send(msg)
+}
+
+// Shows the generation settings of the current dialog, or of all dialogs if there is no active one
+func handleCommandSettings(mc *MainController, msgEx *MessageManager, req *Request) {
+	text, kb, err := prepareSettings(mc, req.UserShell, settingsScopeDialog)
+	if err != nil {
+		msgEx.sendError(fmt.Errorf("handleCommandSettings(): %w", err))
+		return
+	}
+
+	msg := newTgMessage(req.UserShell.ID, text)
+	msg.ReplyMarkup = kb
+	_, _ = msgEx.send(msg)
+}
+
 func handleCommandSetMaintenance(mc *MainController, msgEx *MessageManager, req *Request) {
 	err := mc.store.SetMaintenance(req.Ctx, !mc.store.MaintenanceStatus())
 	if err != nil {
@@ -193,3 +321,10 @@ func handleCommandUnblockUser(mc *MainController, msgEx *MessageManager, req *Re
 	msg := newTgMessage(req.UserShell.ID, fmt.Sprintf("User %d unblocked", userID))
 	_, _ = msgEx.send(msg)
 }
+
+func handleCommandStats(mc *MainController, msgEx *MessageManager, req *Request) {
+	stats := mc.dispatcher.Stats()
+	text := fmt.Sprintf("Workers: %d\nRunning: %d\nQueued: %d (users: %d)\nHandled: %d\nDropped: %d\nMax wait: %s",
+		stats.Workers, stats.Running, stats.Queued, stats.Users, stats.Handled, stats.Dropped, stats.MaxWait.Round(time.Millisecond))
+	_, _ = msgEx.send(newTgMessage(req.UserShell.ID, text))
+}
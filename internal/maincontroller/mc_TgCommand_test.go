@@ -0,0 +1,63 @@
+package maincontroller
+
+import (
+	"context"
+	"testing"
+
+	"tgbot/internal/localization"
+	"tgbot/internal/store"
+)
+
+// Deleting a persona leaves every dialog with it without a persona, not only the active one
+func TestDeletePersonaResetsDialogs(t *testing.T) {
+	mc, tg := newTestController(t, nil)
+	const userID = 42
+	ctx := context.Background()
+
+	mc.handleTgUpdate(newCommandUpdate(1, userID, "/persona \nBe a pirate"))
+	if texts := tg.lastTexts(); len(texts) == 0 || texts[len(texts)-1] != localeText("en", localization.MTypeMsgPersonaNoTitle) {
+		t.Fatalf("persona without a title got %q", texts)
+	}
+	mc.handleTgUpdate(newCommandUpdate(2, userID, "/persona Pirate\nBe a pirate"))
+
+	us, err := mc.store.GetUserShellByID(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	personas, err := mc.store.Personas(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var personaID int64
+	for _, p := range personas {
+		if p.UserID == userID {
+			personaID = p.ID
+		}
+	}
+	if personaID == 0 {
+		t.Fatalf("persona is not added: %+v", personas)
+	}
+
+	for _, title := range []string{"first", "second"} {
+		if _, err := mc.store.AddDialog(ctx, us, &store.Dialog{UserID: userID, Title: title, PersonaID: personaID}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mc.store.DeletePersona(ctx, us, personaID); err != nil {
+		t.Fatal(err)
+	}
+
+	uid := int64(userID)
+	_, dialogs, err := mc.store.UserDialogs(ctx, &store.DialogFilter{UserID: &uid})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dialogs) != 2 {
+		t.Fatalf("%d dialogs, want 2", len(dialogs))
+	}
+	for _, d := range dialogs {
+		if d.PersonaID != 0 {
+			t.Errorf("dialog %q keeps the deleted persona %d", d.Title, d.PersonaID)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package maincontroller
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"tgbot/internal/lib/extract"
+	"tgbot/internal/lib/logger/sl"
+	"tgbot/internal/localization"
+	"tgbot/internal/store"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// DocumentMaxLength is the maximum length of the document text added to the dialog, in runes
+const DocumentMaxLength = 30000
+
+// The document text is put between these lines in the dialog message
+const (
+	documentStart = "[File: %s]\n"
+	documentEnd   = "\n[End of file]"
+)
+
+// readDocument downloads the document and extracts its text formatted for the chat model.
+// The attachment is returned without the chat message id. False is returned if the
+// document can't be added to the dialog, the user is notified in this case.
+func (mc *MainController) readDocument(req *Request, msgEx *MessageManager, doc *tgbotapi.Document) (*store.Attachment, string, bool) {
+	method := "readDocument()"
+	us := req.UserShell
+
+	if !extract.Supported(doc.MimeType, doc.FileName) {
+		_, _ = msgEx.send(newTgMessage(us.ID, localeText(us.Locale, localization.MTypeMsgDocumentUnsupported,
+			strings.Join(extract.Extensions(), ", "))))
+		return nil, "", false
+	}
+	if doc.FileSize > TgFileMaxSize {
+		_, _ = msgEx.send(newTgMessage(us.ID, localeText(us.Locale, localization.MTypeMsgFileTooBig, TgFileMaxSize>>20)))
+		return nil, "", false
+	}
+
+	_, _ = msgEx.send(tgbotapi.NewChatAction(us.ID, tgbotapi.ChatTyping))
+
+	data, err := mc.downloadTgFile(req.AICtx, doc.FileID)
+	if errors.Is(err, errTgFileTooBig) {
+		_, _ = msgEx.send(newTgMessage(us.ID, localeText(us.Locale, localization.MTypeMsgFileTooBig, TgFileMaxSize>>20)))
+		return nil, "", false
+	}
+	if err != nil {
+		msgEx.sendError(fmt.Errorf("%s: %w", method, err))
+		return nil, "", false
+	}
+
+	text, err := extract.Extract(doc.MimeType, doc.FileName, data)
+	if err != nil {
+		mc.log.Info("Could not extract document text", slog.String("File name", doc.FileName), sl.Err(err))
+		_, _ = msgEx.send(newTgMessage(us.ID, localeText(us.Locale, localization.MTypeMsgDocumentNoText, doc.FileName)))
+		return nil, "", false
+	}
+
+	attachment := &store.Attachment{
+		FileID:   doc.FileID,
+		FileName: doc.FileName,
+		MIMEType: doc.MimeType,
+		Size:     int64(len(data)),
+		Created:  time.Now().UTC(),
+	}
+	if runes := []rune(text); len(runes) > DocumentMaxLength {
+		text = string(runes[:DocumentMaxLength])
+		attachment.Truncated = true
+		_, _ = msgEx.send(newTgMessage(us.ID, localeText(us.Locale, localization.MTypeMsgDocumentTruncated,
+			doc.FileName, DocumentMaxLength)))
+	}
+
+	return attachment, fmt.Sprintf(documentStart, doc.FileName) + strings.TrimSpace(text) + documentEnd, true
+}
+
+// collapseDocuments replaces the text of the documents in the message with their file names,
+// the text is for the chat model only
+func collapseDocuments(content string) string {
+	startPrefix, _, _ := strings.Cut(documentStart, "%s")
+	var sb strings.Builder
+	for {
+		start := strings.Index(content, startPrefix)
+		if start < 0 {
+			break
+		}
+		nameEnd := strings.Index(content[start:], "]\n")
+		end := strings.Index(content[start:], documentEnd)
+		if nameEnd < 0 || end < nameEnd {
+			break
+		}
+		sb.WriteString(content[:start+nameEnd+1])
+		content = content[start+end+len(documentEnd):]
+	}
+	sb.WriteString(content)
+	return sb.String()
+}
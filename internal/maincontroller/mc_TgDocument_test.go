@@ -0,0 +1,87 @@
+package maincontroller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"tgbot/internal/config"
+	"tgbot/internal/store"
+	"tgbot/internal/store/db"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestCollapseDocuments(t *testing.T) {
+	doc := func(name, text string) string {
+		return fmt.Sprintf(documentStart, name) + text + documentEnd
+	}
+	tests := []struct {
+		content string
+		want    string
+	}{
+		{content: "no documents", want: "no documents"},
+		{content: doc("a.txt", "long text") + "\n\nWhat is it?", want: "[File: a.txt]\n\nWhat is it?"},
+		{content: doc("a.txt", "one") + "\n" + doc("b.md", "[File: x]\ntwo"), want: "[File: a.txt]\n[File: b.md]"},
+		// Not closed, left as is
+		{content: "[File: a.txt]\nbroken", want: "[File: a.txt]\nbroken"},
+	}
+	for _, tt := range tests {
+		if got := collapseDocuments(tt.content); got != tt.want {
+			t.Errorf("collapseDocuments(%q) = %q, want %q", tt.content, got, tt.want)
+		}
+	}
+}
+
+// A document sent after a pause waits for the new dialog answer with its attachment and is saved
+// with it once the user answers
+func TestDocumentAfterNewDialogPrompt(t *testing.T) {
+	var storagePath string
+	mc, tg := newTestController(t, func(cfg *config.Config) {
+		storagePath = cfg.StoragePath
+	})
+	const userID = 42
+	tg.files["doc1"] = "The secret number is 42."
+
+	mc.handleTgUpdate(newTextUpdate(1, userID, "Hello there"))
+	us, err := mc.store.GetUserShellByID(context.Background(), userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	us.Usage.Range(func(_, value any) bool {
+		value.(*store.UserUsage).LastActivity = time.Now().Add(-2 * store.TgCheckNewDialogTimeout)
+		return true
+	})
+
+	update := newTextUpdate(2, userID, "")
+	update.Message.Caption = "What is the number?"
+	update.Message.Document = &tgbotapi.Document{FileID: "doc1", FileName: "notes.txt", MimeType: "text/plain", FileSize: 24}
+	mc.handleTgUpdate(update)
+	if us.LastAttachment == nil || us.LastAttachment.FileName != "notes.txt" {
+		t.Fatalf("pending attachment = %+v", us.LastAttachment)
+	}
+
+	mc.handleTgUpdate(newCallbackUpdate(3, userID, fmt.Sprint(callbackTypeHandleLastMessage, ";", userID, ";", 1)))
+	if us.LastText != "" || us.LastAttachment != nil {
+		t.Errorf("pending message is kept: %q, %+v", us.LastText, us.LastAttachment)
+	}
+	if len(us.Context) != 2 || !strings.Contains(us.Context[0].Content, "[File: notes.txt]") ||
+		!strings.Contains(us.Context[1].Content, "What is the number?") {
+		t.Fatalf("new dialog = %+v", us.Context)
+	}
+
+	driver, err := db.NewDBDriver(&config.Config{DbDriver: "sqlite", StoragePath: storagePath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = driver.Close() }()
+	attachments, err := driver.AttachmentList(context.Background(), &store.AttachmentFilter{ChatMessageID: &us.Context[0].ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attachments) != 1 || attachments[0].FileID != "doc1" {
+		t.Errorf("attachments = %+v, want the document", attachments)
+	}
+}
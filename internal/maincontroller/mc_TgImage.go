@@ -0,0 +1,125 @@
+package maincontroller
+
+import (
+	"errors"
+	"fmt"
+	"tgbot/internal/ai"
+	"tgbot/internal/localization"
+	"tgbot/internal/store"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// generateImage generates an image with the user's image model, sends it as a photo
+// and records the prompt and the result in the active dialog
+func (mc *MainController) generateImage(req *Request, msgEx *MessageManager, prompt string) {
+	method := "generateImage()"
+	us := req.UserShell
+
+	if !mc.moderateInput(req, msgEx, prompt) {
+		return
+	}
+
+	if _, hasRequest := mc.hasActiveUserRequest(us.ID); hasRequest {
+		msg := newTgMessage(us.ID, localeText(us.Locale, localization.MTypeMsgWaitPreviousRequest))
+		msg.ReplyMarkup = kbWithOneButton(
+			"",
+			localeText(us.Locale, localization.MTypeBtnCancelPreviousRequest),
+			fmt.Sprint(callbackTypeCancelRequest))
+		_, _ = msgEx.send(msg)
+		return
+	}
+
+	imageModel, aiModel, err := mc.imageModel(us.User.ImageModelID)
+	if err != nil {
+		msgEx.sendError(fmt.Errorf("%s: %w", method, err))
+		return
+	}
+
+	checkLimit, err := checkUserUsage(mc, msgEx, us, aiModel.ID)
+	if err != nil {
+		msgEx.sendError(fmt.Errorf("%s: %w", method, err))
+		return
+	}
+	if !checkLimit {
+		return
+	}
+
+	mc.addRequestToPool(us.ID, req)
+	defer mc.requestPool.Delete(us.ID)
+
+	statusMsg := newTgMessage(us.ID, localeText(us.Locale, localization.MTypeMsgImageGenerating))
+	statusMsg.ReplyMarkup = kbWithOneButton(
+		"❌",
+		localeText(us.Locale, localization.MTypeBtnCancelRequest),
+		fmt.Sprint(callbackTypeCancelRequest))
+	sentStatus, err := msgEx.send(statusMsg)
+	if err != nil {
+		msgEx.sendError(fmt.Errorf("%s: %w", method, err))
+		return
+	}
+	_, _ = msgEx.send(tgbotapi.NewChatAction(us.ID, tgbotapi.ChatUploadPhoto))
+
+	image, err := imageModel.GenerateImage(req.AICtx, ai.ImageRequest{
+		Model:  aiModel.APIName,
+		Prompt: prompt,
+		User:   fmt.Sprint(us.ID),
+	})
+	_, _ = msgEx.send(tgbotapi.NewDeleteMessage(us.ID, sentStatus.MessageID))
+	if errors.Is(err, ai.ErrCanceled) {
+		_, _ = msgEx.send(newTgMessage(us.ID, localeText(us.Locale, req.canceledMessageType())))
+		return
+	}
+	if err != nil {
+		msgEx.sendError(fmt.Errorf("%s: %w", method, err))
+		return
+	}
+
+	// The image is paid for once it is generated
+	err = mc.store.UpdateUserUsage(req.Ctx, us, aiModel.ID, 0)
+	if err != nil {
+		msgEx.sendError(fmt.Errorf("%s: %w", method, err))
+		return
+	}
+
+	var file tgbotapi.RequestFileData = tgbotapi.FileURL(image.URL)
+	if len(image.Data) > 0 {
+		file = tgbotapi.FileBytes{Name: "image.png", Bytes: image.Data}
+	}
+	caption := prompt
+	if image.RevisedPrompt != "" {
+		caption = image.RevisedPrompt
+	}
+
+	sentMsg, err := msgEx.send(newTgPhoto(us.ID, file, caption))
+	if err != nil {
+		msgEx.sendError(fmt.Errorf("%s: %w", method, err))
+		return
+	}
+
+	if us.Dialog == nil {
+		_, err := mc.store.AddDialog(req.Ctx, us, &store.Dialog{Title: dialogTitle(prompt), UserID: us.ID, Created: time.Now().UTC()})
+		if err != nil {
+			msgEx.sendError(fmt.Errorf("%s: %w", method, err))
+			return
+		}
+	}
+
+	_, err = mc.store.AddNewMessage(req.Ctx, us, newChatMessage(us.Dialog.ID, len(us.Context), store.RoleUser, prompt))
+	if err != nil {
+		msgEx.sendError(fmt.Errorf("%s: %w", method, err))
+		return
+	}
+
+	imageMessage := newChatMessage(us.Dialog.ID, len(us.Context), store.RoleAssistant, "[Generated image] "+caption)
+	if len(sentMsg.Photo) > 0 {
+		// Sizes are sorted in ascending order
+		imageMessage.ImageFileID = sentMsg.Photo[len(sentMsg.Photo)-1].FileID
+	}
+	_, err = mc.store.AddNewMessage(req.Ctx, us, imageMessage)
+	if err != nil {
+		msgEx.sendError(fmt.Errorf("%s: %w", method, err))
+		return
+	}
+}
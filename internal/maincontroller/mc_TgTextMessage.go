@@ -2,10 +2,13 @@ package maincontroller
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
 	"strings"
-	"sync"
 	"tgbot/internal/ai"
+	"tgbot/internal/lib/logger/sl"
 	"tgbot/internal/localization"
 	"tgbot/internal/store"
 	"time"
@@ -14,179 +17,552 @@ import (
 )
 
 func (mc *MainController) handleTgMessage(req *Request) *MessageManager {
-	method := "handleTgMessage()"
+	msgEx := newMessageExchange()
+	go func() {
+		defer msgEx.close()
+		mc.answerMessage(req, msgEx)
+	}()
+	return msgEx
+}
+
+// answerMessage answers the message of the update, without a message it answers the pending
+// message kept while the user was asked whether to start a new dialog
+func (mc *MainController) answerMessage(req *Request, msgEx *MessageManager) {
+	method := "answerMessage()"
 	us := req.UserShell
 	text := ""
+	imageFileID := ""
+	audio := messageAudio(req.Update.Message)
+	var document *tgbotapi.Document
+	var attachment *store.Attachment
+	if req.Update.Message != nil {
+		document = req.Update.Message.Document
+	}
 	if req.Update.Message != nil {
 		text = req.Update.Message.Text
+		if photo := req.Update.Message.Photo; len(photo) > 0 {
+			// Sizes are sorted in ascending order
+			text = req.Update.Message.Caption
+			imageFileID = photo[len(photo)-1].FileID
+		}
+		if req.Update.Message.Document != nil {
+			text = req.Update.Message.Caption
+		}
 	} else {
 		text = req.UserShell.LastText
+		imageFileID = req.UserShell.LastImageFileID
+		attachment = req.UserShell.LastAttachment
 	}
 
-	msgEx := newMessageExchange()
+	_, hasRequest := mc.hasActiveUserRequest(us.ID)
+	if hasRequest {
+		msg := newTgMessage(us.ID, localeText(us.Locale, localization.MTypeAnswerCreateNewDialog))
+		msg.ReplyMarkup = kbWithOneButton(
+			"",
+			localeText(us.Locale, localization.MTypeBtnCancelPreviousRequest),
+			fmt.Sprint(callbackTypeCancelRequest))
+		_, _ = msgEx.send(msg)
+		return
+	}
 
-	go func() {
-		defer msgEx.close()
-		_, hasRequest := mc.hasActiveUserRequest(us.ID)
-		if hasRequest {
-			msg := newTgMessage(us.ID, localeText(us.Locale, localization.MTypeAnswerCreateNewDialog))
-			msg.ReplyMarkup = kbWithOneButton(
-				"",
-				localeText(us.Locale, localization.MTypeBtnCancelPreviousRequest),
-				fmt.Sprint(callbackTypeCancelRequest))
-			_, _ = msgEx.send(msg)
+	if audio != nil {
+		transcript, ok := mc.transcribeAudio(req, msgEx, audio)
+		if !ok {
 			return
 		}
+		text = transcript
+	}
 
-		checkLimit, err := mc.store.CheckUserUsage(us, us.User.ChatModelID)
-		if err != nil {
-			msgEx.sendError(err)
-			return
-		}
-		if !checkLimit {
-			msg := newTgMessage(us.ID, localeText(us.Locale, localization.MTypeMsgLimitReached))
-			_, _ = msgEx.send(msg)
+	if text == "" && imageFileID == "" && document == nil {
+		_, _ = msgEx.send(newTgMessage(us.ID, localeText(us.Locale, localization.MTypeMsgUnsupportedMessage)))
+		return
+	}
+
+	if us.AwaitingImagePrompt && imageFileID == "" && document == nil {
+		us.AwaitingImagePrompt = false
+		mc.generateImage(req, msgEx, text)
+		return
+	}
+
+	checkLimit, err := checkUserUsage(mc, msgEx, us, us.User.ChatModelID)
+	if err != nil {
+		msgEx.sendError(err)
+		return
+	}
+	if !checkLimit {
+		return
+	}
+
+	chatModel, aiModel, err := mc.chatModel(us.User.ChatModelID)
+	if err != nil {
+		msgEx.sendError(fmt.Errorf("%s: %w", method, err))
+		return
+	}
+	if imageFileID != "" && !aiModel.Supports(store.CapVision) {
+		_, _ = msgEx.send(newTgMessage(us.ID, localeText(us.Locale, localization.MTypeMsgModelNoVision)))
+		return
+	}
+
+	if document != nil {
+		att, content, ok := mc.readDocument(req, msgEx, document)
+		if !ok {
 			return
 		}
+		attachment = att
+		text = strings.TrimSpace(content + "\n\n" + text)
+	}
+
+	if !mc.moderateInput(req, msgEx, text) {
+		return
+	}
 
-		checkNewDialog, err := CheckLastMessageTime(mc, us, text, msgEx)
+	checkNewDialog, err := CheckLastMessageTime(mc, us, text, imageFileID, attachment, msgEx)
+	if err != nil {
+		msgEx.sendError(err)
+		return
+	}
+	if checkNewDialog {
+		return
+	}
+
+	if req.UserShell.Dialog == nil {
+		title := dialogTitle(text)
+		if title == "" {
+			title = imagePlaceholder
+		}
+		_, err := mc.store.AddDialog(req.Ctx, req.UserShell, &store.Dialog{Title: title, UserID: us.ID, Created: time.Now().UTC()})
 		if err != nil {
-			msgEx.sendError(err)
+			msgEx.sendError(fmt.Errorf("%s: %w", method, err))
 			return
 		}
-		if checkNewDialog {
+	}
+
+	us.LastText = ""
+	us.LastImageFileID = ""
+	us.LastAttachment = nil
+
+	mc.addRequestToPool(us.ID, req)
+	defer mc.requestPool.Delete(us.ID)
+
+	chatMessage := newChatMessage(us.Dialog.ID, len(us.Context), store.RoleUser, text)
+	chatMessage.ImageFileID = imageFileID
+	_, err = mc.store.AddNewMessage(req.Ctx, us, chatMessage)
+	if err != nil {
+		msgEx.sendError(fmt.Errorf("%s: %w", method, err))
+		return
+	}
+	if attachment != nil {
+		attachment.ChatMessageID = chatMessage.ID
+		_, err = mc.store.AddAttachment(req.Ctx, attachment)
+		if err != nil {
+			msgEx.sendError(fmt.Errorf("%s: %w", method, err))
 			return
 		}
+	}
 
-		if req.UserShell.Dialog == nil {
-			_, err := mc.store.AddDialog(req.Ctx, req.UserShell, &store.Dialog{Title: dialogTitle(text), UserID: us.ID, Created: time.Now().UTC()})
-			if err != nil {
-				msgEx.sendError(fmt.Errorf("%s: %w", method, err))
-				return
-			}
-		}
+	dialogContext, summaryTokens := mc.buildDialogContext(req, chatModel, aiModel)
+	var images map[string]*ai.ImageContent
+	if aiModel.Supports(store.CapVision) {
+		images = mc.loadDialogImages(req.AICtx, dialogContext)
+	}
 
-		us.LastText = ""
-		contextLen := len(us.Context)
+	messages := dialogContext.AIMessages(images, aiModel.Supports(store.CapTools))
+	result, err := mc.chatAnswer(req, msgEx, chatModel, aiModel, messages)
+	if errors.Is(err, ai.ErrCanceled) {
+		_, _ = msgEx.send(newTgMessage(us.ID, localeText(us.Locale, req.canceledMessageType())))
+		return
+	}
+	if err != nil {
+		msgEx.sendError(fmt.Errorf("%s: %w", method, err))
+		return
+	}
+
+	if result.Status == ai.StreamFinished && !mc.moderateAnswer(req, msgEx, result.Answer, result.MessageIDs) {
+		// The flagged answer is left out of the dialog, but its tokens are spent
+		result.Answer = ""
+	}
 
-		mc.addRequestToPool(us.ID, req)
-		defer mc.requestPool.Delete(us.ID)
+	aiChatMessage := newChatMessage(us.Dialog.ID, len(us.Context), store.RoleAssistant, result.Answer)
+	aiChatMessage.AIModelID = result.AIModelID
+	setMessageUsage(aiChatMessage, result.Usage)
+	_, err = mc.store.AddNewMessage(req.Ctx, us, aiChatMessage)
+	if err != nil {
+		msgEx.sendError(fmt.Errorf("%s: %w", method, err))
+		return
+	}
 
-		chatMessage := newChatMessage(us.Dialog.ID, contextLen, store.RoleUser, text)
-		_, err = mc.store.AddNewMessage(req.Ctx, us, chatMessage)
+	err = mc.store.UpdateUserUsage(req.Ctx, us, result.AIModelID, int64(result.TotalTokens))
+	if err != nil {
+		msgEx.sendError(fmt.Errorf("%s: %w", method, err))
+		return
+	}
+	if summaryTokens > 0 {
+		// The summary is made by the requested model, the answer may come from a fallback
+		err = mc.store.AddUserUsageTokens(req.Ctx, us, aiModel.ID, int64(summaryTokens))
 		if err != nil {
 			msgEx.sendError(fmt.Errorf("%s: %w", method, err))
 			return
 		}
+	}
 
-		var messagesToAi []ai.Message
-		for _, v := range us.Context {
-			role := ""
-			switch v.Role {
-			case store.RoleAssistant:
-				role = "assistant"
-			case store.RoleSystem:
-				role = "system"
-			case store.RoleUser:
-				role = "user"
-			}
-			message := ai.Message{Role: role, Content: v.Content}
-			messagesToAi = append(messagesToAi, message)
+	if us.User.SendVoiceAnswer && result.Status == ai.StreamFinished && result.Answer != "" {
+		mc.sendVoiceAnswer(req, msgEx, result.Answer)
+	}
+}
+
+type chatResult struct {
+	Answer string
+	Status ai.StreamStatus
+	// Model that produced the answer, differs from the requested one if the answer came from a fallback
+	AIModelID int32
+	// Usage of the request that produced the answer
+	Usage ai.Usage
+	// Telegram messages showing the answer
+	MessageIDs []int
+	// Tokens of all requests, including the ones that called tools
+	TotalTokens int
+}
+
+// chatTarget is the model the requests of an answer are sent to
+type chatTarget struct {
+	chatModel ai.ChatModel
+	model     *store.AiModel
+	// Fallbacks of the requested model that haven't been tried yet
+	fallbacks []int32
+}
+
+// chatAnswer streams the answer of the chat model to the user. Tools called by the model are run
+// and their results are sent back until the model answers with text. Tool calls and results
+// are saved to the dialog, the answer itself is not.
+func (mc *MainController) chatAnswer(req *Request, msgEx *MessageManager, chatModel ai.ChatModel, aiModel *store.AiModel, messages []ai.Message) (*chatResult, error) {
+	method := "chatAnswer()"
+	res := &chatResult{}
+	target := &chatTarget{chatModel: chatModel, model: aiModel, fallbacks: aiModel.Fallbacks}
+
+	for iteration := 0; ; iteration++ {
+		stream, answer, messageIDs, err := mc.streamChat(req, msgEx, target, messages, iteration < MaxToolIterations)
+		if err != nil {
+			return res, fmt.Errorf("%s: %w", method, err)
+		}
+
+		usage := stream.Usage()
+		res.TotalTokens += usage.TotalTokens
+		res.AIModelID = target.model.ID
+		calls := stream.ToolCalls()
+		if len(calls) == 0 || !target.model.Supports(store.CapTools) || iteration >= MaxToolIterations ||
+			stream.Status() != ai.StreamFinished {
+			res.Answer = answer
+			res.MessageIDs = messageIDs
+			res.Status = stream.Status()
+			res.Usage = usage
+			return res, nil
 		}
 
-		aiRequest := ai.ChatRequest{
-			Model:    "gpt-4o-mini",
+		messages, err = mc.runToolCalls(req, msgEx, messages, answer, calls, usage, target.model.ID)
+		if err != nil {
+			return res, fmt.Errorf("%s: %w", method, err)
+		}
+	}
+}
+
+// streamChat sends the messages to the target model and streams the answer to the user.
+// If the provider fails before any text is shown, the request is repeated with the next
+// usable fallback, which becomes the target for the rest of the answer.
+func (mc *MainController) streamChat(req *Request, msgEx *MessageManager, target *chatTarget, messages []ai.Message, tools bool) (*ai.Stream, string, []int, error) {
+	us := req.UserShell
+	for {
+		request := ai.ChatRequest{
+			Model:    target.model.APIName,
 			Stream:   true,
-			Messages: messagesToAi,
+			Messages: messages,
 			User:     fmt.Sprint(us.ID),
 		}
+		if tools && target.model.Supports(store.CapTools) {
+			request.Tools = mc.tools.Definitions()
+		}
+		applyParams(&request, us, target.model)
 
-		answer, err := mc.aiAPI.GetStreamMessages(aiRequest)
-		if err != nil {
-			msgEx.sendError(fmt.Errorf("%s: %w", method, err))
-			return
+		stream, err := target.chatModel.GetStreamMessages(req.AICtx, request)
+		var answer string
+		var messageIDs []int
+		if err == nil {
+			answer, messageIDs, err = streamAnswer(req, msgEx, stream, target.model.Supports(store.CapReasoning))
+		}
+		if err == nil || !ai.Failover(err) {
+			return stream, answer, messageIDs, err
 		}
 
-		sentMsg, err := msgEx.send(newTgMessage(us.ID, "..."))
-		if err != nil {
-			msgEx.sendError(err)
-			return
+		failed := target.model
+		if !mc.nextChatFallback(us, target, requiredCapabilities(messages)) {
+			return nil, "", nil, err
 		}
+		mc.log.Warn("Chat model failed, trying fallback",
+			slog.String("Model", failed.Title), slog.String("Fallback", target.model.Title), sl.Err(err))
+	}
+}
 
-		var wg sync.WaitGroup
-		wg.Add(1)
-		var sb strings.Builder
-		var totalSb strings.Builder
-		requestCanceled := false
-
-		go func() {
-			defer wg.Done()
-			ticker := time.NewTicker(TgSendingMessageFrequency)
-			defer ticker.Stop()
-			for {
-				if requestCanceled || req.AICtx.Err() != nil {
-					requestCanceled = true
-					return
-				}
+// nextChatFallback switches the target to its next fallback that is served by a configured provider,
+// fits the user's tariff and has the capabilities the messages need. False is returned if there is none.
+func (mc *MainController) nextChatFallback(us *store.UserShell, target *chatTarget, required store.ModelCapability) bool {
+	for len(target.fallbacks) > 0 {
+		id := target.fallbacks[0]
+		target.fallbacks = target.fallbacks[1:]
 
-				select {
-				case <-req.AICtx.Done():
-					requestCanceled = true
-					return
-				case <-ticker.C:
-					if sb.Len() > TgMessageMaxLength {
-						sentMsg, _ = msgEx.send(newTgEditMessage(us.ID, sentMsg.MessageID, sb.String()+"..."))
-						totalSb.WriteString(sb.String())
-						sb.Reset()
-						sb.WriteString("...")
-						sentMsg, _ = msgEx.send(newTgMessage(us.ID, "..."))
-						continue
-					}
-					msgToSend := newTgEditMessage(us.ID, sentMsg.MessageID, sb.String()+"...")
-					msgToSend.ReplyMarkup = kbWithOneButton(
-						"❌",
-						localeText(us.Locale, localization.MTypeBtnCancelRequest),
-						fmt.Sprint(callbackTypeCancelRequest))
-					sentMsg, _ = msgEx.send(newTgMessage(us.ID, "..."))
-				default:
-					txt, ok := <-answer
-					sb.WriteString(txt)
-					if !ok {
-						return
-					}
-				}
-			}
-		}()
+		chatModel, model, err := mc.chatModel(id)
+		if err != nil || !model.Supports(required) {
+			continue
+		}
+		if ok, err := mc.store.CheckUserUsage(us, id); err != nil || !ok {
+			continue
+		}
+		target.chatModel = chatModel
+		target.model = model
+		return true
+	}
+	return false
+}
+
+// requiredCapabilities returns the capabilities a model needs to process the messages
+func requiredCapabilities(messages []ai.Message) store.ModelCapability {
+	var res store.ModelCapability
+	for _, msg := range messages {
+		if msg.HasImages() {
+			res |= store.CapVision
+		}
+		if len(msg.ToolCalls) > 0 || msg.Role == ai.RoleTool {
+			res |= store.CapTools
+		}
+	}
+	return res
+}
 
-		wg.Wait()
+// runToolCalls saves the tool calls, runs them and saves the results.
+// The returned messages are extended with the calls and the results.
+func (mc *MainController) runToolCalls(req *Request, msgEx *MessageManager, messages []ai.Message, text string, calls []ai.ToolCall, usage ai.Usage, modelID int32) ([]ai.Message, error) {
+	us := req.UserShell
 
-		if requestCanceled {
-			sb.WriteString("\n\n----------\n" + localeText(us.Locale, localization.MTypeMsgRequestCanceledByUser))
+	// Text sent along with the calls is already shown to the user
+	if text != "" {
+		textMessage := newChatMessage(us.Dialog.ID, len(us.Context), store.RoleAssistant, text)
+		textMessage.AIModelID = modelID
+		if _, err := mc.store.AddNewMessage(req.Ctx, us, textMessage); err != nil {
+			return nil, err
 		}
+	}
 
-		msgToSend := newTgEditMessage(us.ID, sentMsg.MessageID, sb.String())
-		sentMsg, _ = msgEx.send(msgToSend)
+	bCalls, err := json.Marshal(calls)
+	if err != nil {
+		return nil, err
+	}
+	callMessage := newChatMessage(us.Dialog.ID, len(us.Context), store.RoleToolCall, string(bCalls))
+	callMessage.AIModelID = modelID
+	setMessageUsage(callMessage, usage)
+	if _, err = mc.store.AddNewMessage(req.Ctx, us, callMessage); err != nil {
+		return nil, err
+	}
 
-		totalSb.WriteString(sb.String())
-		aiChatMessage := &store.ChatMessage{DialogID: us.Dialog.ID, Order: contextLen + 1, Role: store.RoleAssistant, Content: totalSb.String(), Created: time.Now().UTC()}
-		_, err = mc.store.AddNewMessage(req.Ctx, us, aiChatMessage)
-		if err != nil {
-			msgEx.sendError(fmt.Errorf("%s: %w", method, err))
-			return
+	callAIMessage := ai.Message{Role: ai.RoleAssistant, ToolCalls: calls}
+	if text != "" {
+		callAIMessage.Content = []ai.ContentPart{{Type: ai.PartText, Text: text}}
+	}
+	messages = append(messages, callAIMessage)
+
+	for _, call := range calls {
+		_, _ = msgEx.send(tgbotapi.NewChatAction(us.ID, tgbotapi.ChatTyping))
+		result := mc.tools.Call(req.AICtx, us, call)
+
+		resultMessage := newChatMessage(us.Dialog.ID, len(us.Context), store.RoleToolResult, result)
+		resultMessage.ToolCallID = call.ID
+		if _, err = mc.store.AddNewMessage(req.Ctx, us, resultMessage); err != nil {
+			return nil, err
 		}
+		messages = append(messages, ai.ToolResultMessage(call.ID, result))
+	}
+	return messages, nil
+}
 
-		err = mc.store.UpdateUserUsage(req.Ctx, us, us.User.ChatModelID)
-		if err != nil {
-			msgEx.sendError(fmt.Errorf("%s: %w", method, err))
+func setMessageUsage(msg *store.ChatMessage, usage ai.Usage) {
+	msg.PromptTokens = usage.PromptTokens
+	msg.CompletionTokens = usage.CompletionTokens
+	msg.CachedTokens = usage.CachedTokens
+	msg.ReasoningTokens = usage.ReasoningTokens
+}
+
+// buildDialogContext selects the dialog messages that fit into the context budget. Older messages
+// are folded into a summary which is saved to the dialog, its token usage is returned.
+// If summarization fails the older messages are just left out.
+func (mc *MainController) buildDialogContext(req *Request, chatModel ai.ChatModel, aiModel *store.AiModel) (*DialogContext, int) {
+	us := req.UserShell
+	cb := NewContextBuilder(ai.TokenizerFor(aiModel.APIName), mc.contextBudget)
+	dc := cb.Build(mc.dialogPersona(req), us.Context)
+	if len(dc.Overflow) == 0 {
+		return dc, 0
+	}
+
+	summary, err := cb.Summarize(req.AICtx, chatModel, aiModel.APIName, dc)
+	if err != nil {
+		mc.log.Error("Could not summarize dialog", slog.Int64("Dialog id", us.Dialog.ID), sl.Err(err))
+		dc.Overflow = nil
+		return dc, 0
+	}
+
+	summary.DialogID = us.Dialog.ID
+	summary.AIModelID = aiModel.ID
+	summary.Order = len(us.Context)
+	if _, err = mc.store.AddNewMessage(req.Ctx, us, summary); err != nil {
+		mc.log.Error("Could not save dialog summary", slog.Int64("Dialog id", us.Dialog.ID), sl.Err(err))
+	}
+
+	dc.Summary = summary
+	dc.Overflow = nil
+	return dc, summary.PromptTokens + summary.CompletionTokens
+}
+
+// dialogPersona returns the persona prompt of the active dialog. A persona that can't be
+// loaded is left out, the dialog goes on without it.
+func (mc *MainController) dialogPersona(req *Request) string {
+	us := req.UserShell
+	if us.Dialog == nil || us.Dialog.PersonaID == 0 {
+		return ""
+	}
+	persona, err := mc.store.PersonaByID(req.Ctx, us.ID, us.Dialog.PersonaID)
+	if err != nil {
+		mc.log.Error("Could not load dialog persona", slog.Int64("Dialog id", us.Dialog.ID), sl.Err(err))
+		return ""
+	}
+	return persona.Prompt
+}
+
+// streamAnswer shows the answer to the user while it is being generated and returns the whole text.
+// While a reasoning model thinks, the message shows the thinking time and the reasoning summary
+// if the provider streams it. The status is deleted and the answer is sent as a new message.
+// The ids of the messages with the answer are returned. An error is returned only
+// if the stream failed before any text was received.
+func streamAnswer(req *Request, msgEx *MessageManager, stream *ai.Stream, reasoningModel bool) (string, []int, error) {
+	us := req.UserShell
+	sentMsg, err := msgEx.send(newTgMessage(us.ID, "..."))
+	if err != nil {
+		return "", nil, err
+	}
+
+	ticker := time.NewTicker(TgSendingMessageFrequency)
+	defer ticker.Stop()
+
+	start := time.Now()
+	var sb strings.Builder
+	var totalSb strings.Builder
+	var reasoning strings.Builder
+	var messageIDs []int
+	statusShown := false
+	prefix := ""
+	lastSent := ""
+	// replaceStatus deletes the thinking status and sends the message for the answer
+	replaceStatus := func() {
+		if !statusShown {
 			return
 		}
-	}()
+		statusShown = false
+		lastSent = ""
+		_, _ = msgEx.send(tgbotapi.NewDeleteMessage(us.ID, sentMsg.MessageID))
+		if msg, err := msgEx.send(newTgMessage(us.ID, "...")); err == nil {
+			sentMsg = msg
+		}
+	}
+	cancelKb := kbWithOneButton(
+		"❌",
+		localeText(us.Locale, localization.MTypeBtnCancelRequest),
+		fmt.Sprint(callbackTypeCancelRequest))
 
-	return msgEx
+	chunks := stream.Chunks()
+	for chunks != nil {
+		select {
+		case chunk, ok := <-chunks:
+			if !ok {
+				chunks = nil
+				continue
+			}
+			sb.WriteString(chunk.Content)
+			reasoning.WriteString(chunk.Reasoning)
+		case <-ticker.C:
+			if totalSb.Len() == 0 && sb.Len() == 0 {
+				if reasoning.Len() == 0 && !reasoningModel {
+					continue
+				}
+				status := localeText(us.Locale, localization.MTypeMsgThinking, int(time.Since(start).Seconds()))
+				if reasoning.Len() > 0 {
+					status += "\n\n" + lastRunes(reasoning.String(), ReasoningStatusMaxLength)
+				}
+				msgToSend := newTgEditMessage(us.ID, sentMsg.MessageID, status)
+				msgToSend.ReplyMarkup = cancelKb
+				_, _ = msgEx.send(msgToSend)
+				statusShown = true
+				continue
+			}
+			replaceStatus()
+
+			if sb.Len() > TgMessageMaxLength {
+				_, _ = msgEx.send(newTgEditMessage(us.ID, sentMsg.MessageID, prefix+sb.String()+"..."))
+				totalSb.WriteString(sb.String())
+				messageIDs = append(messageIDs, sentMsg.MessageID)
+				sb.Reset()
+				prefix = "..."
+				if msg, err := msgEx.send(newTgMessage(us.ID, "...")); err == nil {
+					sentMsg = msg
+				}
+				lastSent = ""
+				continue
+			}
+			if sb.String() == lastSent {
+				continue
+			}
+			lastSent = sb.String()
+			msgToSend := newTgEditMessage(us.ID, sentMsg.MessageID, prefix+lastSent+"...")
+			msgToSend.ReplyMarkup = cancelKb
+			_, _ = msgEx.send(msgToSend)
+		}
+	}
+	totalSb.WriteString(sb.String())
+	if totalSb.Len() > 0 {
+		replaceStatus()
+	}
+
+	// The model called tools instead of answering
+	if totalSb.Len() == 0 && stream.Status() == ai.StreamFinished && len(stream.ToolCalls()) > 0 {
+		_, _ = msgEx.send(tgbotapi.NewDeleteMessage(us.ID, sentMsg.MessageID))
+		return "", nil, nil
+	}
+
+	footer := ""
+	switch stream.Status() {
+	case ai.StreamCanceled:
+		footer = "\n\n----------\n" + localeText(us.Locale, req.canceledMessageType())
+	case ai.StreamFailed:
+		if totalSb.Len() == 0 {
+			_, _ = msgEx.send(tgbotapi.NewDeleteMessage(us.ID, sentMsg.MessageID))
+			return "", nil, stream.Err()
+		}
+		footer = "\n\n----------\n" + localeText(us.Locale, errorMessageType(stream.Err()))
+	}
+
+	_, _ = msgEx.send(newTgEditMessage(us.ID, sentMsg.MessageID, prefix+sb.String()+footer))
+	return totalSb.String(), append(messageIDs, sentMsg.MessageID), nil
+}
+
+// lastRunes returns the end of the text that fits into n runes, starting with a whole word
+func lastRunes(text string, n int) string {
+	runes := []rune(text)
+	if len(runes) <= n {
+		return text
+	}
+	tail := string(runes[len(runes)-n:])
+	if i := strings.IndexAny(tail, " \n"); i >= 0 {
+		tail = tail[i+1:]
+	}
+	return "..." + tail
 }
 
-func CheckLastMessageTime(mc *MainController, us *store.UserShell, text string, msgEx *MessageManager) (bool, error) {
-	if us.LastText == "" && len(us.Context) > 0 && mc.store.CheckUserLastActivity(us) {
+func CheckLastMessageTime(mc *MainController, us *store.UserShell, text, imageFileID string, attachment *store.Attachment, msgEx *MessageManager) (bool, error) {
+	if us.LastText == "" && us.LastImageFileID == "" && us.LastAttachment == nil && len(us.Context) > 0 && mc.store.CheckUserLastActivity(us) {
 		if us.User.SkipNewDialogMessage {
 			err := mc.store.ResetActiveDialog(context.TODO(), us)
 			if err != nil {
@@ -210,6 +586,8 @@ func CheckLastMessageTime(mc *MainController, us *store.UserShell, text string,
 		sentMsg, _ := msgEx.send(msg)
 
 		us.LastText = text
+		us.LastImageFileID = imageFileID
+		us.LastAttachment = attachment
 		us.InfoMessageID = sentMsg.MessageID
 		return true, nil
 	}
@@ -0,0 +1,64 @@
+package maincontroller
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"tgbot/internal/store"
+)
+
+// The message goes through the whole handler: the user and the dialog are created, the answer
+// of the fake backend is streamed to Telegram and saved with its usage
+func TestHandleTgMessageFakeBackend(t *testing.T) {
+	mc, tg := newTestController(t, nil)
+	const userID = 42
+
+	mc.handleTgUpdate(newTextUpdate(1, userID, "Hello there"))
+	mc.handleTgUpdate(newTextUpdate(2, userID, "And again"))
+	if err := mc.outbox.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	texts := tg.lastTexts()
+	var answers []string
+	for _, text := range texts {
+		if strings.Contains(text, "This is a fake answer of o4-mini to:") {
+			answers = append(answers, text)
+		}
+	}
+	if len(answers) != 2 || !strings.Contains(answers[0], "Hello there") || !strings.Contains(answers[1], "And again") {
+		t.Fatalf("answers = %q, sent texts %q", answers, texts)
+	}
+
+	us, err := mc.store.GetUserShellByID(context.Background(), userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if us.Dialog == nil || us.Dialog.Title == "" {
+		t.Fatalf("dialog = %+v, want a dialog titled by the first message", us.Dialog)
+	}
+	var roles []store.ChatMessageRole
+	for _, msg := range us.Context {
+		roles = append(roles, msg.Role)
+	}
+	want := []store.ChatMessageRole{store.RoleUser, store.RoleAssistant, store.RoleUser, store.RoleAssistant}
+	if len(roles) != len(want) {
+		t.Fatalf("dialog roles = %v, want %v", roles, want)
+	}
+	for i := range want {
+		if roles[i] != want[i] {
+			t.Fatalf("dialog roles = %v, want %v", roles, want)
+		}
+	}
+	answer := us.Context[1]
+	if !strings.Contains(answer.Content, "Hello there") || answer.AIModelID != store.DefaultAIChatModelID ||
+		answer.PromptTokens == 0 || answer.CompletionTokens == 0 {
+		t.Errorf("saved answer = %+v", answer)
+	}
+
+	usage, ok := us.Usage.Load(store.DefaultAIChatModelID)
+	if !ok || usage.(*store.UserUsage).Count != 2 || usage.(*store.UserUsage).Tokens == 0 {
+		t.Errorf("usage = %+v, want 2 requests with tokens", usage)
+	}
+}
@@ -0,0 +1,238 @@
+package maincontroller
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"tgbot/internal/ai"
+	"tgbot/internal/lib/audio"
+	"tgbot/internal/localization"
+	"tgbot/internal/store"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// TTSChunkMaxLength is the input limit of a single speech synthesis, in runes
+const TTSChunkMaxLength = 4000
+
+// Markdown markup that shouldn't be read aloud
+var speechReplacer = strings.NewReplacer("```", "", "`", "", "**", "", "__", "", "#", "")
+
+// audioFile is a voice note or an audio file sent to the bot
+type audioFile struct {
+	FileID   string
+	FileName string
+	FileSize int
+}
+
+// Speech-to-text providers detect the audio format by the file extension
+var audioExtensions = map[string]string{
+	"audio/ogg":   ".ogg",
+	"audio/opus":  ".ogg",
+	"audio/mpeg":  ".mp3",
+	"audio/mp3":   ".mp3",
+	"audio/mp4":   ".m4a",
+	"audio/x-m4a": ".m4a",
+	"audio/wav":   ".wav",
+	"audio/x-wav": ".wav",
+	"audio/flac":  ".flac",
+	"audio/webm":  ".webm",
+}
+
+func messageAudio(msg *tgbotapi.Message) *audioFile {
+	switch {
+	case msg == nil:
+		return nil
+	case msg.Voice != nil:
+		// Voice notes are always OGG/Opus
+		return &audioFile{FileID: msg.Voice.FileID, FileName: "voice.ogg", FileSize: msg.Voice.FileSize}
+	case msg.Audio != nil:
+		name := msg.Audio.FileName
+		if ext, ok := audioExtensions[msg.Audio.MimeType]; ok && !strings.HasSuffix(strings.ToLower(name), ext) {
+			name = "audio" + ext
+		}
+		return &audioFile{FileID: msg.Audio.FileID, FileName: name, FileSize: msg.Audio.FileSize}
+	}
+	return nil
+}
+
+// transcribeAudio converts the audio to text with the speech-to-text model and echoes the transcript
+// back to the user. False is returned if there is nothing to pass on to the chat model.
+func (mc *MainController) transcribeAudio(req *Request, msgEx *MessageManager, audio *audioFile) (string, bool) {
+	method := "transcribeAudio()"
+	us := req.UserShell
+
+	if audio.FileSize > TgFileMaxSize {
+		_, _ = msgEx.send(newTgMessage(us.ID, localeText(us.Locale, localization.MTypeMsgFileTooBig, TgFileMaxSize>>20)))
+		return "", false
+	}
+
+	speechToText, aiModel, err := mc.speechToText(store.DefaultAISpeechToTextModelID)
+	if err != nil {
+		msgEx.sendError(fmt.Errorf("%s: %w", method, err))
+		return "", false
+	}
+
+	checkLimit, err := checkUserUsage(mc, msgEx, us, aiModel.ID)
+	if err != nil {
+		msgEx.sendError(fmt.Errorf("%s: %w", method, err))
+		return "", false
+	}
+	if !checkLimit {
+		return "", false
+	}
+
+	mc.addRequestToPool(us.ID, req)
+	defer mc.requestPool.Delete(us.ID)
+
+	_, _ = msgEx.send(tgbotapi.NewChatAction(us.ID, tgbotapi.ChatTyping))
+
+	data, err := mc.downloadTgFile(req.AICtx, audio.FileID)
+	if errors.Is(err, errTgFileTooBig) {
+		_, _ = msgEx.send(newTgMessage(us.ID, localeText(us.Locale, localization.MTypeMsgFileTooBig, TgFileMaxSize>>20)))
+		return "", false
+	}
+	if err != nil && req.AICtx.Err() != nil {
+		_, _ = msgEx.send(newTgMessage(us.ID, localeText(us.Locale, req.canceledMessageType())))
+		return "", false
+	}
+	if err != nil {
+		msgEx.sendError(fmt.Errorf("%s: %w", method, err))
+		return "", false
+	}
+
+	transcription, err := speechToText.Transcribe(req.AICtx, ai.TranscriptionRequest{
+		Model:    aiModel.APIName,
+		Audio:    data,
+		FileName: audio.FileName,
+	})
+	if errors.Is(err, ai.ErrCanceled) {
+		_, _ = msgEx.send(newTgMessage(us.ID, localeText(us.Locale, req.canceledMessageType())))
+		return "", false
+	}
+	if err != nil {
+		msgEx.sendError(fmt.Errorf("%s: %w", method, err))
+		return "", false
+	}
+
+	// The transcription is paid for even if nothing was recognized
+	err = mc.store.UpdateUserUsage(req.Ctx, us, aiModel.ID, 0)
+	if err != nil {
+		msgEx.sendError(fmt.Errorf("%s: %w", method, err))
+		return "", false
+	}
+
+	return echoTranscript(req, msgEx, transcription.Text)
+}
+
+// echoTranscript shows the user what was recognized, so a wrong answer can be traced to the transcript
+func echoTranscript(req *Request, msgEx *MessageManager, transcript string) (string, bool) {
+	us := req.UserShell
+	transcript = strings.TrimSpace(transcript)
+	if transcript == "" {
+		_, _ = msgEx.send(newTgMessage(us.ID, localeText(us.Locale, localization.MTypeMsgSpeechNotRecognized)))
+		return "", false
+	}
+
+	runes := []rune("🎤 " + transcript)
+	for len(runes) > 0 {
+		n := min(len(runes), TgMessageMaxLength)
+		msg := newTgMessage(us.ID, string(runes[:n]))
+		if req.Update.Message != nil {
+			msg.ReplyToMessageID = req.Update.Message.MessageID
+		}
+		_, _ = msgEx.send(msg)
+		runes = runes[n:]
+	}
+	return transcript, true
+}
+
+// sendVoiceAnswer synthesizes the answer and sends it as voice messages, one per chunk.
+// Every chunk counts as a separate use of the text-to-speech model.
+func (mc *MainController) sendVoiceAnswer(req *Request, msgEx *MessageManager, answer string) {
+	method := "sendVoiceAnswer()"
+	us := req.UserShell
+
+	textToSpeech, aiModel, err := mc.textToSpeech(store.DefaultAITextToSpeechModelID)
+	if err != nil {
+		msgEx.sendError(fmt.Errorf("%s: %w", method, err))
+		return
+	}
+
+	for _, chunk := range splitSpeechText(speechReplacer.Replace(answer), TTSChunkMaxLength) {
+		checkLimit, err := checkUserUsage(mc, msgEx, us, aiModel.ID)
+		if err != nil {
+			msgEx.sendError(fmt.Errorf("%s: %w", method, err))
+			return
+		}
+		if !checkLimit {
+			return
+		}
+
+		_, _ = msgEx.send(tgbotapi.NewChatAction(us.ID, tgbotapi.ChatRecordVoice))
+
+		speech, err := textToSpeech.Synthesize(req.AICtx, ai.SpeechRequest{
+			Model:  aiModel.APIName,
+			Text:   chunk,
+			Format: ai.SpeechFormatOpus,
+		})
+		if errors.Is(err, ai.ErrCanceled) {
+			_, _ = msgEx.send(newTgMessage(us.ID, localeText(us.Locale, req.canceledMessageType())))
+			return
+		}
+		if err != nil {
+			msgEx.sendError(fmt.Errorf("%s: %w", method, err))
+			return
+		}
+
+		err = mc.store.UpdateUserUsage(req.Ctx, us, aiModel.ID, 0)
+		if err != nil {
+			msgEx.sendError(fmt.Errorf("%s: %w", method, err))
+			return
+		}
+
+		data := speech.Data
+		if speech.Format != ai.SpeechFormatOpus {
+			data, err = audio.ToOggOpus(req.AICtx, data)
+			if err != nil {
+				msgEx.sendError(fmt.Errorf("%s: %w", method, err))
+				return
+			}
+		}
+
+		_, err = msgEx.send(tgbotapi.NewVoice(us.ID, tgbotapi.FileBytes{Name: "answer.ogg", Bytes: data}))
+		if err != nil {
+			msgEx.sendError(fmt.Errorf("%s: %w", method, err))
+			return
+		}
+	}
+}
+
+// splitSpeechText splits the text into chunks of at most size runes,
+// preferably at the end of a paragraph, a sentence or a word
+func splitSpeechText(text string, size int) []string {
+	var res []string
+	runes := []rune(strings.TrimSpace(text))
+	for len(runes) > size {
+		cut := speechCut(runes[:size])
+		res = append(res, strings.TrimSpace(string(runes[:cut])))
+		runes = []rune(strings.TrimSpace(string(runes[cut:])))
+	}
+	if len(runes) > 0 {
+		res = append(res, string(runes))
+	}
+	return res
+}
+
+// speechCut returns the position after the last separator in the second half of the window
+func speechCut(window []rune) int {
+	for _, separators := range [][]rune{{'\n'}, {'.', '!', '?'}, {' '}} {
+		for i := len(window) - 1; i >= len(window)/2; i-- {
+			if slices.Contains(separators, window[i]) {
+				return i + 1
+			}
+		}
+	}
+	return len(window)
+}
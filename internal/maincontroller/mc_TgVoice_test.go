@@ -0,0 +1,29 @@
+package maincontroller
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+// Answers of a user with voice answers on are sent as voice notes made by the fake backend
+func TestVoiceAnswerFakeBackend(t *testing.T) {
+	mc, tg := newTestController(t, nil)
+	const userID = 42
+
+	mc.handleTgUpdate(newTextUpdate(1, userID, "Hello there"))
+	us, err := mc.store.GetUserShellByID(context.Background(), userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	us.User.SendVoiceAnswer = true
+	mc.handleTgUpdate(newTextUpdate(2, userID, "Say it"))
+
+	voices := tg.callsOf("sendVoice")
+	if len(voices) != 1 {
+		t.Fatalf("%d voice notes sent, want 1 (calls %+v)", len(voices), tg.callsOf("sendMessage"))
+	}
+	if !bytes.HasPrefix(voices[0].File, []byte("OggS")) {
+		t.Errorf("voice note is not Ogg: % x", voices[0].File[:min(len(voices[0].File), 16)])
+	}
+}
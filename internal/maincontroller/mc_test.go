@@ -0,0 +1,57 @@
+package maincontroller
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"tgbot/internal/config"
+	"tgbot/internal/localization"
+	"tgbot/internal/store"
+)
+
+// Answers still streaming at the shutdown deadline are interrupted, their partial text is saved
+// and the message tells the user about the interruption
+func TestShutdownInterruptsAnswers(t *testing.T) {
+	mc, tg := newTestController(t, func(cfg *config.Config) {
+		cfg.FakeDelay = 100 * time.Millisecond
+	})
+	const userID = 42
+	ctx := context.Background()
+
+	if err := mc.dispatcher.Dispatch(ctx, newTextUpdate(1, userID, "Tell me a long story")); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		if _, ok := mc.hasActiveUserRequest(userID); ok {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// A few words of the answer are streamed
+	time.Sleep(500 * time.Millisecond)
+
+	shutdownCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := mc.Shutdown(shutdownCtx); err != nil {
+		t.Fatal(err)
+	}
+
+	texts := tg.lastTexts()
+	footer := localeText("en", localization.MTypeMsgRequestInterrupted)
+	if len(texts) == 0 || !strings.HasSuffix(texts[len(texts)-1], footer) {
+		t.Errorf("answer %q, want the interrupted footer", texts)
+	}
+	us, err := mc.store.GetUserShellByID(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(us.Context) != 2 {
+		t.Fatalf("dialog has %d messages, want the message and the partial answer", len(us.Context))
+	}
+	answer := us.Context[1]
+	if answer.Role != store.RoleAssistant || answer.Content == "" || strings.HasSuffix(answer.Content, "Tell me a long story") {
+		t.Errorf("saved answer %+v, want the partial answer", answer)
+	}
+}
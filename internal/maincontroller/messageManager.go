@@ -40,16 +40,14 @@ func (msgEx *MessageManager) sendError(err error) {
 	msgEx.errorChan <- err
 }
 
+// close cancels the exchange before closing the channels, so the reader stops once it
+// sees a closed channel. The channels are still read by it, so the fields are kept.
 func (msgEx *MessageManager) close() {
 	msgEx.cancel()
 	close(msgEx.msgChan)
 	close(msgEx.errorChan)
 	close(msgEx.replyChan)
 	close(msgEx.replyErrorChan)
-	msgEx.msgChan = nil
-	msgEx.errorChan = nil
-	msgEx.replyChan = nil
-	msgEx.replyErrorChan = nil
 }
 
 func (msgEx *MessageManager) canceled() bool {
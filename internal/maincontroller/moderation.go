@@ -0,0 +1,115 @@
+package maincontroller
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"tgbot/internal/ai"
+	"tgbot/internal/lib/logger/sl"
+	"tgbot/internal/localization"
+	"tgbot/internal/store"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Flagged content saved with the flag, symbols
+const moderationContentMaxLength = 1000
+
+// moderateInput checks the message of the user. Flagged messages are refused and saved,
+// users flagged too often are blocked. Returns true if the message can be processed,
+// messages are not held back if the moderation fails.
+func (mc *MainController) moderateInput(req *Request, msgEx *MessageManager, text string) bool {
+	us := req.UserShell
+	result := mc.moderate(req, text)
+	if result == nil || !result.Flagged {
+		return true
+	}
+
+	mc.saveModerationFlag(req, store.ModerationSourceInput, result, text)
+	_, _ = msgEx.send(newTgMessage(us.ID, localeText(us.Locale, localization.MTypeMsgModerationFlagged)))
+	mc.blockFlaggedUser(req, msgEx, result)
+	return false
+}
+
+// moderateAnswer checks the answer if answers are moderated, the messages of a flagged answer
+// are deleted. Returns true if the answer can be kept.
+func (mc *MainController) moderateAnswer(req *Request, msgEx *MessageManager, answer string, messageIDs []int) bool {
+	if !mc.moderation.CheckAnswers {
+		return true
+	}
+	us := req.UserShell
+	result := mc.moderate(req, answer)
+	if result == nil || !result.Flagged {
+		return true
+	}
+
+	mc.saveModerationFlag(req, store.ModerationSourceAnswer, result, answer)
+	for _, id := range messageIDs {
+		_, _ = msgEx.send(tgbotapi.NewDeleteMessage(us.ID, id))
+	}
+	_, _ = msgEx.send(newTgMessage(us.ID, localeText(us.Locale, localization.MTypeMsgAnswerModerated)))
+	return false
+}
+
+// moderate returns nil if the moderation is disabled or failed
+func (mc *MainController) moderate(req *Request, text string) *ai.ModerationResult {
+	if mc.moderator == nil || strings.TrimSpace(text) == "" {
+		return nil
+	}
+	result, err := mc.moderator.Moderate(req.AICtx, ai.ModerationRequest{Model: mc.moderation.Model, Text: text})
+	if err != nil {
+		mc.log.Error("Could not moderate message", slog.Int64("User id", req.UserShell.ID), sl.Err(err))
+		return nil
+	}
+	return result
+}
+
+func (mc *MainController) saveModerationFlag(req *Request, source store.ModerationSource, result *ai.ModerationResult, text string) {
+	us := req.UserShell
+	categories := strings.Join(result.Categories, ",")
+	mc.log.Warn("Message flagged by moderation",
+		slog.Int64("User id", us.ID), slog.Int("Source", int(source)), slog.String("Categories", categories))
+
+	content := text
+	if runes := []rune(content); len(runes) > moderationContentMaxLength {
+		content = string(runes[:moderationContentMaxLength])
+	}
+	_, err := mc.store.AddModerationFlag(req.Ctx, &store.ModerationFlag{
+		UserID:     us.ID,
+		Source:     source,
+		Categories: categories,
+		Content:    content,
+		Created:    time.Now().UTC(),
+	})
+	if err != nil {
+		mc.log.Error("Could not save moderation flag", slog.Int64("User id", us.ID), sl.Err(err))
+	}
+}
+
+// blockFlaggedUser blocks the user whose messages were flagged too many times within the window
+func (mc *MainController) blockFlaggedUser(req *Request, msgEx *MessageManager, result *ai.ModerationResult) {
+	us := req.UserShell
+	if mc.moderation.BlockAfter <= 0 || us.ID == mc.tgAdmin {
+		return
+	}
+
+	since := time.Now().UTC().Add(-mc.moderation.BlockWindow)
+	count, err := mc.store.CountModerationFlags(req.Ctx, us.ID, store.ModerationSourceInput, since)
+	if err != nil {
+		mc.log.Error("Could not count moderation flags", slog.Int64("User id", us.ID), sl.Err(err))
+		return
+	}
+	if count < mc.moderation.BlockAfter {
+		return
+	}
+
+	reason := fmt.Sprintf("moderation: %d flagged messages within %s, last: %s",
+		count, mc.moderation.BlockWindow, strings.Join(result.Categories, ", "))
+	if err = mc.store.BlockUser(req.Ctx, us, reason, mc.tgAdmin); err != nil {
+		mc.log.Error("Could not block flagged user", slog.Int64("User id", us.ID), sl.Err(err))
+		return
+	}
+	mc.log.Warn("User blocked by moderation", slog.Int64("User id", us.ID), slog.String("Reason", reason))
+	_, _ = msgEx.send(newTgMessage(us.ID, localeText(us.Locale, localization.MTypeMsgModerationBlocked)))
+}
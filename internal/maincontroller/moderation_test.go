@@ -0,0 +1,121 @@
+package maincontroller
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"tgbot/internal/config"
+	"tgbot/internal/localization"
+	"tgbot/internal/store"
+)
+
+func TestModerateInput(t *testing.T) {
+	mc, tg := newTestController(t, func(cfg *config.Config) {
+		cfg.Moderation = config.ModerationConfig{
+			Moderator:   config.ModeratorLocal,
+			Patterns:    map[string][]string{"violence": {`\bkill\b`}},
+			BlockAfter:  3,
+			BlockWindow: time.Hour,
+		}
+	})
+	const userID = 42
+	ctx := context.Background()
+	flagged := localeText("en", localization.MTypeMsgModerationFlagged)
+	blocked := localeText("en", localization.MTypeMsgModerationBlocked)
+
+	send := func(updateID int, text string) []string {
+		t.Helper()
+		before := len(tg.lastTexts())
+		mc.handleTgUpdate(newTextUpdate(updateID, userID, text))
+		if err := mc.outbox.Flush(ctx); err != nil {
+			t.Fatal(err)
+		}
+		return tg.lastTexts()[before:]
+	}
+	userShell := func() *store.UserShell {
+		t.Helper()
+		us, err := mc.store.GetUserShellByID(ctx, userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return us
+	}
+
+	if texts := send(1, "Hello"); len(texts) != 1 || !strings.Contains(texts[0], "fake answer") {
+		t.Fatalf("clean message got %q, want an answer", texts)
+	}
+
+	// Only the flags within the window count
+	if _, err := mc.store.AddModerationFlag(ctx, &store.ModerationFlag{
+		UserID: userID, Source: store.ModerationSourceInput, Categories: "violence", Created: time.Now().UTC().Add(-2 * time.Hour),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 1; i <= 3; i++ {
+		texts := send(1+i, "I will KILL the process")
+		want := []string{flagged}
+		if i == 3 {
+			want = append(want, blocked)
+		}
+		if !slices.Equal(texts, want) {
+			t.Fatalf("flagged message %d got %q, want %q", i, texts, want)
+		}
+
+		count, err := mc.store.CountModerationFlags(ctx, userID, store.ModerationSourceInput, time.Now().UTC().Add(-time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != i {
+			t.Errorf("%d flags saved, want %d", count, i)
+		}
+		if us := userShell(); us.User.Blocked != (i == 3) {
+			t.Fatalf("after %d flagged messages blocked = %v", i, us.User.Blocked)
+		}
+	}
+
+	// The refused messages are not in the dialog, blocked users get no answers
+	if texts := send(5, "Hello again"); len(texts) != 0 {
+		t.Errorf("blocked user got %q", texts)
+	}
+	if us := userShell(); len(us.Context) != 2 {
+		t.Errorf("dialog has %d messages, want the first message and its answer", len(us.Context))
+	}
+}
+
+// Image prompts are checked like messages, both from the command and from the next message
+func TestModerateImagePrompt(t *testing.T) {
+	mc, tg := newTestController(t, func(cfg *config.Config) {
+		cfg.Moderation = config.ModerationConfig{
+			Moderator:   config.ModeratorLocal,
+			Patterns:    map[string][]string{"violence": {`\bkill\b`}},
+			BlockAfter:  10,
+			BlockWindow: time.Hour,
+		}
+	})
+	const userID = 42
+	ctx := context.Background()
+	flagged := localeText("en", localization.MTypeMsgModerationFlagged)
+
+	mc.handleTgUpdate(newCommandUpdate(1, userID, "/image kill the dragon"))
+	mc.handleTgUpdate(newCommandUpdate(2, userID, "/image"))
+	mc.handleTgUpdate(newTextUpdate(3, userID, "kill the knight"))
+	if err := mc.outbox.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{flagged, localeText("en", localization.MTypeMsgImagePromptRequest), flagged}
+	if texts := tg.lastTexts(); !slices.Equal(texts, want) {
+		t.Errorf("image prompts got %q, want %q", texts, want)
+	}
+	count, err := mc.store.CountModerationFlags(ctx, userID, store.ModerationSourceInput, time.Now().UTC().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("%d flags saved, want 2", count)
+	}
+}
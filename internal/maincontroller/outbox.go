@@ -0,0 +1,432 @@
+package maincontroller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"tgbot/internal/config"
+	"tgbot/internal/lib/tgmarkdown"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Times a request is repeated after Telegram answered it with retry_after
+const outboxMaxRetries = 3
+
+// OutboxLimits are the rates of requests Telegram accepts from a bot
+type OutboxLimits struct {
+	GlobalPerSecond float64
+	ChatPerSecond   float64
+	GroupPerMinute  float64
+	// Requests a chat can send at once before its rate applies
+	ChatBurst int
+}
+
+func outboxLimits(cfg config.TgLimitsConfig) OutboxLimits {
+	return OutboxLimits{
+		GlobalPerSecond: cfg.GlobalPerSecond,
+		ChatPerSecond:   cfg.ChatPerSecond,
+		GroupPerMinute:  cfg.GroupPerMinute,
+		ChatBurst:       cfg.ChatBurst,
+	}
+}
+
+// Outbox sends the requests to Telegram within the global, chat and group rate limits.
+// Requests of a chat are sent in order by a goroutine of the chat. Edits are sent in the
+// background and replace the pending edit of the same message, so a streaming message is
+// updated with its newest text only. Requests answered with retry_after wait the time
+// and are repeated. Requests without a chat are not queued, only the global limit applies to them.
+type Outbox struct {
+	bot    *tgbotapi.BotAPI
+	limits OutboxLimits
+	log    *slog.Logger
+	global *tokenBucket
+	mu     sync.Mutex
+	chats  map[int64]*chatOutbox
+	// Counts the requests not sent yet for Flush
+	pending sync.WaitGroup
+}
+
+type chatOutbox struct {
+	id      int64
+	limiter *tokenBucket
+	queue   []*outboxItem
+	// Pending edits by message id
+	edits map[int]*outboxItem
+	wake  chan struct{}
+	// Error of the last failed background request, returned by the next Sync
+	err error
+}
+
+type outboxItem struct {
+	// Nil for the mark of Sync
+	chattable tgbotapi.Chattable
+	// Message id of an edit, 0 for other requests
+	editID int
+	// Receives the result, nil if nobody waits for it
+	result chan outboxResult
+}
+
+type outboxResult struct {
+	msg tgbotapi.Message
+	err error
+}
+
+func NewOutbox(bot *tgbotapi.BotAPI, limits OutboxLimits, log *slog.Logger) *Outbox {
+	return &Outbox{
+		bot:    bot,
+		limits: limits,
+		log:    log,
+		global: newTokenBucket(limits.GlobalPerSecond, limits.GlobalPerSecond),
+		chats:  map[int64]*chatOutbox{},
+	}
+}
+
+// Send sends the request after the requests queued before it and returns the sent message
+func (o *Outbox) Send(chattable tgbotapi.Chattable) (tgbotapi.Message, error) {
+	chatID := chatIDOf(chattable)
+	if chatID == 0 {
+		o.pending.Add(1)
+		defer o.pending.Done()
+		return o.send(nil, chattable)
+	}
+	item := &outboxItem{chattable: chattable, result: make(chan outboxResult, 1)}
+	o.enqueue(chatID, item)
+	res := <-item.result
+	return res.msg, res.err
+}
+
+// Post queues the request without waiting for it, its error is returned by the next Sync of the chat
+func (o *Outbox) Post(chattable tgbotapi.Chattable) {
+	chatID := chatIDOf(chattable)
+	if chatID == 0 {
+		o.pending.Add(1)
+		go func() {
+			defer o.pending.Done()
+			if _, err := o.send(nil, chattable); err != nil {
+				o.logFailed(chatID, err)
+			}
+		}()
+		return
+	}
+	o.enqueue(chatID, &outboxItem{chattable: chattable})
+}
+
+// Sync waits until the requests of the chat queued before are sent and returns the error of the
+// last request sent in the background since the previous Sync
+func (o *Outbox) Sync(ctx context.Context, chatID int64) error {
+	o.mu.Lock()
+	if _, ok := o.chats[chatID]; !ok {
+		// Nothing is queued and the errors of an idle chat are dropped
+		o.mu.Unlock()
+		return nil
+	}
+	item := &outboxItem{result: make(chan outboxResult, 1)}
+	o.enqueueLocked(chatID, item)
+	o.mu.Unlock()
+
+	select {
+	case res := <-item.result:
+		return res.err
+	case <-ctx.Done():
+		return fmt.Errorf("Sync(): %w", ctx.Err())
+	}
+}
+
+// Edit queues the edit of the message in the background, a pending edit of the message is replaced
+func (o *Outbox) Edit(edit tgbotapi.EditMessageTextConfig) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if chat, ok := o.chats[edit.ChatID]; ok {
+		if item, ok := chat.edits[edit.MessageID]; ok {
+			item.chattable = edit
+			return
+		}
+	}
+	o.enqueueLocked(edit.ChatID, &outboxItem{chattable: edit, editID: edit.MessageID})
+}
+
+// Flush waits until the queued requests are sent
+func (o *Outbox) Flush(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		o.pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("Flush(): %w", ctx.Err())
+	}
+}
+
+func (o *Outbox) enqueue(chatID int64, item *outboxItem) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.enqueueLocked(chatID, item)
+}
+
+func (o *Outbox) enqueueLocked(chatID int64, item *outboxItem) {
+	o.pending.Add(1)
+	chat, ok := o.chats[chatID]
+	if !ok {
+		chat = &chatOutbox{
+			id:      chatID,
+			limiter: o.chatLimiter(chatID),
+			edits:   map[int]*outboxItem{},
+			wake:    make(chan struct{}, 1),
+		}
+		o.chats[chatID] = chat
+		go o.run(chat)
+	}
+	chat.queue = append(chat.queue, item)
+	if item.editID != 0 {
+		chat.edits[item.editID] = item
+	}
+	select {
+	case chat.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Group chats have negative ids and a per minute limit
+func (o *Outbox) chatLimiter(chatID int64) *tokenBucket {
+	burst := float64(o.limits.ChatBurst)
+	if chatID < 0 {
+		return newTokenBucket(o.limits.GroupPerMinute/60, burst)
+	}
+	return newTokenBucket(o.limits.ChatPerSecond, burst)
+}
+
+// run sends the requests of the chat. The chat is removed once its queue is empty and
+// its limiter is refilled, a new chat would start with a full one anyway.
+func (o *Outbox) run(chat *chatOutbox) {
+	for {
+		o.mu.Lock()
+		if len(chat.queue) == 0 {
+			idle := chat.limiter.fullIn(time.Now())
+			if idle <= 0 {
+				delete(o.chats, chat.id)
+				o.mu.Unlock()
+				return
+			}
+			o.mu.Unlock()
+			select {
+			case <-chat.wake:
+			case <-time.After(idle):
+			}
+			continue
+		}
+		// The request is taken once it can be sent, edits queued meanwhile still replace it
+		if wait := chat.limiter.availableIn(time.Now()); wait > 0 && chat.queue[0].chattable != nil {
+			o.mu.Unlock()
+			time.Sleep(wait)
+			continue
+		}
+		item := chat.queue[0]
+		chat.queue = chat.queue[1:]
+		if item.editID != 0 {
+			delete(chat.edits, item.editID)
+		}
+		o.mu.Unlock()
+
+		if item.chattable == nil {
+			item.result <- outboxResult{err: chat.err}
+			chat.err = nil
+			o.pending.Done()
+			continue
+		}
+
+		msg, err := o.send(chat, item.chattable)
+		if item.result != nil {
+			item.result <- outboxResult{msg: msg, err: err}
+		} else if err != nil {
+			o.logFailed(chat.id, err)
+			chat.err = err
+		}
+		o.pending.Done()
+	}
+}
+
+func (o *Outbox) logFailed(chatID int64, err error) {
+	o.log.Warn("Could not send queued request",
+		slog.Attr{Key: "Chat id", Value: slog.Int64Value(chatID)},
+		slog.Attr{Key: "Error", Value: slog.StringValue(err.Error())})
+}
+
+// send waits for the chat and the global limits and sends the request, it is repeated
+// after the time Telegram asks to wait. Texts whose entities Telegram rejects are sent as plain text.
+// A request without a chat waits for the global limit only.
+func (o *Outbox) send(chat *chatOutbox, chattable tgbotapi.Chattable) (tgbotapi.Message, error) {
+	var chatID int64
+	if chat != nil {
+		chatID = chat.id
+	}
+	for attempt := 0; ; attempt++ {
+		if chat != nil {
+			time.Sleep(chat.limiter.reserve(time.Now()))
+		}
+		time.Sleep(o.global.reserve(time.Now()))
+
+		msg, err := o.request(chattable)
+		if isEntityError(err) {
+			if plain, ok := withoutMarkdown(chattable); ok {
+				o.log.Warn("Telegram rejected the entities, sending plain text",
+					slog.Attr{Key: "Chat id", Value: slog.Int64Value(chatID)},
+					slog.Attr{Key: "Error", Value: slog.StringValue(err.Error())})
+				chattable = plain
+				msg, err = o.request(chattable)
+			}
+		}
+		var tgErr *tgbotapi.Error
+		if !errors.As(err, &tgErr) || tgErr.RetryAfter == 0 || attempt >= outboxMaxRetries {
+			return msg, err
+		}
+		retryAfter := time.Duration(tgErr.RetryAfter) * time.Second
+		o.log.Warn("Telegram rate limit hit",
+			slog.Attr{Key: "Chat id", Value: slog.Int64Value(chatID)},
+			slog.Attr{Key: "Retry after", Value: slog.StringValue(retryAfter.String())})
+		// Telegram doesn't tell which limit was hit, so nothing is sent until the time is over
+		if chat != nil {
+			chat.limiter.pause(time.Now(), retryAfter)
+		}
+		o.global.pause(time.Now(), retryAfter)
+	}
+}
+
+// request sends the request, deleting returns no message
+func (o *Outbox) request(chattable tgbotapi.Chattable) (tgbotapi.Message, error) {
+	if _, ok := chattable.(tgbotapi.DeleteMessageConfig); ok {
+		_, err := o.bot.Request(chattable)
+		return tgbotapi.Message{}, err
+	}
+	return o.bot.Send(chattable)
+}
+
+func isEntityError(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "can't parse entities")
+}
+
+// withoutMarkdown returns the request with its MarkdownV2 text turned into plain text
+func withoutMarkdown(chattable tgbotapi.Chattable) (tgbotapi.Chattable, bool) {
+	switch c := chattable.(type) {
+	case tgbotapi.MessageConfig:
+		if c.ParseMode == tgbotapi.ModeMarkdownV2 {
+			c.Text, c.ParseMode = tgmarkdown.Strip(c.Text), ""
+			return c, true
+		}
+	case tgbotapi.EditMessageTextConfig:
+		if c.ParseMode == tgbotapi.ModeMarkdownV2 {
+			c.Text, c.ParseMode = tgmarkdown.Strip(c.Text), ""
+			return c, true
+		}
+	case tgbotapi.PhotoConfig:
+		if c.ParseMode == tgbotapi.ModeMarkdownV2 {
+			c.Caption, c.ParseMode = tgmarkdown.Strip(c.Caption), ""
+			return c, true
+		}
+	case tgbotapi.VoiceConfig:
+		if c.ParseMode == tgbotapi.ModeMarkdownV2 {
+			c.Caption, c.ParseMode = tgmarkdown.Strip(c.Caption), ""
+			return c, true
+		}
+	}
+	return chattable, false
+}
+
+// chatIDOf returns the chat of the request, 0 if it has none
+func chatIDOf(chattable tgbotapi.Chattable) int64 {
+	switch c := chattable.(type) {
+	case tgbotapi.MessageConfig:
+		return c.ChatID
+	case tgbotapi.PhotoConfig:
+		return c.ChatID
+	case tgbotapi.VoiceConfig:
+		return c.ChatID
+	case tgbotapi.DocumentConfig:
+		return c.ChatID
+	case tgbotapi.AudioConfig:
+		return c.ChatID
+	case tgbotapi.VideoConfig:
+		return c.ChatID
+	case tgbotapi.AnimationConfig:
+		return c.ChatID
+	case tgbotapi.StickerConfig:
+		return c.ChatID
+	case tgbotapi.MediaGroupConfig:
+		return c.ChatID
+	case tgbotapi.EditMessageTextConfig:
+		return c.ChatID
+	case tgbotapi.EditMessageCaptionConfig:
+		return c.ChatID
+	case tgbotapi.EditMessageReplyMarkupConfig:
+		return c.ChatID
+	case tgbotapi.DeleteMessageConfig:
+		return c.ChatID
+	}
+	return 0
+}
+
+// tokenBucket allows burst requests at once and rate requests per second after that
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate, burst float64) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if !b.last.IsZero() && now.After(b.last) {
+		b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+}
+
+// reserve takes a token and returns the time to wait before it can be used
+func (b *tokenBucket) reserve(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// availableIn returns the time until a token is available without taking it
+func (b *tokenBucket) availableIn(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// pause makes the next token available after d
+func (b *tokenBucket) pause(now time.Time, d time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	b.tokens = min(b.tokens, -d.Seconds()*b.rate+1)
+}
+
+// fullIn returns the time until the bucket is full
+func (b *tokenBucket) fullIn(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	return time.Duration((b.burst - b.tokens) / b.rate * float64(time.Second))
+}
@@ -0,0 +1,219 @@
+package maincontroller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"tgbot/internal/config"
+	"tgbot/internal/lib/tgmarkdown"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const blockedDescription = "Forbidden: bot was blocked by the user"
+
+func newTestOutbox(t *testing.T, limits OutboxLimits) (*Outbox, *tgStub) {
+	t.Helper()
+	bot, stub := newTestBot(t)
+	o := NewOutbox(bot, limits, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	t.Cleanup(func() {
+		_ = o.Flush(context.Background())
+	})
+	return o, stub
+}
+
+// Requests of unknown types don't share a chat queue, only the global limit applies
+func TestOutboxRequestWithoutChat(t *testing.T) {
+	o, tg := newTestOutbox(t, OutboxLimits{GlobalPerSecond: 100, ChatPerSecond: 100, ChatBurst: 10})
+
+	o.Post(tgbotapi.NewChatAction(42, tgbotapi.ChatTyping))
+	if err := o.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(tg.callsOf("sendChatAction")) != 1 {
+		t.Error("request is not sent")
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.chats) != 0 {
+		t.Errorf("request is queued in chats %v", o.chats)
+	}
+}
+
+// retry_after stops the requests of the other chats too
+func TestOutboxRetryAfterPausesGlobal(t *testing.T) {
+	o, tg := newTestOutbox(t, OutboxLimits{GlobalPerSecond: 100, ChatPerSecond: 100, ChatBurst: 10})
+	tg.fail("sendMessage", 1, 429, "Too Many Requests: retry after 1", 1)
+
+	sent := make(chan error, 1)
+	go func() {
+		_, err := o.Send(tgbotapi.NewMessage(1, "first"))
+		sent <- err
+	}()
+	for len(tg.callsOf("sendMessage")) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	if _, err := o.Send(tgbotapi.NewMessage(2, "second")); err != nil {
+		t.Fatal(err)
+	}
+	if wait := time.Since(start); wait < 500*time.Millisecond {
+		t.Errorf("other chat waited %v after retry_after", wait)
+	}
+	if err := <-sent; err != nil {
+		t.Errorf("repeated request: %v", err)
+	}
+	if calls := tg.callsOf("sendMessage"); len(calls) != 3 {
+		t.Errorf("%d requests sent, want 3", len(calls))
+	}
+}
+
+// Errors of the edits and deletes sent in the background are returned by Sync once
+func TestOutboxSync(t *testing.T) {
+	// The chat is kept after the requests while its limiter refills
+	o, tg := newTestOutbox(t, OutboxLimits{GlobalPerSecond: 100, ChatPerSecond: 1, ChatBurst: 10})
+	tg.fail("deleteMessage", 1, 403, blockedDescription, 0)
+
+	o.Edit(tgbotapi.NewEditMessageText(42, 1, "edit"))
+	o.Post(tgbotapi.NewDeleteMessage(42, 1))
+	if err := o.Sync(context.Background(), 42); err == nil || !strings.Contains(err.Error(), blockedDescription) {
+		t.Fatalf("Sync() = %v, want the error of the delete", err)
+	}
+	if len(tg.callsOf("editMessageText")) != 1 || len(tg.callsOf("deleteMessage")) != 1 {
+		t.Error("queued requests are not sent before Sync returns")
+	}
+	if err := o.Sync(context.Background(), 42); err != nil {
+		t.Errorf("second Sync() = %v", err)
+	}
+	if err := o.Sync(context.Background(), 43); err != nil {
+		t.Errorf("Sync() of a chat without requests = %v", err)
+	}
+}
+
+// A user whose edits fail because the bot is blocked is marked like one whose messages fail
+func TestHandleTgUpdateBlockedEdits(t *testing.T) {
+	mc, tg := newTestController(t, func(cfg *config.Config) {
+		cfg.TgLimits.ChatPerSecond = 1
+	})
+	const userID = 42
+
+	mc.handleTgUpdate(newTextUpdate(1, userID, "Hello there"))
+	tg.fail("editMessageText", 100, 403, blockedDescription, 0)
+	mc.handleTgUpdate(newTextUpdate(2, userID, "And again"))
+
+	if len(tg.callsOf("editMessageText")) == 0 {
+		t.Fatal("answer is not edited")
+	}
+	us, err := mc.store.GetUserShellByID(context.Background(), userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !us.User.SelfBlock {
+		t.Error("user is not marked as the one who blocked the bot")
+	}
+	if _, err := mc.sendMessageToTgBot(us, newTgMessage(userID, "hi")); !errors.Is(err, errUserBlockedBot) {
+		t.Errorf("send to the user = %v, want %v", err, errUserBlockedBot)
+	}
+}
+
+// Edits of a message queued while the chat waits for its limit are sent once with the last text
+func TestOutboxEditsMerged(t *testing.T) {
+	o, tg := newTestOutbox(t, OutboxLimits{GlobalPerSecond: 100, ChatPerSecond: 2, ChatBurst: 1})
+	const edits = 10
+
+	msg, err := o.Send(tgbotapi.NewMessage(42, "first"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= edits; i++ {
+		o.Edit(tgbotapi.NewEditMessageText(42, msg.MessageID, fmt.Sprintf("edit %d", i)))
+		// The chat goroutine wakes up on the first edit and waits for the limit
+		if i == 1 {
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+	if err := o.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := tg.callsOf("editMessageText")
+	if len(calls) != 1 || calls[0].Text != fmt.Sprintf("edit %d", edits) {
+		t.Errorf("edits sent %+v, want one with the last text", calls)
+	}
+}
+
+// Requests of a chat are spaced by its limit once the burst is used, groups by the per minute one
+func TestOutboxChatLimits(t *testing.T) {
+	const rate = 10
+	spacing := time.Second / rate
+	tests := []struct {
+		name   string
+		chatID int64
+		limits OutboxLimits
+		burst  int
+	}{
+		{name: "private", chatID: 42,
+			limits: OutboxLimits{GlobalPerSecond: 1000, ChatPerSecond: rate, GroupPerMinute: 1, ChatBurst: 3}, burst: 3},
+		{name: "group", chatID: -42,
+			limits: OutboxLimits{GlobalPerSecond: 1000, ChatPerSecond: 1000, GroupPerMinute: rate * 60, ChatBurst: 1}, burst: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o, tg := newTestOutbox(t, tt.limits)
+			const requests = 5
+			for i := range requests {
+				o.Post(tgbotapi.NewMessage(tt.chatID, fmt.Sprint(i)))
+			}
+			if err := o.Flush(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			calls := tg.callsOf("sendMessage")
+			if len(calls) != requests {
+				t.Fatalf("%d requests sent, want %d", len(calls), requests)
+			}
+			if gap := calls[tt.burst-1].Time.Sub(calls[0].Time); gap > spacing/2 {
+				t.Errorf("burst of %d requests took %v", tt.burst, gap)
+			}
+			for i := tt.burst; i < requests; i++ {
+				// A little slack for the clocks of the outbox and the stub
+				if gap := calls[i].Time.Sub(calls[i-1].Time); gap < spacing*9/10 {
+					t.Errorf("request %d sent %v after the previous one, want %v", i, gap, spacing)
+				}
+			}
+		})
+	}
+}
+
+// Texts whose entities Telegram rejects are sent again as plain text
+func TestOutboxPlainTextRetry(t *testing.T) {
+	o, tg := newTestOutbox(t, OutboxLimits{GlobalPerSecond: 100, ChatPerSecond: 100, ChatBurst: 10})
+	tg.fail("sendMessage", 1, 400, "Bad Request: can't parse entities: Can't find end of the entity starting at byte offset 5", 0)
+
+	rendered := tgmarkdown.Render("Some **bold** and `code`.")
+	msg := tgbotapi.NewMessage(42, rendered)
+	msg.ParseMode = tgbotapi.ModeMarkdownV2
+	if _, err := o.Send(msg); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := tg.callsOf("sendMessage")
+	if len(calls) != 2 {
+		t.Fatalf("%d requests sent, want 2", len(calls))
+	}
+	if calls[0].ParseMode != tgbotapi.ModeMarkdownV2 {
+		t.Errorf("first request parse mode %q", calls[0].ParseMode)
+	}
+	if want := tgmarkdown.Strip(rendered); calls[1].ParseMode != "" || calls[1].Text != want {
+		t.Errorf("retry = %+v, want the plain text %q", calls[1], want)
+	}
+}
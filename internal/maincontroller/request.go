@@ -3,6 +3,8 @@ package maincontroller
 import (
 	"context"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"sync/atomic"
+	"tgbot/internal/localization"
 	"tgbot/internal/store"
 	"time"
 )
@@ -15,6 +17,8 @@ type Request struct {
 	Update    *tgbotapi.Update
 	StartTime time.Time
 	UserShell *store.UserShell
+	// Set when the AI request is canceled by the shutdown instead of the user
+	interrupted atomic.Bool
 }
 
 func newRequest(update *tgbotapi.Update) *Request {
@@ -29,3 +33,17 @@ func newRequest(update *tgbotapi.Update) *Request {
 		AICancel:  aiCancel,
 	}
 }
+
+// interrupt cancels the AI request because the bot is shutting down
+func (req *Request) interrupt() {
+	req.interrupted.Store(true)
+	req.AICancel()
+}
+
+// canceledMessageType returns the message telling why the AI request was canceled
+func (req *Request) canceledMessageType() localization.MessageType {
+	if req.interrupted.Load() {
+		return localization.MTypeMsgRequestInterrupted
+	}
+	return localization.MTypeMsgRequestCanceledByUser
+}
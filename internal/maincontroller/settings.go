@@ -0,0 +1,214 @@
+package maincontroller
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"tgbot/internal/ai"
+	"tgbot/internal/localization"
+	"tgbot/internal/store"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// settingsScope is the level the generation parameters are edited on
+type settingsScope int
+
+const (
+	settingsScopeUser settingsScope = iota
+	settingsScopeDialog
+)
+
+type generationParam int
+
+const (
+	paramTemperature generationParam = iota
+	paramTopP
+	paramMaxTokens
+	paramReasoningEffort
+)
+
+// Value of the settings callback that unsets the parameter
+const paramUnset = "-"
+
+var errInvalidParam = errors.New("invalid generation parameter")
+
+// settingsParam describes a parameter of the /settings keyboard
+type settingsParam struct {
+	param generationParam
+	emoji string
+	// Values offered on the keyboard
+	options []string
+	// Capability the model needs to use the parameter, 0 if all models can
+	capability store.ModelCapability
+}
+
+var settingsParams = []settingsParam{
+	{paramTemperature, "🌡", []string{"0", "0.3", "0.7", "1", "1.5"}, store.CapSampling},
+	{paramTopP, "🎯", []string{"0.5", "0.8", "0.95", "1"}, store.CapSampling},
+	{paramMaxTokens, "📏", []string{"512", "1024", "4096", "16384"}, 0},
+	{paramReasoningEffort, "🧠", []string{ai.EffortLow, ai.EffortMedium, ai.EffortHigh}, store.CapReasoning},
+}
+
+// paramValue returns the parameter formatted as the keyboard option, empty if it's unset
+func paramValue(params store.GenerationParams, param generationParam) string {
+	switch param {
+	case paramTemperature:
+		if params.Temperature != nil {
+			return strconv.FormatFloat(*params.Temperature, 'f', -1, 64)
+		}
+	case paramTopP:
+		if params.TopP != nil {
+			return strconv.FormatFloat(*params.TopP, 'f', -1, 64)
+		}
+	case paramMaxTokens:
+		if params.MaxTokens != nil {
+			return strconv.Itoa(*params.MaxTokens)
+		}
+	case paramReasoningEffort:
+		if params.ReasoningEffort != nil {
+			return *params.ReasoningEffort
+		}
+	}
+	return ""
+}
+
+// setParam parses the keyboard option into the parameter, paramUnset unsets it.
+// The option comes from the callback data, so values out of the range of the parameter are rejected.
+func setParam(params store.GenerationParams, param generationParam, value string) (store.GenerationParams, error) {
+	method := "setParam()"
+	unset := value == paramUnset
+	switch param {
+	case paramTemperature, paramTopP:
+		var v *float64
+		if !unset {
+			f, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return params, fmt.Errorf("%s: %w: %w", method, errInvalidParam, err)
+			}
+			// NaN fails the comparisons too
+			valid := f >= 0 && f <= store.MaxTemperature
+			if param == paramTopP {
+				valid = f > 0 && f <= 1
+			}
+			if !valid {
+				return params, fmt.Errorf("%s: %w: %d = %s", method, errInvalidParam, param, value)
+			}
+			v = &f
+		}
+		if param == paramTemperature {
+			params.Temperature = v
+		} else {
+			params.TopP = v
+		}
+	case paramMaxTokens:
+		params.MaxTokens = nil
+		if !unset {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return params, fmt.Errorf("%s: %w: %w", method, errInvalidParam, err)
+			}
+			if n <= 0 || n > store.MaxTokensLimit {
+				return params, fmt.Errorf("%s: %w: %d = %s", method, errInvalidParam, param, value)
+			}
+			params.MaxTokens = &n
+		}
+	case paramReasoningEffort:
+		if !unset && value != ai.EffortLow && value != ai.EffortMedium && value != ai.EffortHigh {
+			return params, fmt.Errorf("%s: %w: %d = %s", method, errInvalidParam, param, value)
+		}
+		params.ReasoningEffort = nil
+		if !unset {
+			params.ReasoningEffort = &value
+		}
+	default:
+		return params, fmt.Errorf("%s: %w: %d", method, errInvalidParam, param)
+	}
+	return params, nil
+}
+
+// applyParams sets the parameters of the user the model supports to the request
+func applyParams(request *ai.ChatRequest, us *store.UserShell, model *store.AiModel) {
+	params := us.GenerationParams().Supported(model)
+	request.Temperature = params.Temperature
+	request.TopP = params.TopP
+	if params.MaxTokens != nil {
+		request.MaxTokens = *params.MaxTokens
+	}
+	if params.ReasoningEffort != nil {
+		request.ReasoningEffort = *params.ReasoningEffort
+	}
+}
+
+// prepareSettings returns the generation settings of the scope and the keyboard to change them.
+// Only the parameters supported by the chat model of the user are offered. In the dialog scope
+// unset parameters show the value of the user.
+func prepareSettings(mc *MainController, us *store.UserShell, scope settingsScope) (string, *tgbotapi.InlineKeyboardMarkup, error) {
+	model, ok := mc.store.AIModelByID(us.User.ChatModelID)
+	if !ok {
+		return "", nil, fmt.Errorf("prepareSettings(): %w", store.ErrIncorrectAIModel)
+	}
+	if us.Dialog == nil {
+		scope = settingsScopeUser
+	}
+
+	params := us.User.Params
+	scopeType := localization.MTypeMsgSettingsUser
+	if scope == settingsScopeDialog {
+		params = us.Dialog.Params
+		scopeType = localization.MTypeMsgSettingsDialog
+	}
+
+	kb := tgbotapi.InlineKeyboardMarkup{}
+	if us.Dialog != nil {
+		kb.InlineKeyboard = append(kb.InlineKeyboard,
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData(
+					fmt.Sprint(scopeEmoji(scope, settingsScopeUser, "👤"), " ", localeText(us.Locale, localization.MTypeBtnSettingsUser)),
+					fmt.Sprint(callbackTypeSettings, ";", us.ID, ";", settingsScopeUser)),
+				tgbotapi.NewInlineKeyboardButtonData(
+					fmt.Sprint(scopeEmoji(scope, settingsScopeDialog, "💬"), " ", localeText(us.Locale, localization.MTypeBtnSettingsDialog)),
+					fmt.Sprint(callbackTypeSettings, ";", us.ID, ";", settingsScopeDialog))))
+	}
+
+	values := make([]any, 0, len(settingsParams))
+	for _, sp := range settingsParams {
+		if sp.capability != 0 && !model.Supports(sp.capability) {
+			values = append(values, localeText(us.Locale, localization.MTypeMsgParamUnsupported))
+			continue
+		}
+
+		value := paramValue(params, sp.param)
+		switch {
+		case value != "":
+			values = append(values, value)
+		case scope == settingsScopeDialog && paramValue(us.User.Params, sp.param) != "":
+			values = append(values, localeText(us.Locale, localization.MTypeMsgParamInherited, paramValue(us.User.Params, sp.param)))
+		default:
+			values = append(values, localeText(us.Locale, localization.MTypeMsgParamDefault))
+		}
+
+		row := tgbotapi.NewInlineKeyboardRow()
+		for _, option := range append([]string{paramUnset}, sp.options...) {
+			emoji := sp.emoji
+			if option == value || (option == paramUnset && value == "") {
+				emoji = "✅"
+			}
+			row = append(row, tgbotapi.NewInlineKeyboardButtonData(
+				fmt.Sprint(emoji, " ", option),
+				fmt.Sprint(callbackTypeSettings, ";", us.ID, ";", scope, ";", sp.param, ";", option)))
+		}
+		kb.InlineKeyboard = append(kb.InlineKeyboard, row)
+	}
+
+	text := localeText(us.Locale, localization.MTypeMsgSettings,
+		append([]any{localeText(us.Locale, scopeType), model.Title}, values...)...)
+	return text, &kb, nil
+}
+
+func scopeEmoji(curScope, scope settingsScope, emoji string) string {
+	if curScope != scope {
+		return emoji
+	}
+	return "✅"
+}
@@ -0,0 +1,59 @@
+package maincontroller
+
+import (
+	"errors"
+	"testing"
+
+	"tgbot/internal/ai"
+	"tgbot/internal/store"
+)
+
+func TestSetParam(t *testing.T) {
+	tests := []struct {
+		param   generationParam
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{param: paramTemperature, value: "0.7", want: "0.7"},
+		{param: paramTemperature, value: "0", want: "0"},
+		{param: paramTemperature, value: "2.5", wantErr: true},
+		{param: paramTemperature, value: "-1", wantErr: true},
+		{param: paramTemperature, value: "NaN", wantErr: true},
+		{param: paramTemperature, value: "hot", wantErr: true},
+		{param: paramTopP, value: "1", want: "1"},
+		{param: paramTopP, value: "0", wantErr: true},
+		{param: paramTopP, value: "1.01", wantErr: true},
+		{param: paramMaxTokens, value: "4096", want: "4096"},
+		{param: paramMaxTokens, value: "0", wantErr: true},
+		{param: paramMaxTokens, value: "1000000", wantErr: true},
+		{param: paramReasoningEffort, value: ai.EffortHigh, want: ai.EffortHigh},
+		{param: paramReasoningEffort, value: "maximum", wantErr: true},
+		{param: paramReasoningEffort, value: "", wantErr: true},
+		{param: paramReasoningEffort, value: paramUnset, want: ""},
+		{param: generationParam(99), value: "1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		prev := store.GenerationParams{}
+		effort := ai.EffortLow
+		prev.ReasoningEffort = &effort
+
+		params, err := setParam(prev, tt.param, tt.value)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("setParam(%d, %q) = %+v, want an error", tt.param, tt.value, params)
+			} else if !errors.Is(err, errInvalidParam) {
+				t.Errorf("setParam(%d, %q) error = %v, want %v", tt.param, tt.value, err, errInvalidParam)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("setParam(%d, %q) error = %v", tt.param, tt.value, err)
+			continue
+		}
+		if got := paramValue(params, tt.param); got != tt.want {
+			t.Errorf("setParam(%d, %q) set %q, want %q", tt.param, tt.value, got, tt.want)
+		}
+	}
+}
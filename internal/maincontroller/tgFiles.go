@@ -0,0 +1,79 @@
+package maincontroller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"tgbot/internal/ai"
+	"tgbot/internal/lib/logger/sl"
+	"tgbot/internal/store"
+)
+
+const TgFileMaxSize = 20 << 20 // bytes, getFile limit of the Bot API
+
+var errTgFileTooBig = errors.New("file is too big")
+
+// downloadTgFile downloads a file sent to the bot through the getFile endpoint
+func (mc *MainController) downloadTgFile(ctx context.Context, fileID string) ([]byte, error) {
+	method := "downloadTgFile()"
+
+	fileURL, err := mc.tgBot.GetFileDirectURL(fileID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", method, err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fileURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", method, stripURL(err))
+	}
+
+	resp, err := mc.tgBot.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", method, stripURL(err))
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: unexpected status %s", method, resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, TgFileMaxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", method, stripURL(err))
+	}
+	if len(data) > TgFileMaxSize {
+		return nil, fmt.Errorf("%s: %w", method, errTgFileTooBig)
+	}
+	return data, nil
+}
+
+// loadDialogImages downloads images of the user messages in the context, images that
+// failed to download are left out
+func (mc *MainController) loadDialogImages(ctx context.Context, dc *DialogContext) map[string]*ai.ImageContent {
+	res := map[string]*ai.ImageContent{}
+	for _, msg := range dc.Messages {
+		if msg.ImageFileID == "" || msg.Role != store.RoleUser {
+			continue
+		}
+		data, err := mc.downloadTgFile(ctx, msg.ImageFileID)
+		if err != nil {
+			mc.log.Error("Could not download image", slog.Int64("Message id", msg.ID), sl.Err(err))
+			continue
+		}
+		res[msg.ImageFileID] = &ai.ImageContent{MIMEType: http.DetectContentType(data), Data: data}
+	}
+	return res
+}
+
+// stripURL removes the file URL from the error, since it contains the bot token
+func stripURL(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Err
+	}
+	return err
+}
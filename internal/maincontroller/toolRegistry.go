@@ -0,0 +1,75 @@
+package maincontroller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"tgbot/internal/ai"
+	"tgbot/internal/store"
+)
+
+const (
+	// MaxToolIterations limits the requests with tools per answer, the next request has
+	// no tools so the model has to answer with text
+	MaxToolIterations = 5
+	// Longer tool results are truncated, in runes
+	toolResultMaxLength = 4000
+)
+
+// ChatTool is a function the chat model can call while answering
+type ChatTool struct {
+	Definition ai.Tool
+	Run        func(ctx context.Context, us *store.UserShell, args json.RawMessage) (string, error)
+}
+
+// ToolRegistry holds the tools offered to chat models that support them
+type ToolRegistry struct {
+	tools map[string]*ChatTool
+	// Registration order, keeps the definitions stable between requests for prompt caching
+	names []string
+}
+
+func NewToolRegistry() *ToolRegistry {
+	return &ToolRegistry{tools: map[string]*ChatTool{}}
+}
+
+func (r *ToolRegistry) Register(tool *ChatTool) {
+	if _, ok := r.tools[tool.Definition.Name]; !ok {
+		r.names = append(r.names, tool.Definition.Name)
+	}
+	r.tools[tool.Definition.Name] = tool
+}
+
+func (r *ToolRegistry) Definitions() []ai.Tool {
+	res := make([]ai.Tool, 0, len(r.names))
+	for _, name := range r.names {
+		res = append(res, r.tools[name].Definition)
+	}
+	return res
+}
+
+// Call runs the tool and returns the result for the model. Errors are returned
+// as the result as well, so the model can fix the arguments or explain the failure.
+func (r *ToolRegistry) Call(ctx context.Context, us *store.UserShell, call ai.ToolCall) string {
+	tool, ok := r.tools[call.Name]
+	if !ok {
+		return fmt.Sprintf("error: unknown tool '%s'", call.Name)
+	}
+
+	args := json.RawMessage(call.Arguments)
+	if len(args) == 0 {
+		args = json.RawMessage("{}")
+	}
+	if !json.Valid(args) {
+		return "error: arguments are not a valid JSON object"
+	}
+
+	res, err := tool.Run(ctx, us, args)
+	if err != nil {
+		return "error: " + err.Error()
+	}
+	if runes := []rune(res); len(runes) > toolResultMaxLength {
+		res = string(runes[:toolResultMaxLength]) + "\n[truncated]"
+	}
+	return res
+}
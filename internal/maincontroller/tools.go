@@ -0,0 +1,292 @@
+package maincontroller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"math"
+	"strconv"
+	"strings"
+	"tgbot/internal/ai"
+	"tgbot/internal/store"
+	"time"
+	// Containers often come without the zoneinfo database
+	_ "time/tzdata"
+)
+
+const searchResultsLimit = 10
+
+// newDefaultTools registers the tools available to every user
+func newDefaultTools(st *store.Store) *ToolRegistry {
+	r := NewToolRegistry()
+	r.Register(calculatorTool())
+	r.Register(currentTimeTool())
+	r.Register(searchDialogsTool(st))
+	r.Register(userProfileTool(st))
+	return r
+}
+
+func calculatorTool() *ChatTool {
+	return &ChatTool{
+		Definition: ai.Tool{
+			Name: "calculator",
+			Description: "Evaluates an arithmetic expression. Supports + - * / %, parentheses, " +
+				"the constants pi and e and the functions sqrt, pow, abs, exp, log, log10, log2, " +
+				"sin, cos, tan, floor, ceil, round, min and max.",
+			Parameters: json.RawMessage(`{"type":"object","properties":{"expression":{"type":"string",` +
+				`"description":"Expression to evaluate, e.g. pow(2, 10) / 3"}},"required":["expression"]}`),
+		},
+		Run: func(_ context.Context, _ *store.UserShell, args json.RawMessage) (string, error) {
+			var params struct {
+				Expression string `json:"expression"`
+			}
+			if err := json.Unmarshal(args, &params); err != nil {
+				return "", err
+			}
+			expr, err := parser.ParseExpr(params.Expression)
+			if err != nil {
+				return "", fmt.Errorf("invalid expression: %w", err)
+			}
+			res, err := evalExpr(expr)
+			if err != nil {
+				return "", err
+			}
+			return strconv.FormatFloat(res, 'g', -1, 64), nil
+		},
+	}
+}
+
+var calcConstants = map[string]float64{
+	"pi": math.Pi,
+	"e":  math.E,
+}
+
+var calcFunctions = map[string]func(args []float64) (float64, error){
+	"sqrt":  unaryFunc(math.Sqrt),
+	"abs":   unaryFunc(math.Abs),
+	"exp":   unaryFunc(math.Exp),
+	"log":   unaryFunc(math.Log),
+	"log10": unaryFunc(math.Log10),
+	"log2":  unaryFunc(math.Log2),
+	"sin":   unaryFunc(math.Sin),
+	"cos":   unaryFunc(math.Cos),
+	"tan":   unaryFunc(math.Tan),
+	"floor": unaryFunc(math.Floor),
+	"ceil":  unaryFunc(math.Ceil),
+	"round": unaryFunc(math.Round),
+	"pow": func(args []float64) (float64, error) {
+		if len(args) != 2 {
+			return 0, errors.New("pow expects 2 arguments")
+		}
+		return math.Pow(args[0], args[1]), nil
+	},
+	"min": func(args []float64) (float64, error) {
+		if len(args) == 0 {
+			return 0, errors.New("min expects arguments")
+		}
+		return reduceFloats(args, math.Min), nil
+	},
+	"max": func(args []float64) (float64, error) {
+		if len(args) == 0 {
+			return 0, errors.New("max expects arguments")
+		}
+		return reduceFloats(args, math.Max), nil
+	},
+}
+
+func unaryFunc(f func(float64) float64) func(args []float64) (float64, error) {
+	return func(args []float64) (float64, error) {
+		if len(args) != 1 {
+			return 0, errors.New("function expects 1 argument")
+		}
+		return f(args[0]), nil
+	}
+}
+
+func reduceFloats(args []float64, f func(a, b float64) float64) float64 {
+	res := args[0]
+	for _, v := range args[1:] {
+		res = f(res, v)
+	}
+	return res
+}
+
+// evalExpr evaluates the arithmetic subset of Go expressions
+func evalExpr(expr ast.Expr) (float64, error) {
+	switch e := expr.(type) {
+	case *ast.BasicLit:
+		switch e.Kind {
+		case token.INT:
+			v, err := strconv.ParseInt(e.Value, 0, 64)
+			return float64(v), err
+		case token.FLOAT:
+			return strconv.ParseFloat(e.Value, 64)
+		}
+	case *ast.Ident:
+		if v, ok := calcConstants[e.Name]; ok {
+			return v, nil
+		}
+		return 0, fmt.Errorf("unknown constant '%s'", e.Name)
+	case *ast.ParenExpr:
+		return evalExpr(e.X)
+	case *ast.UnaryExpr:
+		x, err := evalExpr(e.X)
+		if err != nil {
+			return 0, err
+		}
+		switch e.Op {
+		case token.ADD:
+			return x, nil
+		case token.SUB:
+			return -x, nil
+		}
+	case *ast.BinaryExpr:
+		x, err := evalExpr(e.X)
+		if err != nil {
+			return 0, err
+		}
+		y, err := evalExpr(e.Y)
+		if err != nil {
+			return 0, err
+		}
+		switch e.Op {
+		case token.ADD:
+			return x + y, nil
+		case token.SUB:
+			return x - y, nil
+		case token.MUL:
+			return x * y, nil
+		case token.QUO:
+			if y == 0 {
+				return 0, errors.New("division by zero")
+			}
+			return x / y, nil
+		case token.REM:
+			if y == 0 {
+				return 0, errors.New("division by zero")
+			}
+			return math.Mod(x, y), nil
+		case token.XOR:
+			return 0, errors.New("use pow(x, y) for exponentiation")
+		}
+	case *ast.CallExpr:
+		name, ok := e.Fun.(*ast.Ident)
+		if !ok {
+			break
+		}
+		f, ok := calcFunctions[name.Name]
+		if !ok {
+			return 0, fmt.Errorf("unknown function '%s'", name.Name)
+		}
+		args := make([]float64, 0, len(e.Args))
+		for _, arg := range e.Args {
+			v, err := evalExpr(arg)
+			if err != nil {
+				return 0, err
+			}
+			args = append(args, v)
+		}
+		return f(args)
+	}
+	return 0, errors.New("unsupported expression")
+}
+
+func currentTimeTool() *ChatTool {
+	return &ChatTool{
+		Definition: ai.Tool{
+			Name:        "current_time",
+			Description: "Returns the current date and time.",
+			Parameters: json.RawMessage(`{"type":"object","properties":{"timezone":{"type":"string",` +
+				`"description":"IANA time zone, e.g. Europe/Moscow. UTC if omitted"}}}`),
+		},
+		Run: func(_ context.Context, _ *store.UserShell, args json.RawMessage) (string, error) {
+			var params struct {
+				Timezone string `json:"timezone"`
+			}
+			if err := json.Unmarshal(args, &params); err != nil {
+				return "", err
+			}
+			loc, err := time.LoadLocation(params.Timezone)
+			if err != nil {
+				return "", fmt.Errorf("unknown time zone '%s'", params.Timezone)
+			}
+			return time.Now().In(loc).Format("Monday, 2006-01-02 15:04:05 MST"), nil
+		},
+	}
+}
+
+func searchDialogsTool(st *store.Store) *ChatTool {
+	return &ChatTool{
+		Definition: ai.Tool{
+			Name:        "search_dialogs",
+			Description: "Searches the user's messages and the assistant's answers in all dialogs with the bot, newest first.",
+			Parameters: json.RawMessage(`{"type":"object","properties":{"query":{"type":"string",` +
+				`"description":"Text to search for"}},"required":["query"]}`),
+		},
+		Run: func(ctx context.Context, us *store.UserShell, args json.RawMessage) (string, error) {
+			var params struct {
+				Query string `json:"query"`
+			}
+			if err := json.Unmarshal(args, &params); err != nil {
+				return "", err
+			}
+			if strings.TrimSpace(params.Query) == "" {
+				return "", errors.New("query is empty")
+			}
+			msgs, err := st.SearchMessages(ctx, us.ID, params.Query, searchResultsLimit)
+			if err != nil {
+				return "", errors.New("search failed")
+			}
+			if len(msgs) == 0 {
+				return "nothing found", nil
+			}
+
+			var sb strings.Builder
+			for _, msg := range msgs {
+				fmt.Fprintf(&sb, "dialog %d, %s, %s: %s\n\n",
+					msg.DialogID, msg.Created.Format(time.DateOnly), aiRole(msg.Role), msg.Content)
+			}
+			return sb.String(), nil
+		},
+	}
+}
+
+func userProfileTool(st *store.Store) *ChatTool {
+	return &ChatTool{
+		Definition: ai.Tool{
+			Name:        "user_profile",
+			Description: "Returns the user's tariff, AI models and the usage of today's limits.",
+			Parameters:  json.RawMessage(`{"type":"object","properties":{}}`),
+		},
+		Run: func(_ context.Context, us *store.UserShell, _ json.RawMessage) (string, error) {
+			tariff, ok := st.TariffByID(us.User.TariffID)
+			if !ok {
+				return "", store.ErrIncorrectTariff
+			}
+
+			var sb strings.Builder
+			fmt.Fprintf(&sb, "Tariff: %s\n", tariff.Tariff.Title)
+			if model, ok := st.AIModelByID(us.User.ChatModelID); ok {
+				fmt.Fprintf(&sb, "Chat model: %s\n", model.Title)
+			}
+			if model, ok := st.AIModelByID(us.User.ImageModelID); ok {
+				fmt.Fprintf(&sb, "Image model: %s\n", model.Title)
+			}
+
+			var usage []*store.UserUsage
+			us.Usage.Range(func(_, v any) bool {
+				if val, ok := v.(*store.UserUsage); ok {
+					usage = append(usage, val)
+				}
+				return true
+			})
+			sb.WriteString("Usage today (used/limit):\n")
+			sb.WriteString(st.UserUsageToString(tariff.Limits, usage, "tokens"))
+			return sb.String(), nil
+		},
+	}
+}
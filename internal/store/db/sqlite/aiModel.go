@@ -23,7 +23,40 @@ func (d *DB) AiModelList(ctx context.Context) ([]*store.AiModel, error) {
 			&entity.ID,
 			&entity.Title,
 			&entity.APIName,
-			&entity.ModelType)
+			&entity.ModelType,
+			&entity.Provider,
+			&entity.Capabilities)
+		if err != nil {
+			return nil, common.WrapErrors(method, store.ErrDBScanRowError, err)
+		}
+		list = append(list, &entity)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, common.WrapErrors(method, store.ErrDBRowError, err)
+	}
+
+	return list, nil
+}
+
+func (d *DB) AiModelFallbackList(ctx context.Context) ([]*store.AiModelFallback, error) {
+	method := "AiModelFallbackList()"
+	q := `SELECT * FROM aiModelFallbacks ORDER BY aiModelId, position`
+
+	rows, err := d.db.QueryContext(ctx, q)
+	if err != nil {
+		return nil, common.WrapErrors(method, store.ErrDBQueryError, err)
+	}
+	defer closeRows(rows)
+
+	list := make([]*store.AiModelFallback, 0)
+	for rows.Next() {
+		var entity store.AiModelFallback
+		err := rows.Scan(
+			&entity.ID,
+			&entity.AIModelID,
+			&entity.FallbackID,
+			&entity.Position)
 		if err != nil {
 			return nil, common.WrapErrors(method, store.ErrDBScanRowError, err)
 		}
@@ -0,0 +1,109 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"tgbot/common"
+	"tgbot/internal/store"
+	"time"
+)
+
+func (d *DB) AttachmentCreate(ctx context.Context, entity *store.Attachment) (*store.Attachment, error) {
+	fields := []string{"chatMessageId", "fileId", "fileName", "mimeType", "size", "truncated", "created"}
+	args := []any{entity.ChatMessageID, entity.FileID, entity.FileName, entity.MIMEType, entity.Size, entity.Truncated, entity.Created}
+
+	q := "INSERT INTO attachments (" + strings.Join(fields, ", ") + ") VALUES (" + placeholders(len(fields)) + ") RETURNING id"
+
+	if err := d.db.QueryRowContext(ctx, q, args...).Scan(
+		&entity.ID,
+	); err != nil {
+		return nil, common.WrapErrors("AttachmentCreate()", store.ErrDBQueryError, err)
+	}
+
+	return entity, nil
+}
+
+func (d *DB) AttachmentList(ctx context.Context, filter *store.AttachmentFilter) ([]*store.Attachment, error) {
+	method := "AttachmentList()"
+	where, args := []string{"1 = 1"}, []any{}
+
+	if filter.ID != nil {
+		where, args = append(where, "id = ?"), append(args, filter.ID)
+	}
+	if filter.ChatMessageID != nil {
+		where, args = append(where, "chatMessageId = ?"), append(args, filter.ChatMessageID)
+	}
+	if filter.FileID != nil {
+		where, args = append(where, "fileId = ?"), append(args, filter.FileID)
+	}
+
+	q := `
+		SELECT *
+		FROM attachments
+		WHERE ` + strings.Join(where, " AND ")
+
+	rows, err := d.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, common.WrapErrors(method, store.ErrDBQueryError, err)
+	}
+	defer closeRows(rows)
+
+	list := make([]*store.Attachment, 0)
+	for rows.Next() {
+		var entity store.Attachment
+		var created string
+		if err := rows.Scan(
+			&entity.ID, &entity.ChatMessageID, &entity.FileID, &entity.FileName, &entity.MIMEType,
+			&entity.Size, &entity.Truncated, &created,
+		); err != nil {
+			return nil, common.WrapErrors(method, store.ErrDBScanRowError, err)
+		}
+
+		entity.Created, err = time.Parse(dateLayout(), created)
+		if err != nil {
+			return nil, fmt.Errorf("%s: failed to parse created: %w", method, err)
+		}
+		list = append(list, &entity)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, common.WrapErrors(method, store.ErrDBRowError, err)
+	}
+
+	return list, nil
+}
+
+func (d *DB) AttachmentDelete(ctx context.Context, filter *store.AttachmentFilter) error {
+	method := "AttachmentDelete()"
+	where, args := []string{}, []any{}
+
+	if filter.ID != nil {
+		where, args = append(where, "id = ?"), append(args, filter.ID)
+	}
+	if filter.ChatMessageID != nil {
+		where, args = append(where, "chatMessageId = ?"), append(args, filter.ChatMessageID)
+	}
+	if filter.FileID != nil {
+		where, args = append(where, "fileId = ?"), append(args, filter.FileID)
+	}
+
+	if len(where) == 0 {
+		return common.WrapErrors(method, store.ErrDBNoFilterProvided)
+	}
+
+	q := `
+		DELETE
+		FROM attachments
+		WHERE ` + strings.Join(where, " AND ")
+
+	result, err := d.db.ExecContext(ctx, q, args...)
+	if err != nil {
+		return common.WrapErrors(method, store.ErrDBQueryError, err)
+	}
+	if _, err := result.RowsAffected(); err != nil {
+		return common.WrapErrors(method, store.ErrDBNoRowsAffected)
+	}
+
+	return nil
+}
@@ -2,6 +2,7 @@ package sqlite
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"strings"
 	"tgbot/common"
@@ -10,8 +11,8 @@ import (
 )
 
 func (d *DB) ChatMessageCreate(ctx context.Context, entity *store.ChatMessage) (*store.ChatMessage, error) {
-	fields := []string{"dialogId", "\"order\"", "\"role\"", "content", "created"}
-	args := []any{entity.DialogID, entity.Order, entity.Role, entity.Content, entity.Created}
+	fields := []string{"dialogId", "\"order\"", "\"role\"", "content", "created", "promptTokens", "completionTokens", "cachedTokens", "reasoningTokens", "summaryUntil", "imageFileId", "toolCallId", "aiModelId"}
+	args := []any{entity.DialogID, entity.Order, entity.Role, entity.Content, entity.Created, entity.PromptTokens, entity.CompletionTokens, entity.CachedTokens, entity.ReasoningTokens, entity.SummaryUntil, entity.ImageFileID, entity.ToolCallID, entity.AIModelID}
 
 	q := "INSERT INTO chatMessages (" + strings.Join(fields, ", ") + ") VALUES (" + placeholders(len(fields)) + ") RETURNING id"
 
@@ -58,12 +59,41 @@ func (d *DB) ChatMessageList(ctx context.Context, filter *store.ChatMessageFilte
 	}
 	defer closeRows(rows)
 
+	return scanChatMessages(rows, method)
+}
+
+// ChatMessageSearch finds user and assistant messages of the user's dialogs containing the query, newest first
+func (d *DB) ChatMessageSearch(ctx context.Context, userID int64, query string, limit int) ([]*store.ChatMessage, error) {
+	method := "ChatMessageSearch()"
+	pattern := "%" + likeEscaper.Replace(query) + "%"
+
+	q := `
+		SELECT m.*
+		FROM chatMessages m
+		JOIN dialogs d ON d.id = m.dialogId
+		WHERE d.userId = ? AND m."role" IN (?, ?) AND m.content LIKE ? ESCAPE '\'
+		ORDER BY m.id DESC
+		LIMIT ?`
+
+	rows, err := d.db.QueryContext(ctx, q, userID, store.RoleUser, store.RoleAssistant, pattern, limit)
+	if err != nil {
+		return nil, common.WrapErrors(method, store.ErrDBQueryError, err)
+	}
+	defer closeRows(rows)
+
+	return scanChatMessages(rows, method)
+}
+
+func scanChatMessages(rows *sql.Rows, method string) ([]*store.ChatMessage, error) {
+	var err error
 	list := make([]*store.ChatMessage, 0)
 	for rows.Next() {
 		var entity store.ChatMessage
 		var created string
 		if err := rows.Scan(
 			&entity.ID, &entity.DialogID, &entity.Order, &entity.Role, &entity.Content, &created,
+			&entity.PromptTokens, &entity.CompletionTokens, &entity.CachedTokens, &entity.ReasoningTokens, &entity.SummaryUntil,
+			&entity.ImageFileID, &entity.ToolCallID, &entity.AIModelID,
 		); err != nil {
 			return nil, common.WrapErrors(method, store.ErrDBScanRowError, err)
 		}
@@ -89,7 +119,15 @@ func (d *DB) ChatMessageUpdate(ctx context.Context, entity *store.ChatMessage) (
 				"order" = ?,
 				"role" = ?,
 				content = ?,
-				created = ?
+				created = ?,
+				promptTokens = ?,
+				completionTokens = ?,
+				cachedTokens = ?,
+				reasoningTokens = ?,
+				summaryUntil = ?,
+				imageFileId = ?,
+				toolCallId = ?,
+				aiModelId = ?
 			WHERE
 				id = ?;`
 
@@ -99,6 +137,14 @@ func (d *DB) ChatMessageUpdate(ctx context.Context, entity *store.ChatMessage) (
 		entity.Role,
 		entity.Content,
 		entity.Created,
+		entity.PromptTokens,
+		entity.CompletionTokens,
+		entity.CachedTokens,
+		entity.ReasoningTokens,
+		entity.SummaryUntil,
+		entity.ImageFileID,
+		entity.ToolCallID,
+		entity.AIModelID,
 		entity.ID)
 	if err != nil {
 		return nil, common.WrapErrors("ChatMessageUpdate()", store.ErrDBQueryError, err)
@@ -26,6 +26,8 @@ func placeholders(n int) string {
 	return strings.Join(list, ", ")
 }
 
+var likeEscaper = strings.NewReplacer("\\", "\\\\", "%", "\\%", "_", "\\_")
+
 func closeRows(rows *sql.Rows) {
 	_ = rows.Close()
 }
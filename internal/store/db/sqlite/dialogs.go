@@ -10,8 +10,9 @@ import (
 )
 
 func (d *DB) DialogCreate(ctx context.Context, entity *store.Dialog) (*store.Dialog, error) {
-	fields := []string{"userId", "title", "created"}
-	args := []any{entity.UserID, entity.Title, entity.Created}
+	fields := []string{"userId", "title", "created", "personaId", "temperature", "topP", "maxTokens", "reasoningEffort"}
+	args := []any{entity.UserID, entity.Title, entity.Created, entity.PersonaID,
+		entity.Params.Temperature, entity.Params.TopP, entity.Params.MaxTokens, entity.Params.ReasoningEffort}
 
 	q := "INSERT INTO dialogs (" + strings.Join(fields, ", ") + ") VALUES (" + placeholders(len(fields)) + ") RETURNING id"
 
@@ -79,6 +80,11 @@ func (d *DB) DialogList(ctx context.Context, filter *store.DialogFilter) (int, [
 			&entity.UserID,
 			&entity.Title,
 			&created,
+			&entity.PersonaID,
+			&entity.Params.Temperature,
+			&entity.Params.TopP,
+			&entity.Params.MaxTokens,
+			&entity.Params.ReasoningEffort,
 		); err != nil {
 			return 0, nil, common.WrapErrors(method, store.ErrDBScanRowError, err)
 		}
@@ -101,7 +107,12 @@ func (d *DB) DialogUpdate(ctx context.Context, entity *store.Dialog) (*store.Dia
 			SET
 				userId = ?,
 				title = ?,
-				created = ?
+				created = ?,
+				personaId = ?,
+				temperature = ?,
+				topP = ?,
+				maxTokens = ?,
+				reasoningEffort = ?
 			WHERE
 				id = ?;`
 
@@ -109,6 +120,11 @@ func (d *DB) DialogUpdate(ctx context.Context, entity *store.Dialog) (*store.Dia
 		entity.UserID,
 		entity.Title,
 		entity.Created,
+		entity.PersonaID,
+		entity.Params.Temperature,
+		entity.Params.TopP,
+		entity.Params.MaxTokens,
+		entity.Params.ReasoningEffort,
 		entity.ID)
 	if err != nil {
 		return nil, common.WrapErrors("DialogUpdate()", store.ErrDBQueryError, err)
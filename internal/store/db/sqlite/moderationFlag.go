@@ -0,0 +1,75 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"tgbot/common"
+	"tgbot/internal/store"
+	"time"
+)
+
+func (d *DB) ModerationFlagCreate(ctx context.Context, entity *store.ModerationFlag) (*store.ModerationFlag, error) {
+	fields := []string{"userId", "source", "categories", "content", "created"}
+	args := []any{entity.UserID, entity.Source, entity.Categories, entity.Content, entity.Created}
+
+	q := "INSERT INTO moderationFlags (" + strings.Join(fields, ", ") + ") VALUES (" + placeholders(len(fields)) + ") RETURNING id"
+
+	if err := d.db.QueryRowContext(ctx, q, args...).Scan(
+		&entity.ID,
+	); err != nil {
+		return nil, common.WrapErrors("ModerationFlagCreate()", store.ErrDBQueryError, err)
+	}
+
+	return entity, nil
+}
+
+func (d *DB) ModerationFlagList(ctx context.Context, filter *store.ModerationFlagFilter) ([]*store.ModerationFlag, error) {
+	method := "ModerationFlagList()"
+	where, args := []string{"1 = 1"}, []any{}
+
+	if filter.UserID != nil {
+		where, args = append(where, "userId = ?"), append(args, filter.UserID)
+	}
+	if filter.Source != nil {
+		where, args = append(where, "source = ?"), append(args, filter.Source)
+	}
+	if filter.Since != nil {
+		where, args = append(where, "created >= ?"), append(args, filter.Since.UTC())
+	}
+
+	q := `
+		SELECT *
+		FROM moderationFlags
+		WHERE ` + strings.Join(where, " AND ") + `
+		ORDER BY id`
+
+	rows, err := d.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, common.WrapErrors(method, store.ErrDBQueryError, err)
+	}
+	defer closeRows(rows)
+
+	list := make([]*store.ModerationFlag, 0)
+	for rows.Next() {
+		var entity store.ModerationFlag
+		var created string
+		if err := rows.Scan(
+			&entity.ID, &entity.UserID, &entity.Source, &entity.Categories, &entity.Content, &created,
+		); err != nil {
+			return nil, common.WrapErrors(method, store.ErrDBScanRowError, err)
+		}
+
+		entity.Created, err = time.Parse(dateLayout(), created)
+		if err != nil {
+			return nil, fmt.Errorf("%s: failed to parse created: %w", method, err)
+		}
+		list = append(list, &entity)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, common.WrapErrors(method, store.ErrDBRowError, err)
+	}
+
+	return list, nil
+}
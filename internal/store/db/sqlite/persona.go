@@ -0,0 +1,123 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"tgbot/common"
+	"tgbot/internal/store"
+	"time"
+)
+
+func (d *DB) PersonaCreate(ctx context.Context, entity *store.Persona) (*store.Persona, error) {
+	fields := []string{"userId", "title", "prompt", "created"}
+	args := []any{entity.UserID, entity.Title, entity.Prompt, entity.Created}
+
+	q := "INSERT INTO personas (" + strings.Join(fields, ", ") + ") VALUES (" + placeholders(len(fields)) + ") RETURNING id"
+
+	if err := d.db.QueryRowContext(ctx, q, args...).Scan(
+		&entity.ID,
+	); err != nil {
+		return nil, common.WrapErrors("PersonaCreate()", store.ErrDBQueryError, err)
+	}
+
+	return entity, nil
+}
+
+func (d *DB) PersonaList(ctx context.Context, filter *store.PersonaFilter) ([]*store.Persona, error) {
+	method := "PersonaList()"
+	where, args := personaWhere(filter)
+
+	q := `
+		SELECT *
+		FROM personas
+		WHERE ` + strings.Join(append(where, "1 = 1"), " AND ") + `
+		ORDER BY userId, id`
+
+	rows, err := d.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, common.WrapErrors(method, store.ErrDBQueryError, err)
+	}
+	defer closeRows(rows)
+
+	list := make([]*store.Persona, 0)
+	for rows.Next() {
+		var entity store.Persona
+		var created string
+		if err := rows.Scan(
+			&entity.ID, &entity.UserID, &entity.Title, &entity.Prompt, &created,
+		); err != nil {
+			return nil, common.WrapErrors(method, store.ErrDBScanRowError, err)
+		}
+
+		entity.Created, err = time.Parse(dateLayout(), created)
+		if err != nil {
+			return nil, fmt.Errorf("%s: failed to parse created: %w", method, err)
+		}
+		list = append(list, &entity)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, common.WrapErrors(method, store.ErrDBRowError, err)
+	}
+
+	return list, nil
+}
+
+func (d *DB) PersonaDelete(ctx context.Context, filter *store.PersonaFilter) error {
+	method := "PersonaDelete()"
+	where, args := personaWhere(filter)
+
+	if len(where) == 0 {
+		return common.WrapErrors(method, store.ErrDBNoFilterProvided)
+	}
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return common.WrapErrors(method, store.ErrDBQueryError, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// Dialogs with the deleted personas are left without one
+	q := `
+		UPDATE dialogs
+		SET personaId = 0
+		WHERE personaId IN (SELECT id FROM personas WHERE ` + strings.Join(where, " AND ") + `)`
+	if _, err := tx.ExecContext(ctx, q, args...); err != nil {
+		return common.WrapErrors(method, store.ErrDBQueryError, err)
+	}
+
+	q = `
+		DELETE
+		FROM personas
+		WHERE ` + strings.Join(where, " AND ")
+
+	result, err := tx.ExecContext(ctx, q, args...)
+	if err != nil {
+		return common.WrapErrors(method, store.ErrDBQueryError, err)
+	}
+	if _, err := result.RowsAffected(); err != nil {
+		return common.WrapErrors(method, store.ErrDBNoRowsAffected)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return common.WrapErrors(method, store.ErrDBQueryError, err)
+	}
+	return nil
+}
+
+func personaWhere(filter *store.PersonaFilter) ([]string, []any) {
+	where, args := []string{}, []any{}
+
+	if filter.ID != nil {
+		where, args = append(where, "id = ?"), append(args, filter.ID)
+	}
+	if len(filter.UserIDs) > 0 {
+		in := strings.TrimSuffix(strings.Repeat("?, ", len(filter.UserIDs)), ", ")
+		where = append(where, "userId IN ("+in+")")
+		for _, id := range filter.UserIDs {
+			args = append(args, id)
+		}
+	}
+	return where, args
+}
@@ -8,8 +8,8 @@ import (
 )
 
 func (d *DB) TariffLimitCreate(ctx context.Context, entity *store.TariffLimit) (*store.TariffLimit, error) {
-	fields := []string{"tariffId", "aiModelId", "count"}
-	args := []any{entity.TariffID, entity.AIModelID, entity.Count}
+	fields := []string{"tariffId", "aiModelId", "count", "tokens"}
+	args := []any{entity.TariffID, entity.AIModelID, entity.Count, entity.Tokens}
 
 	q := "INSERT INTO tariffLimits (" + strings.Join(fields, ", ") + ") VALUES (" + placeholders(len(fields)) + ") RETURNING id"
 
@@ -38,6 +38,9 @@ func (d *DB) TariffLimitList(ctx context.Context, filter *store.TariffLimitFilte
 	if filter.Count != nil {
 		where, args = append(where, "count = ?"), append(args, filter.Count)
 	}
+	if filter.Tokens != nil {
+		where, args = append(where, "tokens = ?"), append(args, filter.Tokens)
+	}
 
 	q := `
 		SELECT *	
@@ -58,6 +61,7 @@ func (d *DB) TariffLimitList(ctx context.Context, filter *store.TariffLimitFilte
 			&entity.TariffID,
 			&entity.AIModelID,
 			&entity.Count,
+			&entity.Tokens,
 		); err != nil {
 			return nil, common.WrapErrors(method, store.ErrDBScanRowError, err)
 		}
@@ -76,7 +80,8 @@ func (d *DB) TariffLimitUpdate(ctx context.Context, entity *store.TariffLimit) (
 			SET
 				tariffId = ?,
 				aiModelId = ?,
-				count = ?
+				count = ?,
+				tokens = ?
 			WHERE
 				id = ?;`
 
@@ -84,6 +89,7 @@ func (d *DB) TariffLimitUpdate(ctx context.Context, entity *store.TariffLimit) (
 		entity.TariffID,
 		entity.AIModelID,
 		entity.Count,
+		entity.Tokens,
 		entity.ID)
 	if err != nil {
 		return nil, common.WrapErrors("TariffLimitUpdate()", store.ErrDBQueryError, err)
@@ -107,6 +113,9 @@ func (d *DB) TariffLimitDelete(ctx context.Context, filter *store.TariffLimitFil
 	if filter.Count != nil {
 		where, args = append(where, "count = ?"), append(args, filter.Count)
 	}
+	if filter.Tokens != nil {
+		where, args = append(where, "tokens = ?"), append(args, filter.Tokens)
+	}
 
 	if len(where) == 0 {
 		return common.WrapErrors(method, store.ErrDBNoFilterProvided)
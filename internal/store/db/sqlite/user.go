@@ -56,6 +56,9 @@ func (d *DB) UserList(ctx context.Context, filter *store.UserFilter) ([]*store.U
 	if filter.SkipNewDialogMessage != nil {
 		where, args = append(where, "skipNewDialogMessage = ?"), append(args, filter.SkipNewDialogMessage)
 	}
+	if filter.SendVoiceAnswer != nil {
+		where, args = append(where, "sendVoiceAnswer = ?"), append(args, filter.SendVoiceAnswer)
+	}
 
 	q := `
 		SELECT *		
@@ -82,6 +85,11 @@ func (d *DB) UserList(ctx context.Context, filter *store.UserFilter) ([]*store.U
 			&entity.Blocked,
 			&entity.BlockReason,
 			&entity.SkipNewDialogMessage,
+			&entity.SendVoiceAnswer,
+			&entity.Params.Temperature,
+			&entity.Params.TopP,
+			&entity.Params.MaxTokens,
+			&entity.Params.ReasoningEffort,
 		); err != nil {
 			return nil, common.WrapErrors(method, store.ErrDBScanRowError, err)
 		}
@@ -109,7 +117,12 @@ func (d *DB) UserUpdate(ctx context.Context, entity *store.User) (*store.User, e
 				selfBlock = ?,
 				blocked = ?,
 				blockReason = ?,
-				skipNewDialogMessage = ?
+				skipNewDialogMessage = ?,
+				sendVoiceAnswer = ?,
+				temperature = ?,
+				topP = ?,
+				maxTokens = ?,
+				reasoningEffort = ?
 			WHERE
 				id = ?;`
 	_, err := d.db.ExecContext(ctx, q,
@@ -121,6 +134,11 @@ func (d *DB) UserUpdate(ctx context.Context, entity *store.User) (*store.User, e
 		entity.Blocked,
 		entity.BlockReason,
 		entity.SkipNewDialogMessage,
+		entity.SendVoiceAnswer,
+		entity.Params.Temperature,
+		entity.Params.TopP,
+		entity.Params.MaxTokens,
+		entity.Params.ReasoningEffort,
 		entity.ID)
 	if err != nil {
 		return nil, common.WrapErrors("UserUpdate()", store.ErrDBQueryError, err)
@@ -159,6 +177,9 @@ func (d *DB) UserDelete(ctx context.Context, filter *store.UserFilter) error {
 	if filter.SkipNewDialogMessage != nil {
 		where, args = append(where, "skipNewDialogMessage = ?"), append(args, filter.SkipNewDialogMessage)
 	}
+	if filter.SendVoiceAnswer != nil {
+		where, args = append(where, "sendVoiceAnswer = ?"), append(args, filter.SendVoiceAnswer)
+	}
 
 	if len(where) == 0 {
 		return common.WrapErrors(method, store.ErrDBNoFilterProvided)
@@ -10,8 +10,8 @@ import (
 )
 
 func (d *DB) UserUsageCreate(ctx context.Context, entity *store.UserUsage) (*store.UserUsage, error) {
-	fields := []string{"userId", "aiModelId", "count"}
-	args := []any{entity.UserID, entity.AIModelID, entity.Count}
+	fields := []string{"userId", "aiModelId", "count", "tokens"}
+	args := []any{entity.UserID, entity.AIModelID, entity.Count, entity.Tokens}
 
 	q := "INSERT INTO usersUsage (" + strings.Join(fields, ", ") + ") VALUES (" + placeholders(len(fields)) + ")"
 
@@ -38,6 +38,9 @@ func (d *DB) UserUsageList(ctx context.Context, filter *store.UserUsageFilter) (
 	if filter.LastActivity != nil {
 		where, args = append(where, "lastActivity = ?"), append(args, filter.LastActivity)
 	}
+	if filter.Tokens != nil {
+		where, args = append(where, "tokens = ?"), append(args, filter.Tokens)
+	}
 
 	q := `
 		SELECT *	
@@ -59,6 +62,7 @@ func (d *DB) UserUsageList(ctx context.Context, filter *store.UserUsageFilter) (
 			&entity.AIModelID,
 			&entity.Count,
 			&lastActivity,
+			&entity.Tokens,
 		); err != nil {
 			return nil, common.WrapErrors(method, store.ErrDBScanRowError, err)
 		}
@@ -80,13 +84,15 @@ func (d *DB) UserUsageUpdate(ctx context.Context, entity *store.UserUsage) (*sto
 	q := `UPDATE usersUsage
 			SET
 				count = ?,
-				lastActivity = ?
+				lastActivity = ?,
+				tokens = ?
 			WHERE
 				userId = ? AND aiModelId = ?;`
 
 	_, err := d.db.ExecContext(ctx, q,
 		entity.Count,
 		entity.LastActivity,
+		entity.Tokens,
 		entity.UserID,
 		entity.AIModelID)
 	if err != nil {
@@ -111,6 +117,9 @@ func (d *DB) UserUsageDelete(ctx context.Context, filter *store.UserUsageFilter)
 	if filter.LastActivity != nil {
 		where, args = append(where, "lastActivity = ?"), append(args, filter.LastActivity)
 	}
+	if filter.Tokens != nil {
+		where, args = append(where, "tokens = ?"), append(args, filter.Tokens)
+	}
 
 	if len(where) == 0 {
 		return common.WrapErrors(method, store.ErrDBNoFilterProvided)
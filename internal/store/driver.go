@@ -44,9 +44,25 @@ type Driver interface {
 	ChatMessageList(ctx context.Context, filter *ChatMessageFilter) ([]*ChatMessage, error)
 	ChatMessageUpdate(ctx context.Context, entity *ChatMessage) (*ChatMessage, error)
 	ChatMessageDelete(ctx context.Context, entity *ChatMessageFilter) error
+	ChatMessageSearch(ctx context.Context, userID int64, query string, limit int) ([]*ChatMessage, error)
+
+	// Attachments
+	AttachmentCreate(ctx context.Context, entity *Attachment) (*Attachment, error)
+	AttachmentList(ctx context.Context, filter *AttachmentFilter) ([]*Attachment, error)
+	AttachmentDelete(ctx context.Context, filter *AttachmentFilter) error
+
+	// Personas
+	PersonaCreate(ctx context.Context, entity *Persona) (*Persona, error)
+	PersonaList(ctx context.Context, filter *PersonaFilter) ([]*Persona, error)
+	PersonaDelete(ctx context.Context, filter *PersonaFilter) error
+
+	// ModerationFlags
+	ModerationFlagCreate(ctx context.Context, entity *ModerationFlag) (*ModerationFlag, error)
+	ModerationFlagList(ctx context.Context, filter *ModerationFlagFilter) ([]*ModerationFlag, error)
 
 	// AiModels
 	AiModelList(ctx context.Context) ([]*AiModel, error)
+	AiModelFallbackList(ctx context.Context) ([]*AiModelFallback, error)
 
 	// Tariffs
 	TariffCreate(ctx context.Context, entity *Tariff) (*Tariff, error)
@@ -19,15 +19,21 @@ type Store struct {
 }
 
 const (
-	DefaultAIChatModelID    int32 = 1
-	DefaultAIImageModelID   int32 = 2
-	DefaultTariffID         int32 = 1
-	TgCheckNewDialogTimeout       = 3600 * time.Second
+	DefaultAIChatModelID         int32 = 1
+	DefaultAIImageModelID        int32 = 2
+	DefaultAISpeechToTextModelID int32 = 3
+	DefaultAITextToSpeechModelID int32 = 4
+	DefaultTariffID              int32 = 1
+	TgCheckNewDialogTimeout            = 3600 * time.Second
 )
 
 var (
 	ErrIncorrectTariff  = errors.New("incorrect tariff")
 	ErrIncorrectAIModel = errors.New("incorrect AI model")
+	ErrModelNotInTariff = errors.New("AI model is not available in tariff")
+	ErrPersonaNotFound  = errors.New("persona not found")
+	ErrInvalidParams    = errors.New("invalid generation parameters")
+	ErrNoActiveDialog   = errors.New("no active dialog")
 )
 
 func New(driver Driver) (*Store, error) {
@@ -57,7 +63,16 @@ func (s *Store) LoadDefaultValues() error {
 	if err != nil {
 		return fmt.Errorf("failed create Store: %w", err)
 	}
+	fallbacks, err := s.driver.AiModelFallbackList(context.TODO())
+	if err != nil {
+		return fmt.Errorf("failed create Store: %w", err)
+	}
 	for _, model := range aiModels {
+		for _, fallback := range fallbacks {
+			if fallback.AIModelID == model.ID {
+				model.Fallbacks = append(model.Fallbacks, fallback.FallbackID)
+			}
+		}
 		s.aiModels.Store(model.ID, model)
 	}
 	if _, ok := s.aiModels.Load(DefaultAIChatModelID); !ok {
@@ -191,11 +206,17 @@ func (s *Store) UserDialogs(ctx context.Context, filter *DialogFilter) (int, []*
 	return count, dialogs, nil
 }
 
+// AddDialog creates the dialog and makes it active. Dialogs without a persona
+// get the persona the user has chosen for the next dialog.
 func (s *Store) AddDialog(ctx context.Context, user *UserShell, dialog *Dialog) (*Dialog, error) {
+	if dialog.PersonaID == 0 {
+		dialog.PersonaID = user.NextPersonaID
+	}
 	dialog, err := s.driver.DialogCreate(ctx, dialog)
 	if err != nil {
 		return nil, err
 	}
+	user.NextPersonaID = 0
 
 	_, err = s.driver.ActiveDialogUpsert(ctx, &ActiveDialog{UserID: user.ID, DialogID: dialog.ID})
 	if err != nil {
@@ -264,6 +285,67 @@ func (s *Store) ResetActiveDialog(ctx context.Context, user *UserShell) error {
 	return nil
 }
 
+// Personas returns the built-in personas followed by the personas of the user
+func (s *Store) Personas(ctx context.Context, userID int64) ([]*Persona, error) {
+	personas, err := s.driver.PersonaList(ctx, &PersonaFilter{UserIDs: []int64{0, userID}})
+	if err != nil {
+		return nil, fmt.Errorf("Personas(): %w", err)
+	}
+	return personas, nil
+}
+
+// PersonaByID returns a built-in persona or a persona of the user
+func (s *Store) PersonaByID(ctx context.Context, userID, personaID int64) (*Persona, error) {
+	personas, err := s.driver.PersonaList(ctx, &PersonaFilter{ID: &personaID, UserIDs: []int64{0, userID}})
+	if err != nil {
+		return nil, fmt.Errorf("PersonaByID(): %w", err)
+	}
+	if len(personas) == 0 {
+		return nil, fmt.Errorf("PersonaByID(): %w: %d", ErrPersonaNotFound, personaID)
+	}
+	return personas[0], nil
+}
+
+func (s *Store) AddPersona(ctx context.Context, persona *Persona) (*Persona, error) {
+	persona, err := s.driver.PersonaCreate(ctx, persona)
+	if err != nil {
+		return nil, fmt.Errorf("AddPersona(): %w", err)
+	}
+	return persona, nil
+}
+
+// DeletePersona deletes a persona of the user, dialogs with the persona are left without one.
+// The cached dialog of the user is changed too, the others are read from the database.
+func (s *Store) DeletePersona(ctx context.Context, user *UserShell, personaID int64) error {
+	err := s.driver.PersonaDelete(ctx, &PersonaFilter{ID: &personaID, UserIDs: []int64{user.ID}})
+	if err != nil {
+		return fmt.Errorf("DeletePersona(): %w", err)
+	}
+	if user.Dialog != nil && user.Dialog.PersonaID == personaID {
+		user.Dialog.PersonaID = 0
+	}
+	if user.NextPersonaID == personaID {
+		user.NextPersonaID = 0
+	}
+	return nil
+}
+
+// SetPersona sets the persona of the active dialog, or of the next dialog if there is no active one
+func (s *Store) SetPersona(ctx context.Context, user *UserShell, personaID int64) error {
+	if user.Dialog == nil {
+		user.NextPersonaID = personaID
+		return nil
+	}
+
+	prev := user.Dialog.PersonaID
+	user.Dialog.PersonaID = personaID
+	if _, err := s.driver.DialogUpdate(ctx, user.Dialog); err != nil {
+		user.Dialog.PersonaID = prev
+		return fmt.Errorf("SetPersona(): %w", err)
+	}
+	return nil
+}
+
 func (s *Store) AddNewMessage(ctx context.Context, user *UserShell, msg *ChatMessage) (*ChatMessage, error) {
 	msg, err := s.driver.ChatMessageCreate(ctx, msg)
 	if err != nil {
@@ -274,6 +356,22 @@ func (s *Store) AddNewMessage(ctx context.Context, user *UserShell, msg *ChatMes
 	return msg, nil
 }
 
+func (s *Store) SearchMessages(ctx context.Context, userID int64, query string, limit int) ([]*ChatMessage, error) {
+	msgs, err := s.driver.ChatMessageSearch(ctx, userID, query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("SearchMessages(): %w", err)
+	}
+	return msgs, nil
+}
+
+func (s *Store) AddAttachment(ctx context.Context, attachment *Attachment) (*Attachment, error) {
+	attachment, err := s.driver.AttachmentCreate(ctx, attachment)
+	if err != nil {
+		return nil, fmt.Errorf("AddAttachment(): %w", err)
+	}
+	return attachment, nil
+}
+
 func (s *Store) Tariffs() []*TariffShell {
 	var result []*TariffShell
 	s.tariffs.Range(func(_, value any) bool {
@@ -287,15 +385,21 @@ func (s *Store) Tariffs() []*TariffShell {
 
 func (s *Store) TariffByID(id int32) (*TariffShell, bool) {
 	trf, ok := s.tariffs.Load(id)
+	if !ok {
+		return nil, false
+	}
 	return trf.(*TariffShell), ok
 }
 
 func (s *Store) AIModelByID(id int32) (*AiModel, bool) {
 	model, ok := s.aiModels.Load(id)
+	if !ok {
+		return nil, false
+	}
 	return model.(*AiModel), ok
 }
 
-func (s *Store) UserUsageToString(tariffLimits []*TariffLimit, userUsage []*UserUsage) string {
+func (s *Store) UserUsageToString(tariffLimits []*TariffLimit, userUsage []*UserUsage, tokensLabel string) string {
 	if len(tariffLimits) == 0 {
 		return ""
 	}
@@ -303,16 +407,29 @@ func (s *Store) UserUsageToString(tariffLimits []*TariffLimit, userUsage []*User
 	for _, limit := range tariffLimits {
 		model, _ := s.AIModelByID(limit.AIModelID)
 		var usageCount int32 = 0
+		var usageTokens int64 = 0
 		for _, usage := range userUsage {
 			if usage.AIModelID == limit.AIModelID {
 				usageCount = usage.Count
+				usageTokens = usage.Tokens
 			}
 		}
-		result.WriteString(fmt.Sprintf("%s: (%d/%d)\n", model.Title, usageCount, limit.Count))
+		result.WriteString(fmt.Sprintf("%s: (%d/%s)", model.Title, usageCount, limitToString(int64(limit.Count))))
+		if usageTokens > 0 || limit.Tokens >= 0 {
+			result.WriteString(fmt.Sprintf(", %s: (%d/%s)", tokensLabel, usageTokens, limitToString(limit.Tokens)))
+		}
+		result.WriteString("\n")
 	}
 	return result.String()
 }
 
+func limitToString(limit int64) string {
+	if limit < 0 {
+		return "∞"
+	}
+	return fmt.Sprint(limit)
+}
+
 func (s *Store) CheckUserUsage(user *UserShell, modelID int32) (bool, error) {
 	tariff, ok := s.TariffByID(user.User.TariffID)
 	if !ok {
@@ -327,33 +444,59 @@ func (s *Store) CheckUserUsage(user *UserShell, modelID int32) (bool, error) {
 		}
 	}
 	if limit == nil {
-		return false, fmt.Errorf("CheckUserUsage() limit not found by id %d", modelID)
+		return false, fmt.Errorf("CheckUserUsage(): %w: model id %d", ErrModelNotInTariff, modelID)
 	}
 
-	fUsage, ok := user.Usage.Load(modelID)
-	if !ok {
-		return false, fmt.Errorf("CheckUserUsage() usage not found for model id %d", modelID)
+	// Usage is created on first use of the model
+	usage := &UserUsage{}
+	if fUsage, ok := user.Usage.Load(modelID); ok {
+		usage = fUsage.(*UserUsage)
 	}
-	usage := fUsage.(*UserUsage)
 
-	if usage.Count >= limit.Count {
+	if limit.Count >= 0 && usage.Count >= limit.Count {
+		return false, nil
+	}
+	if limit.Tokens >= 0 && usage.Tokens >= limit.Tokens {
 		return false, nil
 	}
 
 	return true, nil
 }
 
-func (s *Store) UpdateUserUsage(ctx context.Context, user *UserShell, modelID int32) error {
+// UpdateUserUsage counts a request to the model and the tokens it used
+func (s *Store) UpdateUserUsage(ctx context.Context, user *UserShell, modelID int32, tokens int64) error {
+	if err := s.addUserUsage(ctx, user, modelID, 1, tokens); err != nil {
+		return fmt.Errorf("UpdateUserUsage(): %w", err)
+	}
+	return nil
+}
+
+// AddUserUsageTokens counts the tokens of an auxiliary request to the model, e.g. a dialog summary,
+// without counting it as a request of the user
+func (s *Store) AddUserUsageTokens(ctx context.Context, user *UserShell, modelID int32, tokens int64) error {
+	if err := s.addUserUsage(ctx, user, modelID, 0, tokens); err != nil {
+		return fmt.Errorf("AddUserUsageTokens(): %w", err)
+	}
+	return nil
+}
+
+func (s *Store) addUserUsage(ctx context.Context, user *UserShell, modelID int32, count int32, tokens int64) error {
 	fUsage, ok := user.Usage.Load(modelID)
 	if !ok {
-		return fmt.Errorf("UpdateUserUsage() usage not found for model id %d", modelID)
+		usage, err := s.driver.UserUsageCreate(ctx, &UserUsage{UserID: user.ID, AIModelID: modelID})
+		if err != nil {
+			return err
+		}
+		fUsage, _ = user.Usage.LoadOrStore(modelID, usage)
 	}
 	usage := fUsage.(*UserUsage)
-	usage.Count++
+	usage.Count += count
+	usage.Tokens += tokens
 	usage.LastActivity = time.Now().UTC()
 	_, err := s.driver.UserUsageUpdate(ctx, usage)
 	if err != nil {
-		usage.Count--
+		usage.Count -= count
+		usage.Tokens -= tokens
 		return err
 	}
 
@@ -390,11 +533,11 @@ func (s *Store) CheckUserLimits(ctx context.Context, user *UserShell) error {
 	})
 
 	for _, u := range usage {
-		prevCount := u.Count
-		u.Count = 0
+		prevCount, prevTokens := u.Count, u.Tokens
+		u.Count, u.Tokens = 0, 0
 		_, err := s.driver.UserUsageUpdate(ctx, u)
 		if err != nil {
-			u.Count = prevCount
+			u.Count, u.Tokens = prevCount, prevTokens
 			return err
 		}
 	}
@@ -429,6 +572,23 @@ func (s *Store) BlockUser(ctx context.Context, us *UserShell, reason string, adm
 	return nil
 }
 
+func (s *Store) AddModerationFlag(ctx context.Context, flag *ModerationFlag) (*ModerationFlag, error) {
+	flag, err := s.driver.ModerationFlagCreate(ctx, flag)
+	if err != nil {
+		return nil, fmt.Errorf("AddModerationFlag(): %w", err)
+	}
+	return flag, nil
+}
+
+// CountModerationFlags returns the number of the user flags of the source created since the time
+func (s *Store) CountModerationFlags(ctx context.Context, userID int64, source ModerationSource, since time.Time) (int, error) {
+	flags, err := s.driver.ModerationFlagList(ctx, &ModerationFlagFilter{UserID: &userID, Source: &source, Since: &since})
+	if err != nil {
+		return 0, fmt.Errorf("CountModerationFlags(): %w", err)
+	}
+	return len(flags), nil
+}
+
 func (s *Store) UnblockUser(ctx context.Context, us *UserShell) error {
 	us.User.Blocked = false
 	us.User.BlockReason = ""
@@ -470,3 +630,70 @@ func (s *Store) ToggleUserSkipDialog(ctx context.Context, us *UserShell) error {
 	}
 	return nil
 }
+
+// Limits of the generation parameters, the efforts are the values of ai.ChatRequest.ReasoningEffort
+const (
+	MaxTemperature = 2
+	MaxTokensLimit = 32768
+)
+
+var reasoningEfforts = map[string]bool{"low": true, "medium": true, "high": true}
+
+func validateParams(params GenerationParams) error {
+	// Written as negations of the valid ranges, so NaN is rejected too
+	if t := params.Temperature; t != nil && !(*t >= 0 && *t <= MaxTemperature) {
+		return fmt.Errorf("%w: temperature %v", ErrInvalidParams, *t)
+	}
+	if p := params.TopP; p != nil && !(*p > 0 && *p <= 1) {
+		return fmt.Errorf("%w: top_p %v", ErrInvalidParams, *p)
+	}
+	if n := params.MaxTokens; n != nil && (*n <= 0 || *n > MaxTokensLimit) {
+		return fmt.Errorf("%w: max tokens %d", ErrInvalidParams, *n)
+	}
+	if e := params.ReasoningEffort; e != nil && !reasoningEfforts[*e] {
+		return fmt.Errorf("%w: reasoning effort %q", ErrInvalidParams, *e)
+	}
+	return nil
+}
+
+// SetUserParams sets the generation parameters used in all dialogs of the user
+func (s *Store) SetUserParams(ctx context.Context, us *UserShell, params GenerationParams) error {
+	if err := validateParams(params); err != nil {
+		return fmt.Errorf("SetUserParams(): %w", err)
+	}
+
+	prev := us.User.Params
+	us.User.Params = params
+	if _, err := s.driver.UserUpdate(ctx, us.User); err != nil {
+		us.User.Params = prev
+		return fmt.Errorf("SetUserParams(): %w", err)
+	}
+	return nil
+}
+
+// SetDialogParams sets the overrides of the generation parameters in the active dialog
+func (s *Store) SetDialogParams(ctx context.Context, us *UserShell, params GenerationParams) error {
+	if us.Dialog == nil {
+		return fmt.Errorf("SetDialogParams(): %w", ErrNoActiveDialog)
+	}
+	if err := validateParams(params); err != nil {
+		return fmt.Errorf("SetDialogParams(): %w", err)
+	}
+
+	prev := us.Dialog.Params
+	us.Dialog.Params = params
+	if _, err := s.driver.DialogUpdate(ctx, us.Dialog); err != nil {
+		us.Dialog.Params = prev
+		return fmt.Errorf("SetDialogParams(): %w", err)
+	}
+	return nil
+}
+
+func (s *Store) ToggleUserVoiceAnswer(ctx context.Context, us *UserShell) error {
+	us.User.SendVoiceAnswer = !us.User.SendVoiceAnswer
+	_, err := s.driver.UserUpdate(ctx, us.User)
+	if err != nil {
+		return fmt.Errorf("ToggleUserVoiceAnswer(): %w", err)
+	}
+	return nil
+}
@@ -17,6 +17,9 @@ type User struct {
 	Blocked              bool
 	BlockReason          string
 	SkipNewDialogMessage bool
+	SendVoiceAnswer      bool
+	// Generation parameters used in all dialogs of the user
+	Params GenerationParams
 }
 
 type UserFilter struct {
@@ -29,6 +32,7 @@ type UserFilter struct {
 	Blocked              *bool
 	BlockReason          *string
 	SkipNewDialogMessage *bool
+	SendVoiceAnswer      *bool
 }
 
 type Dialog struct {
@@ -36,6 +40,10 @@ type Dialog struct {
 	UserID  int64
 	Title   string
 	Created time.Time
+	// 0 if the dialog has no persona
+	PersonaID int64
+	// Overrides of the user generation parameters
+	Params GenerationParams
 }
 
 type DialogFilter struct {
@@ -58,15 +66,38 @@ const (
 	RoleSystem ChatMessageRole = iota
 	RoleUser
 	RoleAssistant
+	// Content is a JSON array of the tools called by the assistant
+	RoleToolCall
+	// Content is the result of the tool call with ToolCallID
+	RoleToolResult
 )
 
 type ChatMessage struct {
-	ID       int64
-	DialogID int64
-	Order    int
-	Role     ChatMessageRole
-	Content  string
-	Created  time.Time
+	ID               int64
+	DialogID         int64
+	Order            int
+	Role             ChatMessageRole
+	Content          string
+	Created          time.Time
+	PromptTokens     int
+	CompletionTokens int
+	CachedTokens     int
+	ReasoningTokens  int
+	// Summary messages replace all messages with order <= SummaryUntil, -1 for regular messages
+	SummaryUntil int
+	// Telegram file id of the attached image
+	ImageFileID string
+	ToolCallID  string
+	// Model that generated the message, 0 for messages of the user
+	AIModelID int32
+}
+
+func (m *ChatMessage) IsSummary() bool {
+	return m.SummaryUntil >= 0
+}
+
+func (m *ChatMessage) IsTool() bool {
+	return m.Role == RoleToolCall || m.Role == RoleToolResult
 }
 
 type ChatMessageFilter struct {
@@ -78,6 +109,67 @@ type ChatMessageFilter struct {
 	Created  *time.Time
 }
 
+// Persona is a named system prompt of a dialog
+type Persona struct {
+	ID int64
+	// 0 for built-in personas
+	UserID  int64
+	Title   string
+	Prompt  string
+	Created time.Time
+}
+
+type PersonaFilter struct {
+	ID *int64
+	// Personas of any of the users, 0 selects the built-in personas
+	UserIDs []int64
+}
+
+// Attachment is a file sent by the user, its text is added to the content of the chat message
+type Attachment struct {
+	ID            int64
+	ChatMessageID int64
+	// Telegram file id
+	FileID   string
+	FileName string
+	MIMEType string
+	Size     int64
+	// Only the beginning of the text was added to the chat message
+	Truncated bool
+	Created   time.Time
+}
+
+type AttachmentFilter struct {
+	ID            *int64
+	ChatMessageID *int64
+	FileID        *string
+}
+
+type ModerationSource int
+
+const (
+	ModerationSourceInput ModerationSource = iota
+	ModerationSourceAnswer
+)
+
+// ModerationFlag is a message of the user or an answer flagged by the moderation
+type ModerationFlag struct {
+	ID     int64
+	UserID int64
+	Source ModerationSource
+	// Flagged categories separated by commas
+	Categories string
+	Content    string
+	Created    time.Time
+}
+
+type ModerationFlagFilter struct {
+	UserID *int64
+	Source *ModerationSource
+	// Flags created at this time or later
+	Since *time.Time
+}
+
 type ActiveDialog struct {
 	UserID   int64
 	DialogID int64
@@ -93,13 +185,78 @@ type AiModelType int
 const (
 	TypeChat AiModelType = iota
 	TypeGenerateImage
+	TypeSpeechToText
+	TypeTextToSpeech
+)
+
+type ModelCapability int64
+
+const (
+	CapVision ModelCapability = 1 << iota
+	CapTools
+	// Temperature and top_p can be changed
+	CapSampling
+	// Reasoning effort can be changed
+	CapReasoning
 )
 
 type AiModel struct {
-	ID        int32
-	Title     string
-	APIName   string
-	ModelType AiModelType
+	ID           int32
+	Title        string
+	APIName      string
+	ModelType    AiModelType
+	Provider     string
+	Capabilities ModelCapability
+	// Models tried in this order when the provider of the model fails
+	Fallbacks []int32
+}
+
+func (m *AiModel) Supports(capability ModelCapability) bool {
+	return m.Capabilities&capability == capability
+}
+
+// GenerationParams are the parameters of chat model requests, nil leaves the value unset
+type GenerationParams struct {
+	Temperature     *float64
+	TopP            *float64
+	MaxTokens       *int
+	ReasoningEffort *string
+}
+
+// Override returns the parameters with the values set in o replaced
+func (p GenerationParams) Override(o GenerationParams) GenerationParams {
+	if o.Temperature != nil {
+		p.Temperature = o.Temperature
+	}
+	if o.TopP != nil {
+		p.TopP = o.TopP
+	}
+	if o.MaxTokens != nil {
+		p.MaxTokens = o.MaxTokens
+	}
+	if o.ReasoningEffort != nil {
+		p.ReasoningEffort = o.ReasoningEffort
+	}
+	return p
+}
+
+// Supported returns the parameters the model can use, the rest are unset
+func (p GenerationParams) Supported(model *AiModel) GenerationParams {
+	if !model.Supports(CapSampling) {
+		p.Temperature = nil
+		p.TopP = nil
+	}
+	if !model.Supports(CapReasoning) {
+		p.ReasoningEffort = nil
+	}
+	return p
+}
+
+type AiModelFallback struct {
+	ID         int64
+	AIModelID  int32
+	FallbackID int32
+	Position   int
 }
 
 type Tariff struct {
@@ -118,11 +275,13 @@ type TariffFilter struct {
 	Available *bool
 }
 
+// TariffLimit count or tokens -1 means no limit
 type TariffLimit struct {
 	ID        int32
 	TariffID  int32
 	AIModelID int32
 	Count     int32
+	Tokens    int64
 }
 
 type TariffLimitFilter struct {
@@ -130,6 +289,7 @@ type TariffLimitFilter struct {
 	TariffID  *int32
 	AIModelID *int32
 	Count     *int32
+	Tokens    *int64
 }
 
 type UserUsage struct {
@@ -137,6 +297,7 @@ type UserUsage struct {
 	AIModelID    int32
 	Count        int32
 	LastActivity time.Time
+	Tokens       int64
 }
 
 type UserUsageFilter struct {
@@ -144,4 +305,5 @@ type UserUsageFilter struct {
 	AIModelID    *int32
 	Count        *int32
 	LastActivity *time.Time
+	Tokens       *int64
 }
@@ -6,13 +6,26 @@ import (
 )
 
 type UserShell struct {
-	User           *User
-	ID             int64
-	Dialog         *Dialog
-	Context        []*ChatMessage
-	LastText       string
-	InfoMessageID  int
-	Locale         string
-	LastLimitReset time.Time
-	Usage          sync.Map // [int32 modelId]*UserUsage
+	User                *User
+	ID                  int64
+	Dialog              *Dialog
+	Context             []*ChatMessage
+	LastText            string
+	LastImageFileID     string
+	LastAttachment      *Attachment // document of the pending message, saved once the message is answered
+	InfoMessageID       int
+	Locale              string
+	LastLimitReset      time.Time
+	Usage               sync.Map // [int32 modelId]*UserUsage
+	AwaitingImagePrompt bool     // next text message is a prompt for image generation
+	NextPersonaID       int64    // persona of the next dialog, chosen while there is no active dialog
+}
+
+// GenerationParams returns the parameters of the user with the overrides of the active dialog
+func (us *UserShell) GenerationParams() GenerationParams {
+	params := us.User.Params
+	if us.Dialog != nil {
+		params = params.Override(us.Dialog.Params)
+	}
+	return params
 }
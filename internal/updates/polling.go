@@ -0,0 +1,36 @@
+package updates
+
+import (
+	"context"
+	"fmt"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Seconds a getUpdates request waits for new updates
+const pollingTimeout = 60
+
+// Polling gets the updates with getUpdates long polling
+type Polling struct {
+	bot *tgbotapi.BotAPI
+}
+
+func NewPolling(bot *tgbotapi.BotAPI) *Polling {
+	return &Polling{bot: bot}
+}
+
+func (p *Polling) Start() (<-chan tgbotapi.Update, error) {
+	// getUpdates fails while a webhook is set, e.g. after the bot ran in webhook mode
+	if _, err := p.bot.Request(tgbotapi.DeleteWebhookConfig{}); err != nil {
+		return nil, fmt.Errorf("Polling.Start(): %w", err)
+	}
+
+	uConf := tgbotapi.NewUpdate(0)
+	uConf.Timeout = pollingTimeout
+	return p.bot.GetUpdatesChan(uConf), nil
+}
+
+func (p *Polling) Stop(_ context.Context) error {
+	p.bot.StopReceivingUpdates()
+	return nil
+}
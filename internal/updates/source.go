@@ -0,0 +1,15 @@
+package updates
+
+import (
+	"context"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Source delivers the updates of the bot, either polled with getUpdates or received by a webhook
+type Source interface {
+	// Start begins receiving the updates. The channel is closed after Stop
+	Start() (<-chan tgbotapi.Update, error)
+	// Stop stops receiving the updates, the updates already received stay in the channel
+	Stop(ctx context.Context) error
+}
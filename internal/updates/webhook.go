@@ -0,0 +1,186 @@
+package updates
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"tgbot/internal/lib/logger/sl"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	SecretTokenHeader = "X-Telegram-Bot-Api-Secret-Token"
+	// Updates are far smaller, bigger bodies are rejected
+	maxUpdateSize     = 1 << 20
+	readHeaderTimeout = 10 * time.Second
+)
+
+type WebhookConfig struct {
+	// Public HTTPS URL set with setWebhook
+	URL string
+	// Address the server listens on, e.g. ":8443"
+	Listen string
+	// Path the server handles
+	Path        string
+	SecretToken string
+	// The server serves TLS if both are set, plain HTTP behind a reverse proxy otherwise
+	CertFile string
+	KeyFile  string
+	// CertFile is uploaded with setWebhook
+	SelfSigned         bool
+	MaxConnections     int
+	DropPendingUpdates bool
+}
+
+// Webhook receives the updates with an HTTP server and sets the webhook at start
+// and deletes it at stop
+type Webhook struct {
+	bot     *tgbotapi.BotAPI
+	cfg     WebhookConfig
+	log     *slog.Logger
+	server  *http.Server
+	updates chan tgbotapi.Update
+	// Closed by Stop to release the handlers waiting for space in updates
+	done     chan struct{}
+	stopOnce sync.Once
+	// Handlers sending to updates, Stop closes updates after they return. The server shutdown
+	// doesn't wait for them once its context is done.
+	mu       sync.Mutex
+	stopped  bool
+	handlers sync.WaitGroup
+}
+
+func NewWebhook(bot *tgbotapi.BotAPI, cfg WebhookConfig, log *slog.Logger) *Webhook {
+	wh := &Webhook{
+		bot:     bot,
+		cfg:     cfg,
+		log:     log,
+		updates: make(chan tgbotapi.Update, bot.Buffer),
+		done:    make(chan struct{}),
+	}
+	wh.server = &http.Server{
+		Addr:              cfg.Listen,
+		Handler:           wh,
+		ReadHeaderTimeout: readHeaderTimeout,
+	}
+	return wh
+}
+
+func (wh *Webhook) Start() (<-chan tgbotapi.Update, error) {
+	method := "Webhook.Start()"
+	ln, err := net.Listen("tcp", wh.cfg.Listen)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", method, err)
+	}
+
+	go func() {
+		var err error
+		if wh.cfg.CertFile != "" {
+			err = wh.server.ServeTLS(ln, wh.cfg.CertFile, wh.cfg.KeyFile)
+		} else {
+			err = wh.server.Serve(ln)
+		}
+		if !errors.Is(err, http.ErrServerClosed) {
+			wh.log.Error("Webhook server stopped", sl.Err(err))
+		}
+	}()
+
+	if err := wh.setWebhook(); err != nil {
+		_ = wh.server.Close()
+		return nil, fmt.Errorf("%s: %w", method, err)
+	}
+	wh.log.Info("Webhook set",
+		slog.Attr{Key: "url", Value: slog.StringValue(wh.cfg.URL)},
+		slog.Attr{Key: "listen", Value: slog.StringValue(wh.cfg.Listen)})
+	return wh.updates, nil
+}
+
+// Stop deletes the webhook, so Telegram keeps the next updates until the bot starts again,
+// and shuts the server down
+func (wh *Webhook) Stop(ctx context.Context) error {
+	var err error
+	wh.stopOnce.Do(func() {
+		_, deleteErr := wh.bot.Request(tgbotapi.DeleteWebhookConfig{})
+		wh.mu.Lock()
+		wh.stopped = true
+		wh.mu.Unlock()
+		close(wh.done)
+		err = errors.Join(deleteErr, wh.server.Shutdown(ctx))
+		// Handlers return at once after done is closed
+		wh.handlers.Wait()
+		close(wh.updates)
+	})
+	if err != nil {
+		return fmt.Errorf("Webhook.Stop(): %w", err)
+	}
+	return nil
+}
+
+// setWebhook calls setWebhook directly, tgbotapi.WebhookConfig has no secret token
+func (wh *Webhook) setWebhook() error {
+	params := tgbotapi.Params{"url": wh.cfg.URL}
+	params.AddNonEmpty("secret_token", wh.cfg.SecretToken)
+	params.AddNonZero("max_connections", wh.cfg.MaxConnections)
+	params.AddBool("drop_pending_updates", wh.cfg.DropPendingUpdates)
+
+	var err error
+	if wh.cfg.SelfSigned {
+		files := []tgbotapi.RequestFile{{Name: "certificate", Data: tgbotapi.FilePath(wh.cfg.CertFile)}}
+		_, err = wh.bot.UploadFiles("setWebhook", params, files)
+	} else {
+		_, err = wh.bot.MakeRequest("setWebhook", params)
+	}
+	return err
+}
+
+func (wh *Webhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != wh.cfg.Path {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if wh.cfg.SecretToken != "" &&
+		subtle.ConstantTimeCompare([]byte(r.Header.Get(SecretTokenHeader)), []byte(wh.cfg.SecretToken)) != 1 {
+		wh.log.Warn("Webhook request with a wrong secret token",
+			slog.Attr{Key: "remote", Value: slog.StringValue(r.RemoteAddr)})
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	var update tgbotapi.Update
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxUpdateSize)).Decode(&update); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	wh.mu.Lock()
+	if wh.stopped {
+		wh.mu.Unlock()
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
+	wh.handlers.Add(1)
+	wh.mu.Unlock()
+	defer wh.handlers.Done()
+
+	// Telegram resends the update if it isn't answered with 2xx
+	select {
+	case wh.updates <- update:
+		w.WriteHeader(http.StatusOK)
+	case <-wh.done:
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+	case <-r.Context().Done():
+	}
+}
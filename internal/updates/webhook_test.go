@@ -0,0 +1,182 @@
+package updates
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// botAPIStub answers the Bot API requests and keeps their parameters by method
+type botAPIStub struct {
+	mu    sync.Mutex
+	calls map[string]url.Values
+}
+
+func newTestBot(t *testing.T) (*tgbotapi.BotAPI, *botAPIStub) {
+	t.Helper()
+	stub := &botAPIStub{calls: map[string]url.Values{}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseMultipartForm(1 << 20)
+		method := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+		stub.mu.Lock()
+		stub.calls[method] = r.Form
+		stub.mu.Unlock()
+		if method == "getMe" {
+			_, _ = io.WriteString(w, `{"ok":true,"result":{"id":1,"is_bot":true,"first_name":"bot","username":"bot"}}`)
+			return
+		}
+		_, _ = io.WriteString(w, `{"ok":true,"result":true}`)
+	}))
+	t.Cleanup(srv.Close)
+
+	bot, err := tgbotapi.NewBotAPIWithAPIEndpoint("token", srv.URL+"/bot%s/%s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bot, stub
+}
+
+func (s *botAPIStub) call(method string) (url.Values, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	params, ok := s.calls[method]
+	return params, ok
+}
+
+func newTestWebhook(t *testing.T, buffer int) (*Webhook, *botAPIStub) {
+	t.Helper()
+	bot, stub := newTestBot(t)
+	bot.Buffer = buffer
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	return NewWebhook(bot, WebhookConfig{
+		URL:         "https://bot.example.com/tg/webhook",
+		Listen:      "127.0.0.1:0",
+		Path:        "/tg/webhook",
+		SecretToken: "secret_123",
+	}, log), stub
+}
+
+func TestWebhookServeHTTP(t *testing.T) {
+	const update = `{"update_id":7,"message":{"message_id":1,"date":0,"chat":{"id":42},"text":"hi"}}`
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		secret string
+		body   string
+		want   int
+	}{
+		{name: "update", method: http.MethodPost, path: "/tg/webhook", secret: "secret_123", body: update, want: http.StatusOK},
+		{name: "wrong path", method: http.MethodPost, path: "/other", secret: "secret_123", body: update, want: http.StatusNotFound},
+		{name: "wrong method", method: http.MethodGet, path: "/tg/webhook", secret: "secret_123", want: http.StatusMethodNotAllowed},
+		{name: "no secret", method: http.MethodPost, path: "/tg/webhook", body: update, want: http.StatusUnauthorized},
+		{name: "wrong secret", method: http.MethodPost, path: "/tg/webhook", secret: "secret_124", body: update, want: http.StatusUnauthorized},
+		{name: "bad body", method: http.MethodPost, path: "/tg/webhook", secret: "secret_123", body: "{", want: http.StatusBadRequest},
+		{name: "too large", method: http.MethodPost, path: "/tg/webhook", secret: "secret_123",
+			body: `{"update_id":1,"x":"` + strings.Repeat("a", maxUpdateSize) + `"}`, want: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wh, _ := newTestWebhook(t, 1)
+			r := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.secret != "" {
+				r.Header.Set(SecretTokenHeader, tt.secret)
+			}
+			w := httptest.NewRecorder()
+			wh.ServeHTTP(w, r)
+
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d", w.Code, tt.want)
+			}
+			select {
+			case got := <-wh.updates:
+				if tt.want != http.StatusOK || got.UpdateID != 7 || got.Message.Text != "hi" {
+					t.Errorf("update %+v received", got)
+				}
+			default:
+				if tt.want == http.StatusOK {
+					t.Error("update is not received")
+				}
+			}
+		})
+	}
+}
+
+func TestWebhookStartStop(t *testing.T) {
+	wh, stub := newTestWebhook(t, 1)
+	updates, err := wh.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	params, ok := stub.call("setWebhook")
+	if !ok || params.Get("url") != wh.cfg.URL || params.Get("secret_token") != wh.cfg.SecretToken {
+		t.Fatalf("setWebhook params = %v", params)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := wh.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := stub.call("deleteWebhook"); !ok {
+		t.Error("webhook is not deleted")
+	}
+	if _, ok := <-updates; ok {
+		t.Error("updates channel is not closed")
+	}
+	if err := wh.Stop(ctx); err != nil {
+		t.Errorf("second Stop() = %v", err)
+	}
+}
+
+// Handlers still decoding when Stop gives up waiting for the server must not send to the closed channel
+func TestWebhookStopWithRunningHandlers(t *testing.T) {
+	wh, _ := newTestWebhook(t, 1)
+
+	var wg sync.WaitGroup
+	codes := make(chan int, 20)
+	bodies := make([]*io.PipeWriter, 0, cap(codes))
+	for range cap(codes) {
+		body, bodyWriter := io.Pipe()
+		bodies = append(bodies, bodyWriter)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := httptest.NewRequest(http.MethodPost, "/tg/webhook", body)
+			r.Header.Set(SecretTokenHeader, "secret_123")
+			w := httptest.NewRecorder()
+			wh.ServeHTTP(w, r)
+			codes <- w.Code
+		}()
+	}
+
+	// The deadline is already over, like a shutdown that ran out of time
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_ = wh.Stop(ctx)
+	for _, bodyWriter := range bodies {
+		_, _ = io.WriteString(bodyWriter, `{"update_id":1}`)
+		_ = bodyWriter.Close()
+	}
+	wg.Wait()
+	close(codes)
+
+	for range wh.updates {
+		t.Error("update received after Stop")
+	}
+	for code := range codes {
+		if code != http.StatusServiceUnavailable {
+			t.Errorf("status %d, want %d", code, http.StatusServiceUnavailable)
+		}
+	}
+}
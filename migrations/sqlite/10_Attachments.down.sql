@@ -0,0 +1,2 @@
+DROP INDEX attachmentsChatMessageId;
+DROP TABLE attachments;
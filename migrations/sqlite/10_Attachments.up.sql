@@ -0,0 +1,13 @@
+CREATE TABLE attachments (
+    id INTEGER PRIMARY KEY,
+    chatMessageId INTEGER NOT NULL,
+    fileId TEXT NOT NULL,
+    fileName TEXT NOT NULL,
+    mimeType TEXT NOT NULL,
+    size INTEGER NOT NULL,
+    truncated BOOLEAN NOT NULL DEFAULT 0,
+    created TEXT,
+    FOREIGN KEY (chatMessageId) REFERENCES chatMessages(id) ON DELETE CASCADE
+);
+
+CREATE INDEX attachmentsChatMessageId ON attachments(chatMessageId);
@@ -0,0 +1,4 @@
+UPDATE aiModels SET capabilities = capabilities & ~2;
+
+DELETE FROM chatMessages WHERE "role" IN (3, 4);
+ALTER TABLE chatMessages DROP COLUMN toolCallId;
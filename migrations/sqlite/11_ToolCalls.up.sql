@@ -0,0 +1,4 @@
+-- Call answered by a tool result message
+ALTER TABLE chatMessages ADD COLUMN toolCallId TEXT NOT NULL DEFAULT '';
+
+UPDATE aiModels SET capabilities = capabilities | 2 WHERE apiName = 'o4-mini';
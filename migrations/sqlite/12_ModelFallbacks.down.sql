@@ -0,0 +1,3 @@
+ALTER TABLE chatMessages DROP COLUMN aiModelId;
+
+DROP TABLE IF EXISTS aiModelFallbacks;
@@ -0,0 +1,16 @@
+-- Models tried in order of position when the model's provider fails
+CREATE TABLE IF NOT EXISTS aiModelFallbacks (
+    id INTEGER PRIMARY KEY,
+    aiModelId INTEGER NOT NULL,
+    fallbackId INTEGER NOT NULL,
+    position INTEGER NOT NULL,
+    FOREIGN KEY (aiModelId) REFERENCES aiModels(id) ON DELETE CASCADE,
+    FOREIGN KEY (fallbackId) REFERENCES aiModels(id) ON DELETE CASCADE
+);
+
+-- Model that generated the message, 0 for messages of the user
+ALTER TABLE chatMessages ADD COLUMN aiModelId INTEGER NOT NULL DEFAULT 0;
+
+-- No fallbacks are set up, a chain calling another paid provider must be added on purpose
+-- with the models it needs, e.g.
+-- INSERT INTO aiModelFallbacks (aiModelId, fallbackId, position) VALUES (1, 5, 1);
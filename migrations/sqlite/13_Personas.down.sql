@@ -0,0 +1,4 @@
+ALTER TABLE dialogs DROP COLUMN personaId;
+
+DROP INDEX IF EXISTS personasUserId;
+DROP TABLE IF EXISTS personas;
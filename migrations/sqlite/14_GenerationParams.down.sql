@@ -0,0 +1,11 @@
+UPDATE aiModels SET capabilities = capabilities & ~12;
+
+ALTER TABLE dialogs DROP COLUMN reasoningEffort;
+ALTER TABLE dialogs DROP COLUMN maxTokens;
+ALTER TABLE dialogs DROP COLUMN topP;
+ALTER TABLE dialogs DROP COLUMN temperature;
+
+ALTER TABLE users DROP COLUMN reasoningEffort;
+ALTER TABLE users DROP COLUMN maxTokens;
+ALTER TABLE users DROP COLUMN topP;
+ALTER TABLE users DROP COLUMN temperature;
@@ -0,0 +1,14 @@
+-- Generation parameters of the user, NULL leaves the default of the model
+ALTER TABLE users ADD COLUMN temperature REAL;
+ALTER TABLE users ADD COLUMN topP REAL;
+ALTER TABLE users ADD COLUMN maxTokens INTEGER;
+ALTER TABLE users ADD COLUMN reasoningEffort TEXT;
+
+-- Overrides of the user parameters in the dialog, NULL keeps the parameter of the user
+ALTER TABLE dialogs ADD COLUMN temperature REAL;
+ALTER TABLE dialogs ADD COLUMN topP REAL;
+ALTER TABLE dialogs ADD COLUMN maxTokens INTEGER;
+ALTER TABLE dialogs ADD COLUMN reasoningEffort TEXT;
+
+-- 4 is sampling (temperature and top_p), 8 is reasoning effort
+UPDATE aiModels SET capabilities = capabilities | 8 WHERE apiName = 'o4-mini';
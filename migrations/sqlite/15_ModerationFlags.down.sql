@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS moderationFlagsUserId;
+DROP TABLE IF EXISTS moderationFlags;
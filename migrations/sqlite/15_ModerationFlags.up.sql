@@ -0,0 +1,14 @@
+-- Messages of users and answers flagged by the moderation
+CREATE TABLE moderationFlags (
+    id INTEGER PRIMARY KEY,
+    userId INTEGER NOT NULL,
+    -- 0 is the message of the user, 1 is the answer of the model
+    source INTEGER NOT NULL,
+    -- Flagged categories separated by commas
+    categories TEXT NOT NULL,
+    content TEXT NOT NULL,
+    created TEXT,
+    FOREIGN KEY (userId) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX moderationFlagsUserId ON moderationFlags(userId);
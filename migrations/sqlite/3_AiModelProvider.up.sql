@@ -0,0 +1 @@
+ALTER TABLE aiModels ADD COLUMN provider TEXT NOT NULL DEFAULT 'openai';
@@ -0,0 +1,6 @@
+ALTER TABLE tariffLimits DROP COLUMN tokens;
+ALTER TABLE usersUsage DROP COLUMN tokens;
+ALTER TABLE chatMessages DROP COLUMN reasoningTokens;
+ALTER TABLE chatMessages DROP COLUMN cachedTokens;
+ALTER TABLE chatMessages DROP COLUMN completionTokens;
+ALTER TABLE chatMessages DROP COLUMN promptTokens;
@@ -0,0 +1,7 @@
+ALTER TABLE chatMessages ADD COLUMN promptTokens INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE chatMessages ADD COLUMN completionTokens INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE chatMessages ADD COLUMN cachedTokens INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE chatMessages ADD COLUMN reasoningTokens INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE usersUsage ADD COLUMN tokens INTEGER NOT NULL DEFAULT 0;
+-- -1 means no token limit
+ALTER TABLE tariffLimits ADD COLUMN tokens INTEGER NOT NULL DEFAULT -1;
@@ -0,0 +1 @@
+ALTER TABLE chatMessages DROP COLUMN summaryUntil;
@@ -0,0 +1,2 @@
+-- Order of the last message folded into the summary, -1 for regular messages
+ALTER TABLE chatMessages ADD COLUMN summaryUntil INTEGER NOT NULL DEFAULT -1;
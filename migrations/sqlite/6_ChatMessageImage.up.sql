@@ -0,0 +1,2 @@
+-- Telegram file id of the image attached to the message
+ALTER TABLE chatMessages ADD COLUMN imageFileId TEXT NOT NULL DEFAULT '';
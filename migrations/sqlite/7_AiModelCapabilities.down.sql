@@ -0,0 +1 @@
+ALTER TABLE aiModels DROP COLUMN capabilities;
@@ -0,0 +1,4 @@
+-- Bit mask of store.ModelCapability
+ALTER TABLE aiModels ADD COLUMN capabilities INTEGER NOT NULL DEFAULT 0;
+
+UPDATE aiModels SET capabilities = 1 WHERE apiName = 'o4-mini';
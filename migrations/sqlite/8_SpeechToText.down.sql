@@ -0,0 +1,3 @@
+DELETE FROM tariffLimits WHERE aiModelId = 3;
+DELETE FROM usersUsage WHERE aiModelId = 3;
+DELETE FROM aiModels WHERE id = 3;
@@ -0,0 +1,7 @@
+INSERT INTO aiModels (id, title, apiName, modelType, provider) VALUES
+(3, 'OpenAI whisper-1', 'whisper-1', 2, 'openai');
+
+INSERT INTO tariffLimits (aiModelId, tariffId, count) VALUES
+(3, 1, 10),
+(3, 2, 100),
+(3, 3, -1);
@@ -0,0 +1,5 @@
+DELETE FROM tariffLimits WHERE aiModelId = 4;
+DELETE FROM usersUsage WHERE aiModelId = 4;
+DELETE FROM aiModels WHERE id = 4;
+
+ALTER TABLE users DROP COLUMN sendVoiceAnswer;
@@ -0,0 +1,9 @@
+ALTER TABLE users ADD COLUMN sendVoiceAnswer BOOLEAN NOT NULL DEFAULT 0;
+
+INSERT INTO aiModels (id, title, apiName, modelType, provider) VALUES
+(4, 'OpenAI gpt-4o-mini-tts', 'gpt-4o-mini-tts', 3, 'openai');
+
+INSERT INTO tariffLimits (aiModelId, tariffId, count) VALUES
+(4, 1, 10),
+(4, 2, 100),
+(4, 3, -1);